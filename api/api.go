@@ -1,26 +1,25 @@
 package api
 
 import (
-	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
 
 	"github.com/cosmos/gaia/app"
-	"github.com/cosmos/cosmos-sdk/codec"
-	sdk "github.com/cosmos/cosmos-sdk/types"
 	"github.com/gorilla/mux"
-	cmn "github.com/tendermint/tendermint/libs/common"
-	rpcclient "github.com/tendermint/tendermint/rpc/client"
+	"github.com/tendermint/tendermint/libs/log"
 )
 
 const (
-	maxValidAccountValue = int(0x80000000 - 1)
-	maxValidIndexalue    = int(0x80000000 - 1)
+	maxValidAccountValue  = int(0x80000000 - 1)
+	maxValidIndexalue     = int(0x80000000 - 1)
+	maxValidCoinTypeValue = int(0x80000000 - 1)
 )
 
-var cdc *codec.Codec
+// defaultMnemonicWords is the length of generated mnemonics unless configured otherwise
+const defaultMnemonicWords = 24
 
-func init() {
-	cdc = app.MakeCodec()
-}
+var cdc = app.MakeCodec()
 
 // Server represents the API server
 type Server struct {
@@ -28,48 +27,89 @@ type Server struct {
 	KeyDir string `json:"key_dir"`
 	Node   string `json:"node"`
 
-	Version string `yaml:"version,omitempty"`
-	Commit  string `yaml:"commit,omitempty"`
-	Branch  string `yaml:"branch,omitempty"`
+	Chains []Chain `json:"chains,omitempty"`
+
+	Keystore KeystoreConfig `json:"keystore"`
+	Vault    VaultConfig    `json:"vault,omitempty"`
+	HSM      HSMConfig      `json:"hsm,omitempty"`
+
+	MnemonicWords int `json:"mnemonic_words,omitempty"`
+
+	LogLevel  string `json:"log_level"`
+	LogFormat string `json:"log_format"`
+
+	Version string `json:"-" yaml:"version,omitempty"`
+	Commit  string `json:"-" yaml:"commit,omitempty"`
+	Branch  string `json:"-" yaml:"branch,omitempty"`
+
+	logger   log.Logger
+	keystore Keystore
+
+	// current is the *Server requests are served with once the config has
+	// been reloaded, mu serializes the reloads
+	current atomic.Value
+	mu      sync.Mutex
+	reloads reloadStats
 }
 
 // Router returns the router
 func (s *Server) Router() *mux.Router {
 	router := mux.NewRouter()
+	router.Use(s.requestMiddleware)
 
-	router.HandleFunc("/version", s.VersionHandler).Methods("GET")
-	router.HandleFunc("/keys", s.GetKeys).Methods("GET")
-	router.HandleFunc("/keys", s.PostKeys).Methods("POST")
-	router.HandleFunc("/keys/{name}", s.GetKey).Methods("GET")
-	router.HandleFunc("/keys/{name}", s.PutKey).Methods("PUT")
-	router.HandleFunc("/keys/{name}", s.DeleteKey).Methods("DELETE")
-	router.HandleFunc("/tx/sign", s.Sign).Methods("POST")
-	router.HandleFunc("/tx/broadcast", s.Broadcast).Methods("POST")
-	router.HandleFunc("/tx/bank/send", s.BankSend).Methods("POST")
+	router.HandleFunc("/version", s.handle((*Server).VersionHandler)).Methods("GET")
+	router.HandleFunc("/healthz", s.handle((*Server).Healthz)).Methods("GET")
+	router.HandleFunc("/readyz", s.handle((*Server).Readyz)).Methods("GET")
+	router.HandleFunc("/metrics", s.Metrics).Methods("GET")
+	router.HandleFunc("/openapi.json", s.handle((*Server).OpenAPI)).Methods("GET")
+	router.HandleFunc("/docs", s.handle((*Server).Docs)).Methods("GET")
+	router.HandleFunc("/keys", s.handle((*Server).GetKeys)).Methods("GET")
+	router.HandleFunc("/keys", s.handle((*Server).PostKeys)).Methods("POST")
+	router.HandleFunc("/keys/{name}", s.handle((*Server).GetKey)).Methods("GET")
+	router.HandleFunc("/keys/{name}", s.handle((*Server).PutKey)).Methods("PUT")
+	router.HandleFunc("/keys/{name}", s.handle((*Server).PatchKey)).Methods("PATCH")
+	router.HandleFunc("/keys/{name}", s.handle((*Server).DeleteKey)).Methods("DELETE")
+	router.HandleFunc("/keys/import", s.handle((*Server).ImportKey)).Methods("POST")
+	router.HandleFunc("/keys/offline", s.handle((*Server).PostOfflineKey)).Methods("POST")
+	router.HandleFunc("/keys/derive", s.handle((*Server).DeriveKeys)).Methods("POST")
+	router.HandleFunc("/keys/remote", s.handle((*Server).PostRemoteKey)).Methods("POST")
+	router.HandleFunc("/keys/multi", s.handle((*Server).PostMultiKey)).Methods("POST")
+	router.HandleFunc("/keys/{name}/export", s.handle((*Server).ExportKey)).Methods("POST")
+	router.HandleFunc("/keys/{name}/rename", s.handle((*Server).RenameKey)).Methods("POST")
+	router.HandleFunc("/keys/{name}/backup/confirm", s.handle((*Server).ConfirmBackup)).Methods("POST")
+	router.HandleFunc("/keys/{name}/backup/reveal", s.handle((*Server).RevealMnemonic)).Methods("POST")
+	router.HandleFunc("/tx/sign", s.handle((*Server).Sign)).Methods("POST")
+	router.HandleFunc("/tx/verify", s.handle((*Server).Verify)).Methods("POST")
+	router.HandleFunc("/tx/broadcast", s.handle((*Server).Broadcast)).Methods("POST")
+	router.HandleFunc("/tx/bank/send", s.handle((*Server).BankSend)).Methods("POST")
 
 	return router
 }
 
-// SimulateGas simulates gas for a transaction
-func (s *Server) SimulateGas(txbytes []byte) (res uint64, err error) {
-	result, err := rpcclient.NewHTTP(s.Node, "/websocket").ABCIQueryWithOptions(
-		"/app/simulate",
-		cmn.HexBytes(txbytes),
-		rpcclient.ABCIQueryOptions{},
-	)
-
-	if err != nil {
-		return
+// handle serves h with the config loaded for the request, a reload while it
+// is served doesn't change the config it sees
+func (s *Server) handle(h func(*Server, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rs, ok := r.Context().Value(requestStateKey).(*requestState); ok {
+			h(rs.config, w, r)
+			return
+		}
+		h(s.config(), w, r)
 	}
+}
 
-	if !result.Response.IsOK() {
-		return 0, errors.New(result.Response.Log)
+// config returns the server with the current config, s itself until the
+// config is reloaded
+func (s *Server) config() *Server {
+	if cfg, ok := s.current.Load().(*Server); ok {
+		return cfg
 	}
+	return s
+}
 
-	var simulationResult sdk.Result
-	if err := cdc.UnmarshalBinaryLengthPrefixed(result.Response.Value, &simulationResult); err != nil {
-		return 0, err
+func (s *Server) mnemonicWords() int {
+	if s.MnemonicWords == 0 {
+		return defaultMnemonicWords
 	}
-
-	return simulationResult.GasUsed, nil
+	return s.MnemonicWords
 }
@@ -2,15 +2,46 @@ package api
 
 import (
 	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/btcec"
 	ckeys "github.com/cosmos/cosmos-sdk/crypto/keys"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+	"github.com/cosmos/cosmos-sdk/x/bank"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/require"
+	amino "github.com/tendermint/go-amino"
+	abci "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+	"github.com/tendermint/tendermint/crypto/secp256k1"
+	"github.com/tendermint/tendermint/crypto/tmhash"
+	"github.com/tendermint/tendermint/libs/bech32"
+	"github.com/tendermint/tendermint/libs/log"
+	"github.com/tendermint/tendermint/p2p"
+	"github.com/tendermint/tendermint/privval"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	rpctypes "github.com/tendermint/tendermint/rpc/lib/types"
+	"github.com/tendermint/tendermint/types"
+	dbm "github.com/tendermint/tm-db"
 )
 
 const (
@@ -85,6 +116,1405 @@ func TestGetKeys(t *testing.T) {
 	require.Empty(t, happyPath)
 }
 
+func TestHealth(t *testing.T) {
+	server := setup(t)
+	defer server.Close()
+
+	// test liveness doesn't depend on the node
+	getRoute(t, fmt.Sprintf("%s/healthz", server.URL), 200)
+
+	// test readiness fails without a node
+	var rd Readiness
+	require.NoError(t, json.Unmarshal(getRoute(t, fmt.Sprintf("%s/readyz", server.URL), 503), &rd))
+	require.False(t, rd.Ready)
+	require.True(t, rd.Keybase.OK)
+	require.False(t, rd.Node.OK)
+	require.NotEmpty(t, rd.Node.Error)
+
+	// test readiness against a synced node
+	status := &ctypes.ResultStatus{
+		NodeInfo: p2p.DefaultNodeInfo{Network: "testing"},
+		SyncInfo: ctypes.SyncInfo{LatestBlockHeight: 42},
+	}
+	node := mockNode(t, map[string]interface{}{"status": status})
+	defer node.Close()
+
+	s := &Server{KeyDir: tempDir(t), Node: node.URL}
+	ready := httptest.NewServer(s.Router())
+	defer ready.Close()
+
+	require.NoError(t, json.Unmarshal(getRoute(t, fmt.Sprintf("%s/readyz", ready.URL), 200), &rd))
+	require.True(t, rd.Ready)
+	require.Equal(t, "testing", rd.Node.ChainID)
+	require.Equal(t, int64(42), rd.Node.LatestBlockHeight)
+
+	// test readiness fails while the node is catching up
+	status.SyncInfo.CatchingUp = true
+	require.NoError(t, json.Unmarshal(getRoute(t, fmt.Sprintf("%s/readyz", ready.URL), 503), &rd))
+	require.True(t, rd.Node.CatchingUp)
+
+	// test hung nodes time out and the chains are checked at once
+	hang := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hang }))
+	defer hung.Close()
+	defer close(hang)
+	defer func(timeout time.Duration) { readyTimeout = timeout }(readyTimeout)
+	readyTimeout = 500 * time.Millisecond
+	s = &Server{KeyDir: tempDir(t), Chains: []Chain{
+		{Name: "a", Node: hung.URL, Bech32Prefix: "cosmos", CoinType: 118},
+		{Name: "b", Node: hung.URL, Bech32Prefix: "cosmos", CoinType: 118},
+		{Name: "c", Node: node.URL, Bech32Prefix: "cosmos", CoinType: 118},
+	}}
+	chains := httptest.NewServer(s.Router())
+	defer chains.Close()
+	start := time.Now()
+	require.NoError(t, json.Unmarshal(getRoute(t, fmt.Sprintf("%s/readyz", chains.URL), 503), &rd))
+	require.True(t, time.Since(start) < 2*readyTimeout)
+	require.False(t, rd.Chains["a"].OK)
+	require.NotEmpty(t, rd.Chains["b"].Error)
+	require.True(t, rd.Chains["c"].OK)
+}
+
+func TestOpenAPI(t *testing.T) {
+	server := setup(t)
+	defer server.Close()
+
+	var spec struct {
+		OpenAPI    string                                       `json:"openapi"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	out := getRoute(t, fmt.Sprintf("%s/openapi.json", server.URL), 200)
+	require.NoError(t, json.Unmarshal(out, &spec))
+	require.Equal(t, OpenAPIVersion, spec.OpenAPI)
+
+	// test every route registered is in the spec
+	s := &Server{KeyDir: tempDir(t)}
+	router := s.Router()
+	routes := 0
+	require.NoError(t, router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		require.NoError(t, err)
+		methods, err := route.GetMethods()
+		require.NoError(t, err)
+		for _, method := range methods {
+			require.Contains(t, spec.Paths[path], strings.ToLower(method), "%s %s is missing from the openapi spec", method, path)
+			routes++
+		}
+		return nil
+	}))
+
+	// test every operation in the spec is routed
+	ops := 0
+	for path, methods := range spec.Paths {
+		for method := range methods {
+			var match mux.RouteMatch
+			req := httptest.NewRequest(strings.ToUpper(method), strings.Replace(path, "{name}", testKey, -1), nil)
+			require.True(t, router.Match(req, &match), "%s %s isn't routed", method, path)
+			ops++
+		}
+	}
+	require.Equal(t, routes, ops)
+
+	// test every schema reference resolves
+	for _, ref := range regexp.MustCompile(`"#/components/schemas/([^"]+)"`).FindAllStringSubmatch(string(out), -1) {
+		require.Contains(t, spec.Components.Schemas, ref[1])
+	}
+
+	// test the schemas follow the json encoding of the types
+	props := spec.Components.Schemas["KeyOutput"]["properties"].(map[string]interface{})
+	require.Contains(t, props, "address")
+	require.Contains(t, props, "hd_path")
+	props = spec.Components.Schemas["AddNewKey"]["properties"].(map[string]interface{})
+	require.Equal(t, "string", props["account"].(map[string]interface{})["type"])
+	require.Equal(t, []interface{}{"name", "password"}, spec.Components.Schemas["AddNewKey"]["required"])
+	props = spec.Components.Schemas["Error"]["properties"].(map[string]interface{})
+	require.Len(t, props["code"].(map[string]interface{})["enum"], len(codeStatus))
+
+	// test the docs page is served
+	require.Contains(t, string(getRoute(t, fmt.Sprintf("%s/docs", server.URL), 200)), "openapi.json")
+}
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "json", "debug")
+	require.NoError(t, err)
+
+	s := &Server{KeyDir: tempDir(t)}
+	s.SetLogger(logger)
+	server := httptest.NewServer(s.Router())
+	defer server.Close()
+
+	// test a request ID is generated and echoed in error bodies
+	resp, err := http.Get(fmt.Sprintf("%s/keys/foo", server.URL))
+	require.NoError(t, err)
+	require.Equal(t, 404, resp.StatusCode)
+	generated := resp.Header.Get(RequestIDHeader)
+	require.NotEmpty(t, generated)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, generated, unmarshalError(body).RequestID)
+
+	// test a caller supplied request ID is propagated
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/keys", server.URL), bytes.NewBuffer(AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc}.Marshal()))
+	require.NoError(t, err)
+	req.Header.Set(RequestIDHeader, "caller-id")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, "caller-id", resp.Header.Get(RequestIDHeader))
+
+	// test the logs are structured, tagged and free of secrets
+	require.Contains(t, buf.String(), `"request_id":"caller-id"`)
+	require.NotContains(t, buf.String(), testPass)
+	require.NotContains(t, buf.String(), sMenominc)
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(line, &entry))
+	}
+}
+
+func TestRequestBodyLog(t *testing.T) {
+	addNP := AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc, Metadata: map[string]string{"note": "logged"}}
+
+	// test bodies aren't read for the logs unless debug is enabled
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "json", "info")
+	require.NoError(t, err)
+	s := &Server{KeyDir: tempDir(t)}
+	s.SetLogger(logger)
+	server := httptest.NewServer(s.Router())
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), addNP.Marshal(), 200)
+	server.Close()
+	require.NotContains(t, buf.String(), "request body")
+
+	// test bodies are logged at debug, and large ones only by size
+	buf.Reset()
+	logger, err = NewLogger(&buf, "json", "debug")
+	require.NoError(t, err)
+	s = &Server{KeyDir: tempDir(t)}
+	s.SetLogger(logger)
+	server = httptest.NewServer(s.Router())
+	defer server.Close()
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), addNP.Marshal(), 200)
+	require.Contains(t, buf.String(), `"note":"logged"`)
+
+	buf.Reset()
+	addNP.Name = testKey + "2"
+	for i := 0; i*maxMetadataValueLength <= maxLoggedBody; i++ {
+		addNP.Metadata[fmt.Sprintf("note%d", i)] = strings.Repeat("x", maxMetadataValueLength)
+	}
+	var key KeyOutput
+	require.NoError(t, json.Unmarshal(postRoute(t, fmt.Sprintf("%s/keys", server.URL), addNP.Marshal(), 200), &key))
+	require.Equal(t, addNP.Metadata, key.Metadata)
+	require.Contains(t, buf.String(), "not logged")
+	require.NotContains(t, buf.String(), "xxxx")
+}
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "logfmt", "info")
+	require.NoError(t, err)
+
+	logger.With("password", testPass).Info("test",
+		"old_password", testPass,
+		"body", json.RawMessage(`{"tx":{"memo":"hi"},"passphrase":"123456789"}`),
+		"key", AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc},
+		"garbage", []byte("mnemonic="+sMenominc),
+	)
+	logger.Debug("filtered", "name", "debug-only")
+
+	require.NotContains(t, buf.String(), testPass)
+	require.NotContains(t, buf.String(), sMenominc)
+	require.NotContains(t, buf.String(), "debug-only")
+	require.Contains(t, buf.String(), "memo")
+	require.Contains(t, buf.String(), testKey)
+
+	_, err = NewLogger(&buf, "xml", "info")
+	require.Error(t, err)
+}
+
+func TestChains(t *testing.T) {
+	node := mockNode(t, map[string]interface{}{
+		"abci_query": &ctypes.ResultABCIQuery{Response: abci.ResponseQuery{
+			Value: cdc.MustMarshalBinaryLengthPrefixed(sdk.Result{GasUsed: 50000}),
+		}},
+	})
+	defer node.Close()
+
+	terra := Chain{Name: "terra", ChainID: "columbus-3", Node: node.URL, Bech32Prefix: "terra", CoinType: 330, GasPrices: "0.015uluna"}
+	s := &Server{KeyDir: tempDir(t), Node: node.URL, Chains: []Chain{terra}}
+	require.NoError(t, s.ValidateChains())
+	server := httptest.NewServer(s.Router())
+	defer server.Close()
+
+	// test unknown chains are rejected
+	getRoute(t, fmt.Sprintf("%s/keys?chain=foo", server.URL), 400)
+
+	// test keys are derived with the chain's coin type and shown with its prefix
+	addNP := AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc}
+	key := unmarshalKeyOutput(postRoute(t, fmt.Sprintf("%s/keys?chain=terra", server.URL), addNP.Marshal(), 200))
+	require.Regexp(t, "^terra1", key.Address)
+	require.Regexp(t, "^terrapub1", key.PubKey)
+	require.NotEqual(t, sAcc, key.Address)
+
+	valKey := unmarshalKeyOutput(getRoute(t, fmt.Sprintf("%s/keys/%s?chain=terra&bech=val", server.URL, testKey), 200))
+	require.Regexp(t, "^terravaloper1", valKey.Address)
+
+	// test the same key without a chain uses the cosmos prefix
+	cosmosKey := unmarshalKeyOutput(getRoute(t, fmt.Sprintf("%s/keys/%s", server.URL, testKey), 200))
+	require.Regexp(t, "^cosmos1", cosmosKey.Address)
+
+	// test fees are paid at the chain's default gas prices for the simulated gas
+	send := fmt.Sprintf(`{"sender":%q,"reciever":%q,"amount":"10uluna","gas_adjustment":"1.5"}`, key.Address, key.Address)
+	var unsigned auth.StdTx
+	require.NoError(t, cdc.UnmarshalJSON(postRoute(t, fmt.Sprintf("%s/tx/bank/send?chain=terra", server.URL), []byte(send), 200), &unsigned))
+	require.Equal(t, uint64(75000), unsigned.Fee.Gas)
+	require.Equal(t, "1125uluna", unsigned.Fee.Amount.String())
+
+	// test signing defaults to the chain's chain ID
+	sb := SignBody{Tx: postRoute(t, fmt.Sprintf("%s/tx/bank/send?chain=terra", server.URL), []byte(send), 200), Name: testKey, Passphrase: testPass, AccountNumber: "1", Sequence: "2"}
+	var signed auth.StdTx
+	require.NoError(t, cdc.UnmarshalJSON(postRoute(t, fmt.Sprintf("%s/tx/sign?chain=terra", server.URL), sb.Marshal(), 200), &signed))
+	require.Len(t, signed.Signatures, 1)
+	signBytes := auth.StdSignBytes(terra.ChainID, 1, 2, signed.Fee, signed.Msgs, signed.Memo)
+	require.True(t, signed.Signatures[0].PubKey.VerifyBytes(signBytes, signed.Signatures[0].Signature))
+
+	// test signing without a chain ID fails when the profile has none
+	require.Contains(t, unmarshalError(postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 400)).Error, "chain_id")
+
+	// test a request waiting on one chain's node doesn't block another chain
+	entered, unblock := make(chan struct{}), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-unblock
+		node.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+	kava := Chain{Name: "kava", ChainID: "kava-2", Node: slow.URL, Bech32Prefix: "kava", CoinType: 459}
+	s.Chains = append(s.Chains, kava)
+	_, accAddr, err := bech32.DecodeAndConvert(sAcc)
+	require.NoError(t, err)
+	kavaAddr, err := bech32.ConvertAndEncode("kava", accAddr)
+	require.NoError(t, err)
+	done := make(chan int)
+	go func() {
+		resp, err := http.Post(fmt.Sprintf("%s/tx/bank/send?chain=kava", server.URL), "application/json", strings.NewReader(fmt.Sprintf(`{"sender":%q,"reciever":%q,"amount":"10ukava"}`, kavaAddr, kavaAddr)))
+		if err != nil {
+			done <- 0
+			return
+		}
+		done <- resp.StatusCode
+	}()
+	select {
+	case <-entered:
+	case status := <-done:
+		t.Fatalf("send on kava returned %d without reaching the node", status)
+	}
+	require.Regexp(t, "^terra1", unmarshalKeyOutput(getRoute(t, fmt.Sprintf("%s/keys/%s?chain=terra", server.URL, testKey), 200)).Address)
+	close(unblock)
+	require.Equal(t, 200, <-done)
+
+	// test invalid profiles are caught
+	require.Error(t, (&Server{Chains: []Chain{terra, terra}}).ValidateChains())
+	require.Error(t, Chain{Name: "kava", Node: node.URL, Bech32Prefix: "kava", Codec: "foo"}.Validate())
+}
+
+func TestChainLease(t *testing.T) {
+	a := Chain{Name: "a", Bech32Prefix: "a"}
+	b := Chain{Name: "b", Bech32Prefix: "b"}
+
+	// test a request for a busy chain queues behind one waiting for another
+	releaseA := a.use()
+	gotB := make(chan func())
+	go func() { gotB <- b.use() }()
+	for waiting := false; !waiting; time.Sleep(time.Millisecond) {
+		chainLease.Lock()
+		waiting = chainLease.next != nil
+		chainLease.Unlock()
+	}
+
+	gotA := make(chan func())
+	go func() { gotA <- a.use() }()
+	select {
+	case <-gotA:
+		t.Fatal("chain a was leased again while chain b was waiting")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	releaseA()
+	(<-gotB)()
+	(<-gotA)()
+	require.Equal(t, "a", sdk.GetConfig().GetBech32AccountAddrPrefix())
+}
+
+func TestHDPath(t *testing.T) {
+	server := setup(t)
+	defer server.Close()
+
+	// test the default path uses the cosmos coin type
+	addNP := AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc}
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), addNP.Marshal(), 200)
+	var key KeyOutput
+	require.NoError(t, json.Unmarshal(getRoute(t, fmt.Sprintf("%s/keys/%s", server.URL, testKey), 200), &key))
+	require.Equal(t, sAcc, key.Address)
+	require.Equal(t, "44'/118'/0'/0/0", key.HDPath)
+
+	// test a coin type and the equivalent full path derive the same key
+	byCoinType := AddNewKey{Name: "terra", Password: testPass, Mnemonic: sMenominc, CoinType: 330, Index: 2}
+	require.NoError(t, json.Unmarshal(postRoute(t, fmt.Sprintf("%s/keys", server.URL), byCoinType.Marshal(), 200), &key))
+	require.Equal(t, "44'/330'/0'/0/2", key.HDPath)
+	terraAddr := key.Address
+
+	byPath := AddNewKey{Name: "terra-path", Password: testPass, Mnemonic: sMenominc, HDPath: "m/44'/330'/0'/0/2"}
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), byPath.Marshal(), 200)
+	require.NoError(t, json.Unmarshal(getRoute(t, fmt.Sprintf("%s/keys/terra-path", server.URL), 200), &key))
+	require.Equal(t, terraAddr, key.Address)
+	require.Equal(t, "44'/330'/0'/0/2", key.HDPath)
+
+	// test invalid paths are rejected
+	for _, bad := range []AddNewKey{
+		{Name: "bad", Password: testPass, HDPath: "m/44'/118'/0/0/0"},
+		{Name: "bad", Password: testPass, HDPath: "m/44'/118'/0'/0'/0"},
+		{Name: "bad", Password: testPass, HDPath: "m/44'/4294967414'/0'/0/0"},
+		{Name: "bad", Password: testPass, HDPath: "m/44'/118'/0'/0"},
+		{Name: "bad", Password: testPass, HDPath: "m/44'/118'/0'/0/0", Account: 1},
+		{Name: "bad", Password: testPass, CoinType: -1},
+	} {
+		postRoute(t, fmt.Sprintf("%s/keys", server.URL), bad.Marshal(), 400)
+	}
+
+	// test metadata is removed with the key
+	deleteRoute(t, fmt.Sprintf("%s/keys/terra", server.URL), DeleteKeyBody{Password: testPass}.Marshal(), 200)
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: "terra", Password: testPass, Mnemonic: sMenominc}.Marshal(), 200)
+	require.NoError(t, json.Unmarshal(getRoute(t, fmt.Sprintf("%s/keys/terra", server.URL), 200), &key))
+	require.Equal(t, "44'/118'/0'/0/0", key.HDPath)
+}
+
+func TestBIP39Passphrase(t *testing.T) {
+	server := setup(t)
+	defer server.Close()
+
+	// test the passphrase changes the derived key and recovers it deterministically
+	withPass := AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc, BIP39Passphrase: "25th word"}
+	key := unmarshalKeyOutput(postRoute(t, fmt.Sprintf("%s/keys", server.URL), withPass.Marshal(), 200))
+	require.NotEqual(t, sAcc, key.Address)
+
+	withPass.Name = "recovered"
+	recovered := unmarshalKeyOutput(postRoute(t, fmt.Sprintf("%s/keys", server.URL), withPass.Marshal(), 200))
+	require.Equal(t, key.Address, recovered.Address)
+
+	// test generated mnemonic lengths
+	generated := unmarshalKeyOutput(postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: "long", Password: testPass}.Marshal(), 200))
+	require.Len(t, strings.Fields(generated.Mnemonic), 24)
+
+	generated = unmarshalKeyOutput(postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: "short", Password: testPass, MnemonicWords: 12}.Marshal(), 200))
+	require.Len(t, strings.Fields(generated.Mnemonic), 12)
+
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: "bad", Password: testPass, MnemonicWords: 13}.Marshal(), 400)
+}
+
+func TestExportImport(t *testing.T) {
+	staging := setup(t)
+	defer staging.Close()
+	production := setup(t)
+	defer production.Close()
+
+	addNP := AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc}
+	postRoute(t, fmt.Sprintf("%s/keys", staging.URL), addNP.Marshal(), 200)
+
+	// test export requires the key's password
+	exportRoute := fmt.Sprintf("%s/keys/%s/export", staging.URL, testKey)
+	postRoute(t, exportRoute, ExportKeyBody{Password: testPassAlt}.Marshal(), 401)
+	postRoute(t, fmt.Sprintf("%s/keys/foo/export", staging.URL), ExportKeyBody{Password: testPass}.Marshal(), 404)
+	getRoute(t, exportRoute, 405)
+
+	var priv KeyArmor
+	require.NoError(t, json.Unmarshal(postRoute(t, exportRoute, ExportKeyBody{Password: testPass, ExportPassword: "transport"}.Marshal(), 200), &priv))
+	require.Contains(t, priv.Armor, armorTypePrivKey)
+
+	// test import requires the export password and re-encrypts with the new one
+	doRoute(t, http.MethodPost, fmt.Sprintf("%s/keys/import", production.URL), ImportKeyBody{Name: testKey, Armor: priv.Armor, Passphrase: testPass}.Marshal(), 401)
+	imported := unmarshalKeyOutput(postRoute(t, fmt.Sprintf("%s/keys/import", production.URL), ImportKeyBody{Name: testKey, Armor: priv.Armor, Passphrase: "transport", Password: testPassAlt}.Marshal(), 200))
+	require.Equal(t, sAcc, imported.Address)
+	require.Equal(t, "local", imported.Type)
+	postRoute(t, fmt.Sprintf("%s/keys/import", production.URL), ImportKeyBody{Name: testKey, Armor: priv.Armor, Passphrase: "transport"}.Marshal(), 409)
+	putRoute(t, fmt.Sprintf("%s/keys/%s", production.URL, testKey), UpdateKeyBody{OldPassword: testPassAlt, NewPassword: testPass}.Marshal(), 204)
+
+	// test public keys import as offline keys
+	var pub KeyArmor
+	require.NoError(t, json.Unmarshal(postRoute(t, exportRoute+"?pubkey=true", nil, 200), &pub))
+	offline := unmarshalKeyOutput(postRoute(t, fmt.Sprintf("%s/keys/import", production.URL), ImportKeyBody{Name: "watch", Armor: pub.Armor}.Marshal(), 200))
+	require.Equal(t, sAcc, offline.Address)
+	require.Equal(t, "offline", offline.Type)
+
+	// test garbage is rejected
+	postRoute(t, fmt.Sprintf("%s/keys/import", production.URL), ImportKeyBody{Name: "bad", Armor: "foo"}.Marshal(), 400)
+}
+
+func TestOfflineKeys(t *testing.T) {
+	server := setup(t)
+	defer server.Close()
+
+	// test registering from a public key or an address
+	offline := unmarshalKeyOutput(postRoute(t, fmt.Sprintf("%s/keys/offline", server.URL), OfflineKeyBody{Name: "ledger", PubKey: sAccPub}.Marshal(), 200))
+	require.Equal(t, "offline", offline.Type)
+	require.Equal(t, sAcc, offline.Address)
+
+	address := unmarshalKeyOutput(postRoute(t, fmt.Sprintf("%s/keys/offline", server.URL), OfflineKeyBody{Name: "cold", Address: sAcc}.Marshal(), 200))
+	require.Equal(t, keyTypeAddress, address.Type)
+	require.Equal(t, sAcc, address.Address)
+
+	// test bad bodies and taken names are rejected
+	postRoute(t, fmt.Sprintf("%s/keys/offline", server.URL), OfflineKeyBody{Name: "bad", PubKey: sAcc}.Marshal(), 400)
+	postRoute(t, fmt.Sprintf("%s/keys/offline", server.URL), OfflineKeyBody{Name: "bad", PubKey: sAccPub, Address: sAcc}.Marshal(), 400)
+	postRoute(t, fmt.Sprintf("%s/keys/offline", server.URL), OfflineKeyBody{Name: "cold", PubKey: sAccPub}.Marshal(), 409)
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: "cold", Password: testPass}.Marshal(), 409)
+
+	// test listing shows every key with its type
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc}.Marshal(), 200)
+	keys := unmarshalKeysOutput(getRoute(t, fmt.Sprintf("%s/keys", server.URL), 200))
+	require.Len(t, keys, 3)
+	require.Equal(t, []string{"cold", testKey, "ledger"}, []string{keys[0].Name, keys[1].Name, keys[2].Name})
+	require.Equal(t, []string{keyTypeAddress, "local", "offline"}, []string{keys[0].Type, keys[1].Type, keys[2].Type})
+
+	valAddress := unmarshalKeyOutput(getRoute(t, fmt.Sprintf("%s/keys/cold?bech=val", server.URL), 200))
+	require.Equal(t, sVal, valAddress.Address)
+
+	// test watch-only keys can't sign
+	sb := SignBody{Tx: []byte(`{"type":"cosmos-sdk/StdTx","value":{"msg":[],"fee":{"amount":[],"gas":"0"},"signatures":null,"memo":""}}`), Name: "ledger", ChainID: "testing", AccountNumber: "0", Sequence: "0"}
+	require.Contains(t, unmarshalError(postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 400)).Error, "offline")
+	sb.Name = "cold"
+	watchErr := unmarshalError(postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 400))
+	require.Equal(t, CodeInvalidRequest, watchErr.Code)
+	require.Contains(t, watchErr.Error, "watch-only address key")
+	require.Equal(t, keyTypeAddress, watchErr.Details["type"])
+	sb.Name = testKey
+	sb.Passphrase = testPass
+	postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 200)
+
+	// test watch-only keys can be multisig members unless only their address is known
+	member := unmarshalKeyOutput(postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: "member", Password: testPass}.Marshal(), 200))
+	multi := unmarshalKeyOutput(postRoute(t, fmt.Sprintf("%s/keys/multi", server.URL), MultiKeyBody{Name: "multi", Keys: []string{"ledger", "member"}, Threshold: 2}.Marshal(), 200))
+	require.Equal(t, "multi", multi.Type)
+	require.NotEqual(t, sAcc, multi.Address)
+	require.Contains(t, unmarshalError(postRoute(t, fmt.Sprintf("%s/keys/multi", server.URL), MultiKeyBody{Name: "other", Keys: []string{"ledger", "cold"}, Threshold: 1}.Marshal(), 400)).Error, "without a public key")
+	postRoute(t, fmt.Sprintf("%s/keys/multi", server.URL), MultiKeyBody{Name: "other", Keys: []string{"ledger", "missing"}, Threshold: 1}.Marshal(), 404)
+	postRoute(t, fmt.Sprintf("%s/keys/multi", server.URL), MultiKeyBody{Name: "other", Keys: []string{"ledger", "member"}, Threshold: 3}.Marshal(), 400)
+	postRoute(t, fmt.Sprintf("%s/keys/multi", server.URL), MultiKeyBody{Name: "other", Keys: []string{"ledger", "ledger"}, Threshold: 1}.Marshal(), 400)
+	postRoute(t, fmt.Sprintf("%s/keys/multi", server.URL), MultiKeyBody{Name: "multi", Keys: []string{"ledger", "member"}, Threshold: 1}.Marshal(), 409)
+	require.Contains(t, unmarshalError(postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), SignBody{Tx: sb.Tx, Name: "multi", ChainID: "testing", AccountNumber: "0", Sequence: "0"}.Marshal(), 400)).Error, "multi")
+
+	// test watch-only keys can be deleted
+	deleteRoute(t, fmt.Sprintf("%s/keys/cold", server.URL), DeleteKeyBody{}.Marshal(), 200)
+	deleteRoute(t, fmt.Sprintf("%s/keys/ledger", server.URL), DeleteKeyBody{}.Marshal(), 200)
+	require.Len(t, unmarshalKeysOutput(getRoute(t, fmt.Sprintf("%s/keys", server.URL), 200)), 3)
+
+	// test verifying names the signers' keys, watch-only addresses included
+	from, err := sdk.AccAddressFromBech32(sAcc)
+	require.NoError(t, err)
+	to, err := sdk.AccAddressFromBech32(member.Address)
+	require.NoError(t, err)
+	watched := sdk.AccAddress(tmhash.SumTruncated([]byte("watched")))
+	postRoute(t, fmt.Sprintf("%s/keys/offline", server.URL), OfflineKeyBody{Name: "watched", Address: watched.String()}.Marshal(), 200)
+	coins := sdk.NewCoins(sdk.NewInt64Coin("stake", 10))
+	tx := cdc.MustMarshalJSON(auth.NewStdTx([]sdk.Msg{bank.MsgSend{FromAddress: from, ToAddress: to, Amount: coins}, bank.MsgSend{FromAddress: watched, ToAddress: to, Amount: coins}}, auth.NewStdFee(200000, nil), nil, "verify"))
+	sb = SignBody{Tx: tx, Name: testKey, Passphrase: testPass, ChainID: "testing", AccountNumber: "1", Sequence: "2"}
+	signed := postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 200)
+
+	vb := VerifyBody{Tx: signed, ChainID: "testing", Accounts: []VerifyAccount{{"1", "2"}, {"3", "0"}}}
+	var res VerifyResult
+	require.NoError(t, json.Unmarshal(postRoute(t, fmt.Sprintf("%s/tx/verify", server.URL), vb.Marshal(), 200), &res))
+	require.False(t, res.Valid)
+	require.Equal(t, []SignerResult{
+		{Address: sAcc, Name: testKey, Type: "local", Valid: true},
+		{Address: watched.String(), Name: "watched", Type: keyTypeAddress, Error: "missing signature"},
+	}, res.Signers)
+
+	// test signatures for another sequence or chain are invalid
+	vb.Accounts[0].Sequence = "3"
+	require.NoError(t, json.Unmarshal(postRoute(t, fmt.Sprintf("%s/tx/verify", server.URL), vb.Marshal(), 200), &res))
+	require.Equal(t, "invalid signature", res.Signers[0].Error)
+	vb.Accounts[0].Sequence, vb.ChainID = "2", "other"
+	require.NoError(t, json.Unmarshal(postRoute(t, fmt.Sprintf("%s/tx/verify", server.URL), vb.Marshal(), 200), &res))
+	require.False(t, res.Signers[0].Valid)
+	vb.ChainID, vb.Accounts = "testing", vb.Accounts[:1]
+	postRoute(t, fmt.Sprintf("%s/tx/verify", server.URL), vb.Marshal(), 400)
+
+	// test a transaction signed by every signer is valid
+	vb = VerifyBody{Tx: signed, ChainID: "testing", Accounts: []VerifyAccount{{"1", "2"}}}
+	signed = postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), SignBody{Tx: cdc.MustMarshalJSON(auth.NewStdTx([]sdk.Msg{bank.MsgSend{FromAddress: from, ToAddress: to, Amount: coins}}, auth.NewStdFee(200000, nil), nil, "")), Name: testKey, Passphrase: testPass, ChainID: "testing", AccountNumber: "1", Sequence: "2"}.Marshal(), 200)
+	vb.Tx = signed
+	require.NoError(t, json.Unmarshal(postRoute(t, fmt.Sprintf("%s/tx/verify", server.URL), vb.Marshal(), 200), &res))
+	require.True(t, res.Valid)
+}
+
+func TestLabels(t *testing.T) {
+	server := setup(t)
+	defer server.Close()
+
+	// test labels and metadata are stored with new keys
+	addNP := AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc, Labels: []string{"hot", "deposit", "hot"}, Metadata: map[string]string{"owner": "ops"}}
+	key := unmarshalLabeledKey(postRoute(t, fmt.Sprintf("%s/keys", server.URL), addNP.Marshal(), 200))
+	require.Equal(t, []string{"deposit", "hot"}, key.Labels)
+	require.Equal(t, map[string]string{"owner": "ops"}, key.Metadata)
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: "bad", Password: testPass, Labels: []string{"a b"}}.Marshal(), 400)
+
+	// test a key isn't kept without its labels when they can't be stored
+	ks := NewMemoryKeystore()
+	s := &Server{KeyDir: tempDir(t), Node: "tcp://127.0.0.1:1"}
+	s.SetKeystore(failingKeystore{ks, "Meta", ""})
+	failing := httptest.NewServer(s.Router())
+	defer failing.Close()
+	postRoute(t, fmt.Sprintf("%s/keys", failing.URL), addNP.Marshal(), 500)
+	kb, err := ks.Keybase()
+	require.NoError(t, err)
+	_, err = kb.Get(testKey)
+	require.Error(t, err)
+
+	for i := 0; i < 5; i++ {
+		postRoute(t, fmt.Sprintf("%s/keys/offline", server.URL), OfflineKeyBody{Name: fmt.Sprintf("customer-%d", i), Address: sAcc}.Marshal(), 200)
+	}
+
+	// test patching labels and merging metadata
+	note, customer := "vip", "3"
+	pb := PatchKeyBody{Labels: &[]string{"deposit"}, Metadata: map[string]*string{"customer": &customer, "note": &note}}
+	key = unmarshalLabeledKey(doRoute(t, http.MethodPatch, fmt.Sprintf("%s/keys/customer-3", server.URL), pb.Marshal(), 200))
+	require.Equal(t, []string{"deposit"}, key.Labels)
+	require.Equal(t, keyTypeAddress, key.Type)
+
+	pb = PatchKeyBody{Metadata: map[string]*string{"note": nil}}
+	key = unmarshalLabeledKey(doRoute(t, http.MethodPatch, fmt.Sprintf("%s/keys/customer-3", server.URL), pb.Marshal(), 200))
+	require.Equal(t, []string{"deposit"}, key.Labels)
+	require.Equal(t, map[string]string{"customer": "3"}, key.Metadata)
+
+	key = unmarshalLabeledKey(getRoute(t, fmt.Sprintf("%s/keys/customer-3", server.URL), 200))
+	require.Equal(t, map[string]string{"customer": "3"}, key.Metadata)
+
+	pb = PatchKeyBody{Labels: &[]string{"deposit"}}
+	doRoute(t, http.MethodPatch, fmt.Sprintf("%s/keys/customer-1", server.URL), pb.Marshal(), 200)
+	doRoute(t, http.MethodPatch, fmt.Sprintf("%s/keys/missing", server.URL), pb.Marshal(), 404)
+	doRoute(t, http.MethodPatch, fmt.Sprintf("%s/keys/customer-1", server.URL), PatchKeyBody{Labels: &[]string{""}}.Marshal(), 400)
+
+	// test filtering
+	names := func(route string) []string {
+		var out []string
+		for _, k := range unmarshalKeysOutput(getRoute(t, route, 200)) {
+			out = append(out, k.Name)
+		}
+		return out
+	}
+	require.Equal(t, []string{"customer-1", "customer-3", testKey}, names(fmt.Sprintf("%s/keys?label=deposit", server.URL)))
+	require.Equal(t, []string{testKey}, names(fmt.Sprintf("%s/keys?label=deposit&label=hot", server.URL)))
+	require.Equal(t, []string{"customer-1", "customer-3"}, names(fmt.Sprintf("%s/keys?label=deposit&type=address", server.URL)))
+	require.Equal(t, []string{testKey}, names(fmt.Sprintf("%s/keys?type=local", server.URL)))
+	require.Len(t, names(fmt.Sprintf("%s/keys?prefix=customer-", server.URL)), 5)
+	getRoute(t, fmt.Sprintf("%s/keys?limit=0", server.URL), 400)
+
+	// test paging through every key
+	var paged []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		require.True(t, pages < 3)
+		resp, err := http.Get(fmt.Sprintf("%s/keys?limit=2&cursor=%s", server.URL, cursor))
+		require.NoError(t, err)
+		require.Equal(t, 200, resp.StatusCode)
+		out, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		for _, k := range unmarshalKeysOutput(out) {
+			paged = append(paged, k.Name)
+		}
+		if cursor = resp.Header.Get(NextCursorHeader); cursor == "" {
+			break
+		}
+	}
+	require.Equal(t, names(fmt.Sprintf("%s/keys", server.URL)), paged)
+	require.Len(t, paged, 6)
+
+	// test concurrent patches of one key are all kept, the leveldb keystore
+	// opens its db per operation so concurrent requests use a slow memory one
+	s = &Server{KeyDir: tempDir(t)}
+	s.SetKeystore(slowKeystore{NewMemoryKeystore()})
+	concurrent := httptest.NewServer(s.Router())
+	defer concurrent.Close()
+	postRoute(t, fmt.Sprintf("%s/keys/offline", concurrent.URL), OfflineKeyBody{Name: "customer-0", Address: sAcc}.Marshal(), 200)
+	statuses := make(chan int)
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			value := strconv.Itoa(i)
+			pb := PatchKeyBody{Metadata: map[string]*string{"k" + value: &value}}
+			req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/keys/customer-0", concurrent.URL), bytes.NewReader(pb.Marshal()))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		require.Equal(t, 200, <-statuses)
+	}
+	key = unmarshalLabeledKey(getRoute(t, fmt.Sprintf("%s/keys/customer-0", concurrent.URL), 200))
+	require.Len(t, key.Metadata, 20)
+}
+
+func TestDeriveKeys(t *testing.T) {
+	server := setup(t)
+	defer server.Close()
+	route := fmt.Sprintf("%s/keys/derive", server.URL)
+
+	// test computing addresses without storing keys
+	computed := unmarshalDerivedKeys(postRoute(t, route, DeriveKeysBody{Mnemonic: sMenominc, Indexes: "0-2", ComputeOnly: true}.Marshal(), 200))
+	require.Len(t, computed, 3)
+	require.Equal(t, sAcc, computed[0].Address)
+	require.Equal(t, sAccPub, computed[0].PubKey)
+	require.Equal(t, "44'/118'/0'/0/2", computed[2].HDPath)
+	require.Empty(t, computed[2].Name)
+	require.Len(t, unmarshalKeysOutput(getRoute(t, fmt.Sprintf("%s/keys", server.URL), 200)), 0)
+
+	postRoute(t, route, DeriveKeysBody{Mnemonic: sMenominc, Accounts: "0-1", Indexes: "0-999", ComputeOnly: true}.Marshal(), 400)
+	postRoute(t, route, DeriveKeysBody{Mnemonic: sMenominc, Indexes: "2-1", ComputeOnly: true}.Marshal(), 400)
+
+	// test storing derived keys
+	db := DeriveKeysBody{Mnemonic: sMenominc, Password: testPass, Indexes: "1-2", NameTemplate: "deposit-{index}", Labels: []string{"deposit"}}
+	stored := unmarshalDerivedKeys(postRoute(t, route, db.Marshal(), 200))
+	require.Equal(t, []string{"deposit-1", "deposit-2"}, []string{stored[0].Name, stored[1].Name})
+	require.Equal(t, computed[1:], []DerivedKey{{HDPath: stored[0].HDPath, Address: stored[0].Address, PubKey: stored[0].PubKey}, {HDPath: stored[1].HDPath, Address: stored[1].Address, PubKey: stored[1].PubKey}})
+	key := unmarshalLabeledKey(getRoute(t, fmt.Sprintf("%s/keys/deposit-2", server.URL), 200))
+	require.Equal(t, computed[2].Address, key.Address)
+	require.Equal(t, []string{"deposit"}, key.Labels)
+
+	// test names must be unique and free
+	postRoute(t, route, db.Marshal(), 409)
+	db.Indexes, db.NameTemplate = "3-4", "deposit"
+	postRoute(t, route, db.Marshal(), 400)
+	db.NameTemplate = ""
+	postRoute(t, route, db.Marshal(), 400)
+
+	// test deriving from a base key with a retained mnemonic
+	master := unmarshalLabeledKey(postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: "master", Password: testPass, RetainMnemonic: true}.Marshal(), 200))
+	require.True(t, master.MnemonicRetained)
+	fromBase := DeriveKeysBody{BaseKey: "master", Password: testPass, ComputeOnly: true}
+	derived := unmarshalDerivedKeys(postRoute(t, route, fromBase.Marshal(), 200))
+	require.Equal(t, master.Address, derived[0].Address)
+
+	fromBase.Password = "wrong"
+	postRoute(t, route, fromBase.Marshal(), 401)
+	fromBase.BaseKey = "deposit-1"
+	postRoute(t, route, fromBase.Marshal(), 400)
+	fromBase.BaseKey = "missing"
+	postRoute(t, route, fromBase.Marshal(), 404)
+
+	// test the retained mnemonic follows password changes
+	putRoute(t, fmt.Sprintf("%s/keys/master", server.URL), UpdateKeyBody{OldPassword: testPass, NewPassword: "newpassword"}.Marshal(), 204)
+	fromBase = DeriveKeysBody{BaseKey: "master", Password: "newpassword", Indexes: "1", NameTemplate: "child"}
+	derived = unmarshalDerivedKeys(postRoute(t, route, fromBase.Marshal(), 200))
+	require.Equal(t, "child", derived[0].Name)
+	require.Equal(t, "44'/118'/0'/0/1", derived[0].HDPath)
+
+	// test a failed password change leaves the retained mnemonic with the old password
+	ks := NewMemoryKeystore()
+	s := &Server{Node: "tcp://127.0.0.1:1"}
+	s.SetKeystore(ks)
+	server = httptest.NewServer(s.Router())
+	defer server.Close()
+	route = fmt.Sprintf("%s/keys/derive", server.URL)
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: "master", Password: testPass, RetainMnemonic: true}.Marshal(), 200)
+	s.SetKeystore(failingKeystore{ks, "Update", ""})
+	putRoute(t, fmt.Sprintf("%s/keys/master", server.URL), UpdateKeyBody{OldPassword: testPass, NewPassword: "newpassword"}.Marshal(), 500)
+	putRoute(t, fmt.Sprintf("%s/keys/master", server.URL), UpdateKeyBody{OldPassword: "wrong", NewPassword: "newpassword"}.Marshal(), 401)
+	s.SetKeystore(ks)
+	postRoute(t, route, DeriveKeysBody{BaseKey: "master", Password: testPass, ComputeOnly: true}.Marshal(), 200)
+	postRoute(t, route, DeriveKeysBody{BaseKey: "master", Password: "newpassword", ComputeOnly: true}.Marshal(), 401)
+
+	// test computing keys from a mnemonic doesn't need the keybase
+	s.SetKeystore(noKeybaseKeystore{ks})
+	computed = unmarshalDerivedKeys(postRoute(t, route, DeriveKeysBody{Mnemonic: sMenominc, ComputeOnly: true}.Marshal(), 200))
+	require.Equal(t, sAcc, computed[0].Address)
+	postRoute(t, route, DeriveKeysBody{BaseKey: "master", Password: testPass, ComputeOnly: true}.Marshal(), 503)
+}
+
+func TestRenameKey(t *testing.T) {
+	server := setup(t)
+	defer server.Close()
+
+	addNP := AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc, Labels: []string{"hot"}}
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), addNP.Marshal(), 200)
+	postRoute(t, fmt.Sprintf("%s/keys/offline", server.URL), OfflineKeyBody{Name: "cold", Address: sVal}.Marshal(), 400)
+	postRoute(t, fmt.Sprintf("%s/keys/offline", server.URL), OfflineKeyBody{Name: "cold", Address: sAcc}.Marshal(), 200)
+	postRoute(t, fmt.Sprintf("%s/keys/offline", server.URL), OfflineKeyBody{Name: "ledger", PubKey: sAccPub}.Marshal(), 200)
+
+	// test bad requests
+	route := fmt.Sprintf("%s/keys/%s/rename", server.URL, testKey)
+	postRoute(t, route, RenameKeyBody{NewName: "renamed", Password: "wrongpassword"}.Marshal(), 401)
+	postRoute(t, route, RenameKeyBody{NewName: "cold", Password: testPass}.Marshal(), 409)
+	postRoute(t, route, RenameKeyBody{Password: testPass}.Marshal(), 400)
+	postRoute(t, fmt.Sprintf("%s/keys/missing/rename", server.URL), RenameKeyBody{NewName: "renamed"}.Marshal(), 404)
+
+	// test renaming a local key keeps its address, password and labels
+	key := unmarshalLabeledKey(postRoute(t, route, RenameKeyBody{NewName: "renamed", Password: testPass}.Marshal(), 200))
+	require.Equal(t, "renamed", key.Name)
+	require.Equal(t, sAcc, key.Address)
+	require.Equal(t, []string{"hot"}, key.Labels)
+	getRoute(t, fmt.Sprintf("%s/keys/%s", server.URL, testKey), 404)
+	key = unmarshalLabeledKey(getRoute(t, fmt.Sprintf("%s/keys/renamed", server.URL), 200))
+	require.Equal(t, []string{"hot"}, key.Labels)
+	require.Equal(t, "44'/118'/0'/0/0", key.HDPath)
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc}.Marshal(), 200)
+
+	sb := SignBody{Tx: []byte(`{"type":"cosmos-sdk/StdTx","value":{"msg":[],"fee":{"amount":[],"gas":"0"},"signatures":null,"memo":""}}`), Name: "renamed", Passphrase: testPass, ChainID: "testing", AccountNumber: "0", Sequence: "0"}
+	postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 200)
+
+	// test renaming watch-only keys
+	key = unmarshalLabeledKey(postRoute(t, fmt.Sprintf("%s/keys/ledger/rename", server.URL), RenameKeyBody{NewName: "hardware"}.Marshal(), 200))
+	require.Equal(t, "offline", key.Type)
+	key = unmarshalLabeledKey(postRoute(t, fmt.Sprintf("%s/keys/cold/rename", server.URL), RenameKeyBody{NewName: "frozen"}.Marshal(), 200))
+	require.Equal(t, keyTypeAddress, key.Type)
+	require.Equal(t, sAcc, key.Address)
+
+	var names []string
+	for _, k := range unmarshalKeysOutput(getRoute(t, fmt.Sprintf("%s/keys", server.URL), 200)) {
+		names = append(names, k.Name)
+	}
+	require.Equal(t, []string{"frozen", "hardware", testKey, "renamed"}, names)
+
+	// test a failed rename leaves the key under its old name
+	ks := NewMemoryKeystore()
+	s := &Server{Node: "tcp://127.0.0.1:1"}
+	s.SetKeystore(ks)
+	server = httptest.NewServer(s.Router())
+	defer server.Close()
+	route = fmt.Sprintf("%s/keys/%s/rename", server.URL, testKey)
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), addNP.Marshal(), 200)
+	kb, err := ks.Keybase()
+	require.NoError(t, err)
+	addr, err := sdk.AccAddressFromBech32(sAcc)
+	require.NoError(t, err)
+
+	for _, failing := range []failingKeystore{{ks, "ImportPrivKey", ""}, {ks, "Delete", testKey}} {
+		method := failing.fail
+		s.SetKeystore(failing)
+		postRoute(t, route, RenameKeyBody{NewName: "renamed", Password: testPass}.Marshal(), 500)
+		key = unmarshalLabeledKey(getRoute(t, fmt.Sprintf("%s/keys/%s", server.URL, testKey), 200))
+		require.Equal(t, []string{"hot"}, key.Labels, method)
+		getRoute(t, fmt.Sprintf("%s/keys/renamed", server.URL), 404)
+		info, err := kb.GetByAddress(addr)
+		require.NoError(t, err, method)
+		require.Equal(t, testKey, info.GetName(), method)
+	}
+
+	// test the address index follows the renamed key
+	s.SetKeystore(ks)
+	postRoute(t, route, RenameKeyBody{NewName: "renamed", Password: testPass}.Marshal(), 200)
+	info, err := kb.GetByAddress(addr)
+	require.NoError(t, err)
+	require.Equal(t, "renamed", info.GetName())
+
+	// test labels patched while a key is renamed are either moved with it or rejected
+	s.SetKeystore(slowKeystore{ks})
+	labels := []string{"patched"}
+	patched := make(chan int)
+	go func() {
+		req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/keys/renamed", server.URL), bytes.NewBuffer(PatchKeyBody{Labels: &labels}.Marshal()))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			patched <- 0
+			return
+		}
+		resp.Body.Close()
+		patched <- resp.StatusCode
+	}()
+	postRoute(t, fmt.Sprintf("%s/keys/renamed/rename", server.URL), RenameKeyBody{NewName: "moved", Password: testPass}.Marshal(), 200)
+	key = unmarshalLabeledKey(getRoute(t, fmt.Sprintf("%s/keys/moved", server.URL), 200))
+	switch status := <-patched; status {
+	case 200:
+		require.Equal(t, labels, unmarshalLabeledKey(getRoute(t, fmt.Sprintf("%s/keys/moved", server.URL), 200)).Labels)
+	case 404:
+		require.Equal(t, []string{"hot"}, key.Labels)
+	default:
+		t.Fatalf("unexpected patch status %d", status)
+	}
+	getRoute(t, fmt.Sprintf("%s/keys/renamed", server.URL), 404)
+
+	// test a key whose address can't be indexed again is still renamed
+	s.SetKeystore(failingKeystore{ks, "Update", ""})
+	postRoute(t, fmt.Sprintf("%s/keys/moved/rename", server.URL), RenameKeyBody{NewName: "last", Password: testPass}.Marshal(), 500)
+	key = unmarshalLabeledKey(getRoute(t, fmt.Sprintf("%s/keys/last", server.URL), 200))
+	require.NotEmpty(t, key.Labels)
+	getRoute(t, fmt.Sprintf("%s/keys/moved", server.URL), 404)
+}
+
+func TestBackupConfirm(t *testing.T) {
+	server := setup(t)
+	defer server.Close()
+
+	addNP := AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc, VerifyBackup: true}
+	key := unmarshalLabeledKey(postRoute(t, fmt.Sprintf("%s/keys", server.URL), addNP.Marshal(), 200))
+	require.True(t, key.BackupUnconfirmed)
+	require.Len(t, key.BackupWords, 3)
+	require.False(t, key.MnemonicRetained)
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: "other", Password: testPass}.Marshal(), 200)
+
+	// test unconfirmed keys are flagged
+	keys := unmarshalLabeledKeys(getRoute(t, fmt.Sprintf("%s/keys?backup=unconfirmed", server.URL), 200))
+	require.Len(t, keys, 1)
+	require.Equal(t, testKey, keys[0].Name)
+	require.Equal(t, key.BackupWords, keys[0].BackupWords)
+	getRoute(t, fmt.Sprintf("%s/keys?backup=yes", server.URL), 400)
+
+	// test revealing the mnemonic
+	route := fmt.Sprintf("%s/keys/%s/backup", server.URL, testKey)
+	postRoute(t, route+"/reveal", BackupRevealBody{Password: "wrongpassword"}.Marshal(), 401)
+	var mn Mnemonic
+	require.NoError(t, json.Unmarshal(postRoute(t, route+"/reveal", BackupRevealBody{Password: testPass}.Marshal(), 200), &mn))
+	require.Equal(t, sMenominc, mn.Mnemonic)
+	postRoute(t, fmt.Sprintf("%s/keys/other/backup/reveal", server.URL), BackupRevealBody{Password: testPass}.Marshal(), 400)
+
+	// test wrong or missing words are rejected
+	mnemonic := strings.Fields(sMenominc)
+	words := make(map[int]string)
+	for _, pos := range key.BackupWords {
+		words[pos] = "wrong"
+	}
+	postRoute(t, route+"/confirm", BackupConfirmBody{Password: testPass, Words: words}.Marshal(), 400)
+	for _, pos := range key.BackupWords[:2] {
+		words[pos] = mnemonic[pos-1]
+	}
+	delete(words, key.BackupWords[2])
+	postRoute(t, route+"/confirm", BackupConfirmBody{Password: testPass, Words: words}.Marshal(), 400)
+
+	// test confirming the backup drops the mnemonic
+	words[key.BackupWords[2]] = strings.ToUpper(mnemonic[key.BackupWords[2]-1])
+	postRoute(t, route+"/confirm", BackupConfirmBody{Password: "wrongpassword", Words: words}.Marshal(), 401)
+	key = unmarshalLabeledKey(postRoute(t, route+"/confirm", BackupConfirmBody{Password: testPass, Words: words}.Marshal(), 200))
+	require.False(t, key.BackupUnconfirmed)
+	require.Empty(t, key.BackupWords)
+	require.Len(t, unmarshalLabeledKeys(getRoute(t, fmt.Sprintf("%s/keys?backup=unconfirmed", server.URL), 200)), 0)
+	postRoute(t, route+"/reveal", BackupRevealBody{Password: testPass}.Marshal(), 400)
+	postRoute(t, route+"/confirm", BackupConfirmBody{Password: testPass, Words: words}.Marshal(), 400)
+
+	// test labels patched while a backup is confirmed are kept
+	ks := NewMemoryKeystore()
+	s := &Server{Node: "tcp://127.0.0.1:1"}
+	s.SetKeystore(slowKeystore{ks})
+	server = httptest.NewServer(s.Router())
+	defer server.Close()
+	route = fmt.Sprintf("%s/keys/%s/backup", server.URL, testKey)
+	key = unmarshalLabeledKey(postRoute(t, fmt.Sprintf("%s/keys", server.URL), addNP.Marshal(), 200))
+	words = make(map[int]string)
+	for _, pos := range key.BackupWords {
+		words[pos] = mnemonic[pos-1]
+	}
+	labels := []string{"patched"}
+	patched := make(chan struct{})
+	go func() {
+		defer close(patched)
+		doRoute(t, http.MethodPatch, fmt.Sprintf("%s/keys/%s", server.URL, testKey), PatchKeyBody{Labels: &labels}.Marshal(), 200)
+	}()
+	postRoute(t, route+"/confirm", BackupConfirmBody{Password: testPass, Words: words}.Marshal(), 200)
+	<-patched
+	key = unmarshalLabeledKey(getRoute(t, fmt.Sprintf("%s/keys/%s", server.URL, testKey), 200))
+	require.Equal(t, labels, key.Labels)
+	require.False(t, key.BackupUnconfirmed)
+}
+
+func TestKeystores(t *testing.T) {
+	dir := tempDir(t)
+	path := filepath.Join(dir, "keys.enc")
+	stores := map[string]*Server{
+		KeystoreMemory: {KeyDir: dir, Node: "tcp://127.0.0.1:1", Keystore: KeystoreConfig{Type: KeystoreMemory}},
+		KeystoreFile:   {KeyDir: dir, Node: "tcp://127.0.0.1:1", Keystore: KeystoreConfig{Type: KeystoreFile, Password: testPass}},
+	}
+
+	for typ, s := range stores {
+		require.NoError(t, s.OpenKeystore(), typ)
+		server := httptest.NewServer(s.Router())
+
+		// test keys, metadata and signing work against the keystore
+		addNP := AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc, Labels: []string{typ}}
+		postRoute(t, fmt.Sprintf("%s/keys", server.URL), addNP.Marshal(), 200)
+		postRoute(t, fmt.Sprintf("%s/keys/offline", server.URL), OfflineKeyBody{Name: "cold", Address: sAcc}.Marshal(), 200)
+		keys := unmarshalLabeledKeys(getRoute(t, fmt.Sprintf("%s/keys", server.URL), 200))
+		require.Len(t, keys, 2, typ)
+		require.Equal(t, []string{typ}, keys[1].Labels)
+
+		sb := SignBody{Tx: []byte(`{"type":"cosmos-sdk/StdTx","value":{"msg":[],"fee":{"amount":[],"gas":"0"},"signatures":null,"memo":""}}`), Name: testKey, Passphrase: testPass, ChainID: "testing", AccountNumber: "0", Sequence: "0"}
+		postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 200)
+		server.Close()
+	}
+
+	// test nothing was written to the key directory
+	_, err := os.Stat(filepath.Join(dir, "keys"))
+	require.True(t, os.IsNotExist(err))
+
+	// test the file keystore is encrypted and reloads
+	bz, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(bz), testKey)
+
+	// test the file can't be opened twice, another process would erase the keys written here
+	_, err = NewFileKeystore(path, testPass)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "in use")
+	require.NoError(t, stores[KeystoreFile].keystore.(*fileKeystore).close())
+
+	_, err = NewFileKeystore(path, "wrongpassword")
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "in use")
+
+	reopened := &Server{KeyDir: dir, Keystore: KeystoreConfig{Type: KeystoreFile, Path: path, Password: testPass}}
+	require.NoError(t, reopened.OpenKeystore())
+	server := httptest.NewServer(reopened.Router())
+	defer server.Close()
+	key := unmarshalLabeledKey(getRoute(t, fmt.Sprintf("%s/keys/%s", server.URL, testKey), 200))
+	require.Equal(t, sAcc, key.Address)
+	require.Equal(t, []string{KeystoreFile}, key.Labels)
+	getRoute(t, fmt.Sprintf("%s/keys/cold", server.URL), 200)
+
+	// test reads don't rewrite the file, each write is sealed with a fresh nonce
+	bz, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	getRoute(t, fmt.Sprintf("%s/keys", server.URL), 200)
+	getRoute(t, fmt.Sprintf("%s/keys/%s", server.URL, testKey), 200)
+	unchanged, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, bz, unchanged)
+	labels := []string{"patched"}
+	doRoute(t, http.MethodPatch, fmt.Sprintf("%s/keys/cold", server.URL), PatchKeyBody{Labels: &labels}.Marshal(), 200)
+	changed, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NotEqual(t, bz, changed)
+
+	deleteRoute(t, fmt.Sprintf("%s/keys/%s", server.URL, testKey), DeleteKeyBody{Password: testPass}.Marshal(), 200)
+	require.NoError(t, reopened.keystore.(*fileKeystore).close())
+
+	reopened = &Server{KeyDir: dir, Keystore: KeystoreConfig{Type: KeystoreFile, Password: testPass}}
+	require.NoError(t, reopened.OpenKeystore())
+	kb, err := reopened.keybase()
+	require.NoError(t, err)
+	infos, err := kb.List()
+	require.NoError(t, err)
+	require.Len(t, infos, 0)
+
+	// test bad configs are rejected
+	require.Error(t, (&Server{Keystore: KeystoreConfig{Type: "s3"}}).OpenKeystore())
+	require.Error(t, (&Server{KeyDir: dir, Keystore: KeystoreConfig{Type: KeystoreFile}}).OpenKeystore())
+}
+
+func TestErrorCodes(t *testing.T) {
+	node := mockNode(t, map[string]interface{}{
+		"abci_query":        &ctypes.ResultABCIQuery{Response: abci.ResponseQuery{Code: 5, Codespace: "sdk", Log: "insufficient funds"}},
+		"broadcast_tx_sync": &ctypes.ResultBroadcastTx{Code: 4, Log: `{"codespace":"sdk","code":4,"message":"signature verification failed"}`},
+	})
+	defer node.Close()
+	s := &Server{KeyDir: tempDir(t), Node: node.URL}
+	s.SetKeystore(NewMemoryKeystore())
+	server := httptest.NewServer(s.Router())
+	defer server.Close()
+	postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: testKey, Password: testPass, Mnemonic: sMenominc}.Marshal(), 200)
+	send := BankSendBody{Sender: sdk.AccAddress(tmhash.SumTruncated([]byte("a"))).String(), Reciever: sdk.AccAddress(tmhash.SumTruncated([]byte("b"))).String(), Amount: "1stake"}.Marshal()
+
+	// test errors carry a code with a consistent status
+	for _, tc := range []struct {
+		method, path string
+		body         []byte
+		status       int
+		code         string
+	}{
+		{http.MethodGet, "/keys/missing", nil, 404, CodeKeyNotFound},
+		{http.MethodGet, "/keys?chain=missing", nil, 400, CodeUnknownChain},
+		{http.MethodPost, "/keys", AddNewKey{Name: testKey, Password: testPass}.Marshal(), 409, CodeKeyExists},
+		{http.MethodPost, "/keys", AddNewKey{Name: "bad", Password: testPass, Mnemonic: "foo bar"}.Marshal(), 400, CodeInvalidMnemonic},
+		{http.MethodPost, "/keys", []byte("{"), 400, CodeInvalidRequest},
+		{http.MethodPost, "/tx/sign", SignBody{Tx: []byte(`{"type":"cosmos-sdk/StdTx","value":{"msg":[],"fee":{"amount":[],"gas":"0"},"signatures":null,"memo":""}}`), Name: testKey, Passphrase: "wrongpassword", ChainID: "testing", AccountNumber: "0", Sequence: "0"}.Marshal(), 401, CodeWrongPassword},
+		{http.MethodPost, "/tx/bank/send", send, 422, CodeSimulationFailed},
+	} {
+		e := unmarshalError(doRoute(t, tc.method, server.URL+tc.path, tc.body, tc.status))
+		require.Equal(t, tc.code, e.Code, tc.path)
+		require.NotEmpty(t, e.Error)
+		require.NotEmpty(t, e.RequestID)
+	}
+
+	// test details and the abci codespace and code are passed through
+	e := unmarshalError(postRoute(t, fmt.Sprintf("%s/keys", server.URL), AddNewKey{Name: testKey, Password: testPass}.Marshal(), 409))
+	require.Equal(t, map[string]interface{}{"name": testKey}, e.Details)
+	e = unmarshalError(postRoute(t, fmt.Sprintf("%s/tx/bank/send", server.URL), send, 422))
+	require.Equal(t, "sdk", e.Codespace)
+	require.Equal(t, uint32(5), e.ABCICode)
+	require.Equal(t, "insufficient funds", e.Error)
+
+	// test transactions failing CheckTx are rejected with their codespace and code
+	tx := []byte(`{"type":"cosmos-sdk/StdTx","value":{"msg":[],"fee":{"amount":[],"gas":"0"},"signatures":null,"memo":""}}`)
+	e = unmarshalError(postRoute(t, fmt.Sprintf("%s/tx/broadcast", server.URL), tx, 422))
+	require.Equal(t, CodeChainRejected, e.Code)
+	require.Equal(t, "sdk", e.Codespace)
+	require.Equal(t, uint32(4), e.ABCICode)
+	require.Equal(t, "signature verification failed", e.Error)
+
+	// test an unreachable node
+	node.Close()
+	e = unmarshalError(postRoute(t, fmt.Sprintf("%s/tx/bank/send", server.URL), send, 502))
+	require.Equal(t, CodeNodeUnavailable, e.Code)
+	e = unmarshalError(postRoute(t, fmt.Sprintf("%s/tx/broadcast", server.URL), tx, 502))
+	require.Equal(t, CodeNodeUnavailable, e.Code)
+
+	// test errors without a code are written with their status
+	for _, status := range []int{http.StatusBadRequest, http.StatusBadGateway, http.StatusServiceUnavailable} {
+		w := httptest.NewRecorder()
+		writeError(w, httptest.NewRequest(http.MethodGet, "/", nil), status, fmt.Errorf("failed"))
+		require.Equal(t, status, w.Code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := tempDir(t)
+	valid := func() *Server {
+		return &Server{Port: 3000, KeyDir: filepath.Join(dir, "keys"), Node: "http://localhost:26657"}
+	}
+	// test validating doesn't create the key directory, the server creates it at startup
+	require.NoError(t, valid().Validate())
+	_, err := os.Stat(valid().KeyDir)
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, valid().CreateKeyDir())
+	_, err = os.Stat(valid().KeyDir)
+	require.NoError(t, err)
+	require.NoError(t, valid().Validate())
+
+	// test invalid ports, nodes, key directories and values are rejected
+	file := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(file, nil, 0600))
+	for _, edit := range []func(s *Server){
+		func(s *Server) { s.Port = 0 },
+		func(s *Server) { s.Port = 70000 },
+		func(s *Server) { s.Node = "" },
+		func(s *Server) { s.Node = "localhost:26657" },
+		func(s *Server) { s.Node = "http://" },
+		func(s *Server) { s.KeyDir = "" },
+		func(s *Server) { s.KeyDir = filepath.Join(file, "keys") },
+		func(s *Server) { s.KeyDir = file },
+		func(s *Server) { s.Keystore.Type = "s3" },
+		func(s *Server) { s.MnemonicWords = 13 },
+		func(s *Server) { s.LogLevel = "loud" },
+		func(s *Server) { s.Chains = []Chain{{Name: "terra"}} },
+	} {
+		s := valid()
+		edit(s)
+		require.Error(t, s.Validate(), "%+v", s)
+	}
+	require.Error(t, (&Server{KeyDir: file}).CreateKeyDir())
+}
+
+func TestReload(t *testing.T) {
+	dir := tempDir(t)
+	s := &Server{Port: 3000, KeyDir: dir, Node: "http://localhost:26657"}
+	s.SetKeystore(NewMemoryKeystore())
+	server := httptest.NewServer(s.Router())
+	defer server.Close()
+
+	// test valid configs are applied and settings read at startup are reported
+	restart, err := s.Reload(func() (*Server, error) {
+		return &Server{Port: 3001, KeyDir: dir, Node: "http://node:26657", Chains: []Chain{{Name: "terra", Node: "http://terra:26657", Bech32Prefix: "terra", CoinType: 330}}}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"port"}, restart)
+	require.Equal(t, 3000, s.Port)
+	require.Equal(t, "http://node:26657", s.config().Node)
+	getRoute(t, fmt.Sprintf("%s/keys?chain=terra", server.URL), 200)
+
+	// test invalid configs are rejected and the current one is kept
+	_, err = s.Reload(func() (*Server, error) {
+		return &Server{Port: 3000, KeyDir: dir, Node: "node:26657"}, nil
+	})
+	require.Error(t, err)
+	_, err = s.Reload(func() (*Server, error) { return nil, fmt.Errorf("malformed config") })
+	require.Error(t, err)
+	require.Equal(t, "http://node:26657", s.config().Node)
+	getRoute(t, fmt.Sprintf("%s/keys?chain=terra", server.URL), 200)
+
+	// test reloading doesn't wait for requests in flight, which keep their config
+	ks := blockingKeystore{NewMemoryKeystore(), make(chan struct{}, 1), make(chan struct{}), make(chan struct{})}
+	blocked := &Server{Port: 3000, KeyDir: dir, Node: "http://localhost:26657", Chains: []Chain{{Name: "terra", Node: "http://terra:26657", Bech32Prefix: "terra", CoinType: 330}}}
+	blocked.SetKeystore(ks)
+	reloading := httptest.NewServer(blocked.Router())
+	defer reloading.Close()
+	ks.block <- struct{}{}
+	statuses := make(chan int)
+	go func() {
+		resp, err := http.Get(fmt.Sprintf("%s/keys?chain=terra", reloading.URL))
+		if err != nil {
+			statuses <- 0
+			return
+		}
+		resp.Body.Close()
+		statuses <- resp.StatusCode
+	}()
+	<-ks.started
+	reloaded := make(chan error)
+	go func() {
+		_, err := blocked.Reload(func() (*Server, error) { return &Server{Port: 3000, KeyDir: dir, Node: "http://node:26657"}, nil })
+		reloaded <- err
+	}()
+	select {
+	case err := <-reloaded:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload waited for the request in flight")
+	}
+	getRoute(t, fmt.Sprintf("%s/keys?chain=terra", reloading.URL), 400)
+	close(ks.release)
+	require.Equal(t, 200, <-statuses)
+
+	// test the reloads are counted in the metrics
+	metrics := string(getRoute(t, fmt.Sprintf("%s/metrics", server.URL), 200))
+	require.Contains(t, metrics, `keyserver_config_reloads_total{result="success"} 1`)
+	require.Contains(t, metrics, `keyserver_config_reloads_total{result="failure"} 2`)
+	require.Contains(t, metrics, "keyserver_config_last_reload_successful 0")
+}
+
+func TestVaultSigner(t *testing.T) {
+	priv, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	var requests int32
+	vault := mockVault(t, "root", map[string]*btcec.PrivateKey{"validator": priv}, &requests)
+	defer vault.Close()
+
+	s := &Server{KeyDir: tempDir(t), Node: "tcp://127.0.0.1:1", Vault: VaultConfig{Address: vault.URL, Token: "root"}}
+	server := httptest.NewServer(s.Router())
+	defer server.Close()
+
+	// test registering a vault key
+	var pub secp256k1.PubKeySecp256k1
+	copy(pub[:], priv.PubKey().SerializeCompressed())
+	rb := RemoteKeyBody{Name: "vaulted", Backend: SignerVault, KeyName: "validator", Password: testPass}
+	key := unmarshalLabeledKey(postRoute(t, fmt.Sprintf("%s/keys/remote", server.URL), rb.Marshal(), 200))
+	require.Equal(t, SignerVault, key.Type)
+	require.Equal(t, sdk.AccAddress(pub.Address()).String(), key.Address)
+	require.Len(t, unmarshalLabeledKeys(getRoute(t, fmt.Sprintf("%s/keys?type=vault", server.URL), 200)), 1)
+
+	postRoute(t, fmt.Sprintf("%s/keys/remote", server.URL), RemoteKeyBody{Name: "missing", Backend: SignerVault, Password: testPass}.Marshal(), 502)
+	postRoute(t, fmt.Sprintf("%s/keys/remote", server.URL), RemoteKeyBody{Name: "other", Backend: "kms", Password: testPass}.Marshal(), 400)
+	postRoute(t, fmt.Sprintf("%s/keys/remote", server.URL), RemoteKeyBody{Name: "other", Backend: SignerVault, KeyName: "validator"}.Marshal(), 400)
+	postRoute(t, fmt.Sprintf("%s/keys/remote", server.URL), rb.Marshal(), 409)
+
+	// test key names that would leave the transit key's path are rejected before reaching vault
+	registered := atomic.LoadInt32(&requests)
+	for _, keyName := range []string{"../keys/validator", "validator/rotate", "validator?version=1", ".validator"} {
+		postRoute(t, fmt.Sprintf("%s/keys/remote", server.URL), RemoteKeyBody{Name: "other", Backend: SignerVault, KeyName: keyName, Password: testPass}.Marshal(), 400)
+	}
+	signer, err := NewVaultSigner(s.Vault)
+	require.NoError(t, err)
+	_, err = signer.Sign("../keys/validator", []byte("msg"))
+	require.Error(t, err)
+	require.Equal(t, registered, atomic.LoadInt32(&requests))
+
+	// test signing without the key's password never reaches vault
+	sb := SignBody{Tx: []byte(`{"type":"cosmos-sdk/StdTx","value":{"msg":[],"fee":{"amount":[],"gas":"0"},"signatures":null,"memo":"vault"}}`), Name: "vaulted", ChainID: "testing", AccountNumber: "3", Sequence: "7"}
+	postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 401)
+	sb.Passphrase = "wrongpassword"
+	postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 401)
+	require.Equal(t, registered, atomic.LoadInt32(&requests))
+
+	// test signing with the vault key only asks vault for the signature
+	sb.Passphrase = testPass
+	var tx auth.StdTx
+	require.NoError(t, cdc.UnmarshalJSON(postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 200), &tx))
+	require.Equal(t, registered+1, atomic.LoadInt32(&requests))
+	require.Len(t, tx.Signatures, 1)
+	require.Equal(t, pub, tx.Signatures[0].PubKey)
+	require.True(t, pub.VerifyBytes(auth.StdSignBytes("testing", 3, 7, tx.Fee, tx.Msgs, tx.Memo), tx.Signatures[0].Signature))
+
+	// test the password of a vault key is changed in its metadata
+	route := fmt.Sprintf("%s/keys/vaulted", server.URL)
+	putRoute(t, route, UpdateKeyBody{OldPassword: "wrongpassword", NewPassword: "newpassword"}.Marshal(), 401)
+	putRoute(t, route, UpdateKeyBody{OldPassword: testPass}.Marshal(), 400)
+	putRoute(t, route, UpdateKeyBody{OldPassword: testPass, NewPassword: "newpassword"}.Marshal(), 204)
+	postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 401)
+	sb.Passphrase = "newpassword"
+	postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 200)
+
+	// test vault errors fail the request
+	s.Vault.Token = "expired"
+	postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 502)
+
+	// test deleting a vault key requires its password
+	deleteRoute(t, route, DeleteKeyBody{}.Marshal(), 401)
+	deleteRoute(t, route, DeleteKeyBody{Password: testPass}.Marshal(), 401)
+	getRoute(t, route, 200)
+	deleteRoute(t, route, DeleteKeyBody{Password: "newpassword"}.Marshal(), 200)
+	getRoute(t, route, 404)
+}
+
+func TestHSMSignerUnavailable(t *testing.T) {
+	s := &Server{KeyDir: tempDir(t), Node: "tcp://127.0.0.1:1"}
+	server := httptest.NewServer(s.Router())
+	defer server.Close()
+
+	// test hsm keys can't be registered without a configured module
+	postRoute(t, fmt.Sprintf("%s/keys/remote", server.URL), RemoteKeyBody{Name: "operator", Backend: SignerHSM, Password: testPass}.Marshal(), 400)
+	getRoute(t, fmt.Sprintf("%s/keys/operator", server.URL), 404)
+}
+
+func TestPrivValidator(t *testing.T) {
+	s := &Server{KeyDir: tempDir(t)}
+
+	// test the consensus key is generated once
+	pv, err := s.PrivValidator("validator")
+	require.NoError(t, err)
+	loaded, err := s.PrivValidator("validator")
+	require.NoError(t, err)
+	require.Equal(t, pv.GetPubKey(), loaded.GetPubKey())
+	_, err = s.PrivValidator("../validator")
+	require.Error(t, err)
+
+	// test a second signer can't use the key while it is locked
+	release, err := s.LockPrivValidator("validator")
+	require.NoError(t, err)
+	_, err = s.LockPrivValidator("validator")
+	require.Error(t, err)
+	other, err := s.LockPrivValidator("other")
+	require.NoError(t, err)
+	require.NoError(t, other())
+
+	// test signing for a node over SecretConnection
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	node := privval.NewSignerValidatorEndpoint(log.NewNopLogger(), privval.NewTCPListener(ln, ed25519.GenPrivKey()))
+
+	stop, done := make(chan struct{}), make(chan error)
+	go func() {
+		done <- ServePrivValidator(log.NewNopLogger(), pv, "testing", "tcp://"+ln.Addr().String(), stop)
+	}()
+	require.NoError(t, node.Start())
+	require.Equal(t, pv.GetPubKey(), node.GetPubKey())
+
+	vote := &types.Vote{Type: types.PrevoteType, Height: 10, Timestamp: time.Now(), BlockID: types.BlockID{Hash: tmhash.Sum([]byte("block"))}, ValidatorAddress: pv.GetAddress()}
+	require.NoError(t, node.SignVote("testing", vote))
+	require.True(t, pv.GetPubKey().VerifyBytes(vote.SignBytes("testing"), vote.Signature))
+
+	// test a conflicting vote for the same height, round and step is refused
+	conflicting := &types.Vote{Type: types.PrevoteType, Height: 10, Timestamp: time.Now(), BlockID: types.BlockID{Hash: tmhash.Sum([]byte("other"))}, ValidatorAddress: pv.GetAddress()}
+	require.Error(t, node.SignVote("testing", conflicting))
+
+	close(stop)
+	require.NoError(t, <-done)
+	node.Stop()
+	require.NoError(t, release())
+	release, err = s.LockPrivValidator("validator")
+	require.NoError(t, err)
+	require.NoError(t, release())
+
+	// test the last sign state is persisted
+	loaded, err = s.PrivValidator("validator")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), loaded.LastSignState.Height)
+
+	// test importing a node's key along with its state, or explicitly without one
+	keyFile, stateFile, err := s.privValFiles("validator")
+	require.NoError(t, err)
+	require.Error(t, s.ImportPrivValidator("validator", keyFile, stateFile))
+	require.NoError(t, s.ImportPrivValidator("imported", keyFile, stateFile))
+	imported, err := s.PrivValidator("imported")
+	require.NoError(t, err)
+	require.Equal(t, pv.GetPubKey(), imported.GetPubKey())
+	require.Equal(t, int64(10), imported.LastSignState.Height)
+	require.NoError(t, s.ImportPrivValidator("fresh", keyFile, ""))
+	imported, err = s.PrivValidator("fresh")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), imported.LastSignState.Height)
+
+	// test a key without its state isn't loaded
+	_, stateFile, err = s.privValFiles("imported")
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(stateFile))
+	_, err = s.PrivValidator("imported")
+	require.Error(t, err)
+}
+
+// mockVault serves the transit engine's key read and sign endpoints for secp256k1 keys,
+// returning signatures with a high S to check they are normalized, and counts requests
+func mockVault(t *testing.T, token string, keys map[string]*btcec.PrivateKey, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/transit/"), "/")
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		priv, ok := keys[parts[len(parts)-1]]
+		if len(parts) != 2 || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":["no such key"]}`))
+			return
+		}
+
+		switch parts[0] {
+		case "keys":
+			spki, err := asn1.Marshal(struct {
+				Algorithm pkix.AlgorithmIdentifier
+				PublicKey asn1.BitString
+			}{
+				Algorithm: pkix.AlgorithmIdentifier{
+					Algorithm:  asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1},
+					Parameters: asn1.RawValue{FullBytes: []byte{0x06, 0x05, 0x2b, 0x81, 0x04, 0x00, 0x0a}},
+				},
+				PublicKey: asn1.BitString{Bytes: priv.PubKey().SerializeUncompressed(), BitLength: 65 * 8},
+			})
+			require.NoError(t, err)
+			pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: spki})
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+				"type":           "ecdsa-secp256k1",
+				"latest_version": 1,
+				"keys":           map[string]interface{}{"1": map[string]string{"public_key": string(pemKey)}},
+			}})
+		case "sign":
+			var req struct {
+				Input     string `json:"input"`
+				Prehashed bool   `json:"prehashed"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			require.True(t, req.Prehashed)
+			hash, err := base64.StdEncoding.DecodeString(req.Input)
+			require.NoError(t, err)
+			sig, err := priv.Sign(hash)
+			require.NoError(t, err)
+			der, err := asn1.Marshal(struct{ R, S *big.Int }{sig.R, new(big.Int).Sub(btcec.S256().N, sig.S)})
+			require.NoError(t, err)
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{
+				"signature": "vault:v1:" + base64.StdEncoding.EncodeToString(der),
+			}})
+		}
+	}))
+}
+
+func unmarshalLabeledKeys(ko []byte) (out []KeyOutput) {
+	err := json.Unmarshal(ko, &out)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+func unmarshalDerivedKeys(in []byte) (out []DerivedKey) {
+	err := json.Unmarshal(in, &out)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+func unmarshalLabeledKey(ko []byte) (out KeyOutput) {
+	err := json.Unmarshal(ko, &out)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
 func unmarshalError(in []byte) (out restError) {
 	err := json.Unmarshal(in, &out)
 	if err != nil {
@@ -110,12 +1540,128 @@ func unmarshalKeysOutput(ko []byte) (out []ckeys.KeyOutput) {
 }
 
 func setup(t *testing.T) *httptest.Server {
+	s := &Server{KeyDir: tempDir(t), Node: "tcp://127.0.0.1:1"}
+	return httptest.NewServer(s.Router())
+}
+
+func tempDir(t *testing.T) string {
 	dir, err := ioutil.TempDir("", "")
 	if err != nil {
 		t.Fatal(err)
 	}
-	s := &Server{KeyDir: dir}
-	return httptest.NewServer(s.Router())
+	return dir
+}
+
+// slowKeystore delays metadata access so concurrent requests interleave
+type slowKeystore struct {
+	Keystore
+}
+
+func (ks slowKeystore) Meta(fn func(db dbm.DB) error) error {
+	time.Sleep(time.Millisecond)
+	return ks.Keystore.Meta(fn)
+}
+
+// blockingKeystore blocks opening the keybase once there is a value in
+// block, closing started and waiting for release to be closed
+type blockingKeystore struct {
+	Keystore
+	block, started, release chan struct{}
+}
+
+func (ks blockingKeystore) Keybase() (ckeys.Keybase, error) {
+	select {
+	case <-ks.block:
+		close(ks.started)
+		<-ks.release
+	default:
+	}
+	return ks.Keystore.Keybase()
+}
+
+// noKeybaseKeystore fails to open its keybase but serves metadata
+type noKeybaseKeystore struct {
+	Keystore
+}
+
+func (ks noKeybaseKeystore) Keybase() (ckeys.Keybase, error) {
+	return nil, fmt.Errorf("keybase unavailable")
+}
+
+// failingKeystore opens a keybase whose method named fail returns an error
+// for every key, or only for the key named name if it is set
+type failingKeystore struct {
+	Keystore
+	fail, name string
+}
+
+type failingKeybase struct {
+	ckeys.Keybase
+	fail, name string
+}
+
+func (ks failingKeystore) Keybase() (ckeys.Keybase, error) {
+	kb, err := ks.Keystore.Keybase()
+	return failingKeybase{kb, ks.fail, ks.name}, err
+}
+
+// Meta fails writing metadata if fail is Meta, the metadata read is empty
+func (ks failingKeystore) Meta(fn func(db dbm.DB) error) error {
+	if ks.fail != "Meta" {
+		return ks.Keystore.Meta(fn)
+	}
+	db := &dirtyDB{DB: dbm.NewMemDB()}
+	if err := fn(db); err != nil || !db.dirty {
+		return err
+	}
+	return fmt.Errorf("metadata write failed")
+}
+
+func (kb failingKeybase) failed(method, name string) error {
+	if kb.fail == method && (kb.name == "" || kb.name == name) {
+		return fmt.Errorf("keybase %s of %s failed", method, name)
+	}
+	return nil
+}
+
+func (kb failingKeybase) Update(name, oldpass string, getNewPass func() (string, error)) error {
+	if err := kb.failed("Update", name); err != nil {
+		return err
+	}
+	return kb.Keybase.Update(name, oldpass, getNewPass)
+}
+
+func (kb failingKeybase) Delete(name, passphrase string, skipPass bool) error {
+	if err := kb.failed("Delete", name); err != nil {
+		return err
+	}
+	return kb.Keybase.Delete(name, passphrase, skipPass)
+}
+
+func (kb failingKeybase) ImportPrivKey(name, armor, passphrase string) error {
+	if err := kb.failed("ImportPrivKey", name); err != nil {
+		return err
+	}
+	return kb.Keybase.ImportPrivKey(name, armor, passphrase)
+}
+
+// mockNode serves the tendermint JSON-RPC methods in results, results are
+// marshaled when the request arrives so tests can mutate them in between calls
+func mockNode(t *testing.T, results map[string]interface{}) *httptest.Server {
+	rpccdc := amino.NewCodec()
+	ctypes.RegisterAmino(rpccdc)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req rpctypes.RPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		result, ok := results[req.Method]
+		if !ok {
+			json.NewEncoder(w).Encode(rpctypes.RPCMethodNotFoundError(req.ID))
+			return
+		}
+		json.NewEncoder(w).Encode(rpctypes.NewRPCSuccessResponse(rpccdc, req.ID, result))
+	}))
 }
 
 func getRoute(t *testing.T, route string, expStatus int) []byte {
@@ -169,6 +1715,26 @@ func putRoute(t *testing.T, route string, data []byte, expStatus int) []byte {
 	return out
 }
 
+func doRoute(t *testing.T, method, route string, data []byte, expStatus int) []byte {
+	req, err := http.NewRequest(method, route, bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != expStatus {
+		t.Fatalf("Expected status '%d', got '%d' -> route %s %s\n", expStatus, resp.StatusCode, method, route)
+	}
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
 func deleteRoute(t *testing.T, route string, data []byte, expStatus int) []byte {
 	client := &http.Client{}
 	req, err := http.NewRequest(http.MethodDelete, route, bytes.NewBuffer(data))
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	ckeys "github.com/cosmos/cosmos-sdk/crypto/keys"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/keyerror"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/tendermint/tendermint/crypto/armor"
+)
+
+const (
+	armorTypePrivKey = "TENDERMINT PRIVATE KEY"
+	armorTypePubKey  = "TENDERMINT PUBLIC KEY"
+)
+
+// ExportKeyBody is the body for an export request, the private key is
+// decrypted with Password and the armor encrypted with ExportPassword,
+// which defaults to Password
+type ExportKeyBody struct {
+	Password       string `json:"password"`
+	ExportPassword string `json:"export_password,omitempty"`
+}
+
+// Marshal returns the json byte representation of the export body
+func (eb ExportKeyBody) Marshal() []byte {
+	out, err := json.Marshal(eb)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// KeyArmor is an ASCII armored private or public key
+type KeyArmor struct {
+	Name  string `json:"name"`
+	Armor string `json:"armor"`
+}
+
+// Marshal returns the json byte representation of the armored key
+func (ka KeyArmor) Marshal() []byte {
+	out, err := json.Marshal(ka)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// ExportKey is the handler for the POST /keys/{name}/export, with ?pubkey=true
+// only the armored public key is returned and no body is needed
+func (s *Server) ExportKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	kb, err := s.keybase()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if _, err = kb.Get(name); keyerror.IsErrKeyNotFound(err) {
+		writeError(w, r, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	var out string
+	if r.URL.Query().Get("pubkey") == "true" {
+		out, err = kb.ExportPubKey(name)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	} else {
+		var m ExportKeyBody
+		err = json.NewDecoder(r.Body).Decode(&m)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if m.ExportPassword == "" {
+			m.ExportPassword = m.Password
+		}
+
+		out, err = kb.ExportPrivKey(name, m.Password, m.ExportPassword)
+		if keyerror.IsErrWrongPassword(err) {
+			writeError(w, r, http.StatusUnauthorized, err)
+			return
+		} else if err != nil {
+			writeError(w, r, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(KeyArmor{Name: name, Armor: out}.Marshal())
+	return
+}
+
+// ImportKeyBody is the body for an import request. Armored private keys are
+// decrypted with Passphrase and stored encrypted with Password, which
+// defaults to Passphrase. Armored public keys are stored as offline keys.
+type ImportKeyBody struct {
+	Name       string `json:"name"`
+	Armor      string `json:"armor"`
+	Passphrase string `json:"passphrase,omitempty"`
+	Password   string `json:"password,omitempty"`
+}
+
+// Marshal returns the json byte representation of the import body
+func (ib ImportKeyBody) Marshal() []byte {
+	out, err := json.Marshal(ib)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// ImportKey is the handler for the POST /keys/import
+func (s *Server) ImportKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var m ImportKeyBody
+
+	kb, err := s.keybase()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	chain, err := s.chain(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if m.Name == "" || m.Armor == "" {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("must include both name and armor with request"))
+		return
+	}
+
+	exists, err := s.keyExists(kb, m.Name)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	} else if exists {
+		writeError(w, r, http.StatusConflict, errKeyExists(m.Name))
+		return
+	}
+
+	blockType, _, _, err := armor.DecodeArmor(m.Armor)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid armor: %s", err))
+		return
+	}
+
+	switch blockType {
+	case armorTypePrivKey:
+		err = importPrivKey(kb, m)
+	case armorTypePubKey:
+		err = kb.ImportPubKey(m.Name, m.Armor)
+	default:
+		err = fmt.Errorf("unsupported armor type %s", blockType)
+	}
+	if keyerror.IsErrWrongPassword(errors.Cause(err)) {
+		writeError(w, r, http.StatusUnauthorized, err)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	info, err := kb.Get(m.Name)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	keyOutput, err := chain.keyOutput(info)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	out, err := json.Marshal(newKeyOutput(keyOutput, keyMeta{}))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+	return
+}
+
+// importPrivKey imports an armored private key and re-encrypts it with the new password
+func importPrivKey(kb ckeys.Keybase, m ImportKeyBody) error {
+	if m.Passphrase == "" {
+		return fmt.Errorf("passphrase is required to import a private key")
+	}
+
+	if err := kb.ImportPrivKey(m.Name, m.Armor, m.Passphrase); err != nil {
+		return err
+	}
+
+	if m.Password == "" || m.Password == m.Passphrase {
+		return nil
+	}
+	return kb.Update(m.Name, m.Passphrase, func() (string, error) { return m.Password, nil })
+}
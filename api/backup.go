@@ -0,0 +1,264 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/crypto/keys/keyerror"
+	"github.com/gorilla/mux"
+)
+
+// backupWords is how many words of the mnemonic an operator must supply to confirm a backup
+const backupWords = 3
+
+// backupCheck is a pending backup verification, the mnemonic is retained
+// encrypted with the key's password until the operator confirms it by
+// supplying the words at Words, which are 1-based positions
+type backupCheck struct {
+	Words []int         `json:"words"`
+	Seed  *sealedSecret `json:"seed"`
+}
+
+// newBackupCheck picks the words of the mnemonic the operator will be asked for
+func newBackupCheck(sd seed, password string) (*backupCheck, error) {
+	count := len(strings.Fields(sd.Mnemonic))
+	picked := make(map[int]bool, backupWords)
+	words := make([]int, 0, backupWords)
+	for len(words) < backupWords && len(words) < count {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(count)))
+		if err != nil {
+			return nil, err
+		}
+		if pos := int(n.Int64()) + 1; !picked[pos] {
+			picked[pos] = true
+			words = append(words, pos)
+		}
+	}
+	sort.Ints(words)
+
+	sealed, err := sealSeed(sd, password)
+	if err != nil {
+		return nil, err
+	}
+	return &backupCheck{Words: words, Seed: sealed}, nil
+}
+
+// BackupConfirmBody is the body for a backup confirmation, Words maps the
+// requested 1-based positions in the mnemonic to the words at them. The json
+// name keeps the words out of the logs along with the other mnemonic fields.
+type BackupConfirmBody struct {
+	Password string         `json:"password"`
+	Words    map[int]string `json:"mnemonic_words"`
+}
+
+// Marshal returns the json byte representation of the confirm body
+func (bb BackupConfirmBody) Marshal() []byte {
+	out, err := json.Marshal(bb)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// BackupRevealBody is the body for a mnemonic reveal request
+type BackupRevealBody struct {
+	Password string `json:"password"`
+}
+
+// Marshal returns the json byte representation of the reveal body
+func (bb BackupRevealBody) Marshal() []byte {
+	out, err := json.Marshal(bb)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// Mnemonic is a revealed mnemonic
+type Mnemonic struct {
+	Name     string `json:"name"`
+	Mnemonic string `json:"mnemonic"`
+}
+
+// Marshal returns the json byte representation of the mnemonic
+func (mn Mnemonic) Marshal() []byte {
+	out, err := json.Marshal(mn)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// ConfirmBackup is the handler for the POST /keys/{name}/backup/confirm. Once
+// the words match the retained mnemonic is dropped, unless the key was also
+// created with retain_mnemonic.
+func (s *Server) ConfirmBackup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	name := vars["name"]
+	var m BackupConfirmBody
+
+	kb, err := s.keybase()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	chain, err := s.chain(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	info, err := kb.Get(name)
+	if keyerror.IsErrKeyNotFound(err) {
+		writeError(w, r, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	meta, err := s.getMeta(name)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if meta.Backup == nil {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("key %s has no backup to confirm", name))
+		return
+	}
+
+	sd, err := meta.Backup.Seed.open(m.Password)
+	if keyerror.IsErrWrongPassword(err) {
+		writeError(w, r, http.StatusUnauthorized, err)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !meta.Backup.matches(sd, m.Words) {
+		writeError(w, r, http.StatusBadRequest, withCode(CodeInvalidMnemonic, fmt.Errorf("words don't match the mnemonic, supply the words at positions %v", meta.Backup.Words), "positions", meta.Backup.Words))
+		return
+	}
+
+	// the backup is dropped from the stored metadata under the lock, so
+	// changes made to the key while the words were checked aren't lost
+	confirmed := meta.Backup
+	status := http.StatusInternalServerError
+	meta, err = s.updateMeta(name, func(meta *keyMeta) error {
+		if meta.Backup == nil {
+			status = http.StatusBadRequest
+			return fmt.Errorf("key %s has no backup to confirm", name)
+		} else if !meta.Backup.Seed.equal(confirmed.Seed) {
+			status = http.StatusConflict
+			return fmt.Errorf("the backup of key %s changed while it was confirmed, try again", name)
+		}
+		meta.Backup = nil
+		return nil
+	})
+	if err != nil {
+		writeError(w, r, status, err)
+		return
+	}
+
+	keyOutput, err := chain.keyOutput(info)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	out, err := json.Marshal(newKeyOutput(keyOutput, meta))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+	return
+}
+
+// matches reports whether exactly the requested words were supplied and match the mnemonic
+func (bc *backupCheck) matches(sd seed, words map[int]string) bool {
+	mnemonic := strings.Fields(sd.Mnemonic)
+	if len(words) != len(bc.Words) {
+		return false
+	}
+	for _, pos := range bc.Words {
+		if strings.ToLower(strings.TrimSpace(words[pos])) != mnemonic[pos-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// RevealMnemonic is the handler for the POST /keys/{name}/backup/reveal, it
+// returns the mnemonic while the backup is unconfirmed or if it was retained
+func (s *Server) RevealMnemonic(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	name := vars["name"]
+	var m BackupRevealBody
+
+	kb, err := s.keybase()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err = kb.Get(name); keyerror.IsErrKeyNotFound(err) {
+		writeError(w, r, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	meta, err := s.getMeta(name)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	sealed := meta.Seed
+	if meta.Backup != nil {
+		sealed = meta.Backup.Seed
+	}
+	if sealed == nil {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("key %s has no retained mnemonic", name))
+		return
+	}
+
+	sd, err := sealed.open(m.Password)
+	if keyerror.IsErrWrongPassword(err) {
+		writeError(w, r, http.StatusUnauthorized, err)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(Mnemonic{Name: name, Mnemonic: sd.Mnemonic}.Marshal())
+	return
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 
@@ -9,33 +10,45 @@ import (
 	rpcclient "github.com/tendermint/tendermint/rpc/client"
 )
 
+// Broadcast handles the /tx/broadcast route
 func (s *Server) Broadcast(w http.ResponseWriter, r *http.Request) {
 	var stdTx auth.StdTx
+
+	chain, err := s.chain(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	cdc := chain.cdc()
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	err = cdc.UnmarshalJSON(body, &stdTx)
+	err = chain.encode(func() error {
+		return cdc.UnmarshalJSON(body, &stdTx)
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
 	txBytes, err := cdc.MarshalBinaryLengthPrefixed(stdTx)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	res, err := rpcclient.NewHTTP(s.Node, "/websocket").BroadcastTxAsync(txBytes)
+	res, err := rpcclient.NewHTTP(chain.Node, "/websocket").BroadcastTxSync(txBytes)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadGateway, withCode(CodeNodeUnavailable, err, "node", chain.Node))
+		return
+	}
+	if res.Code != 0 {
+		codespace, log := checkTxLog(res.Log)
+		writeError(w, r, http.StatusUnprocessableEntity, abciError(CodeChainRejected, codespace, res.Code, log))
 		return
 	}
 
@@ -43,3 +56,17 @@ func (s *Server) Broadcast(w http.ResponseWriter, r *http.Request) {
 	w.Write(cdc.MustMarshalJSON(sdk.NewResponseFormatBroadcastTx(res)))
 	return
 }
+
+// checkTxLog returns the codespace and message of a transaction that failed
+// CheckTx, the broadcast result has no codespace but the sdk writes both in
+// the log as json
+func checkTxLog(log string) (codespace, message string) {
+	var e struct {
+		Codespace string `json:"codespace"`
+		Message   string `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(log), &e); err != nil || e.Message == "" {
+		return "", log
+	}
+	return e.Codespace, e.Message
+}
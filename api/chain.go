@@ -0,0 +1,234 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	ckeys "github.com/cosmos/cosmos-sdk/crypto/keys"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	cmn "github.com/tendermint/tendermint/libs/common"
+	rpcclient "github.com/tendermint/tendermint/rpc/client"
+)
+
+// codecs are the transaction codecs a chain profile can select by name
+var codecs = map[string]*codec.Codec{
+	"gaia": cdc,
+}
+
+// Chain is a named chain profile, requests select one with the chain query parameter
+type Chain struct {
+	Name         string `json:"name"`
+	ChainID      string `json:"chain_id"`
+	Node         string `json:"node"`
+	Bech32Prefix string `json:"bech32_prefix"`
+	CoinType     uint32 `json:"coin_type"`
+	GasPrices    string `json:"gas_prices,omitempty"`
+	Codec        string `json:"codec,omitempty"`
+}
+
+// Validate checks that the profile can be used to serve requests
+func (c Chain) Validate() error {
+	if c.Name == "" {
+		return errors.New("chain profiles must have a name")
+	}
+	if c.Node == "" {
+		return fmt.Errorf("chain %s: node is required", c.Name)
+	}
+	if c.Bech32Prefix == "" {
+		return fmt.Errorf("chain %s: bech32_prefix is required", c.Name)
+	}
+	if _, ok := codecs[c.codecName()]; !ok {
+		return fmt.Errorf("chain %s: unknown codec %s", c.Name, c.Codec)
+	}
+	if c.GasPrices != "" {
+		if _, err := sdk.ParseDecCoins(c.GasPrices); err != nil {
+			return fmt.Errorf("chain %s: invalid gas_prices %s: %s", c.Name, c.GasPrices, err)
+		}
+	}
+	return nil
+}
+
+// ValidateChains checks every configured chain profile
+func (s *Server) ValidateChains() error {
+	seen := make(map[string]bool)
+	for _, c := range s.Chains {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("chain %s is defined more than once", c.Name)
+		}
+		seen[c.Name] = true
+	}
+	return nil
+}
+
+// defaultChain is the profile used when a request doesn't name a chain,
+// it uses the server's node with the cosmos hub prefixes and coin type
+func (s *Server) defaultChain() Chain {
+	return Chain{
+		Node:         s.Node,
+		Bech32Prefix: sdk.Bech32MainPrefix,
+		CoinType:     sdk.CoinType,
+	}
+}
+
+// chain returns the profile named by the request's chain query parameter
+func (s *Server) chain(r *http.Request) (Chain, error) {
+	name := r.URL.Query().Get("chain")
+	if name == "" {
+		return s.defaultChain(), nil
+	}
+	for _, c := range s.Chains {
+		if c.Name == name {
+			if err := c.Validate(); err != nil {
+				return c, withCode(CodeUnknownChain, err, "chain", name)
+			}
+			return c, nil
+		}
+	}
+	return Chain{}, withCode(CodeUnknownChain, fmt.Errorf("unknown chain %s", name), "chain", name)
+}
+
+func (c Chain) codecName() string {
+	if c.Codec == "" {
+		return "gaia"
+	}
+	return c.Codec
+}
+
+// cdc returns the codec for the chain, profiles are validated before use
+func (c Chain) cdc() *codec.Codec {
+	return codecs[c.codecName()]
+}
+
+// SimulateGas simulates gas for a transaction against the chain's node
+func (c Chain) SimulateGas(txbytes []byte) (res uint64, err error) {
+	result, err := rpcclient.NewHTTP(c.Node, "/websocket").ABCIQueryWithOptions(
+		"/app/simulate",
+		cmn.HexBytes(txbytes),
+		rpcclient.ABCIQueryOptions{},
+	)
+
+	if err != nil {
+		return 0, withCode(CodeNodeUnavailable, err, "node", c.Node)
+	}
+
+	if !result.Response.IsOK() {
+		return 0, abciError(CodeSimulationFailed, result.Response.Codespace, result.Response.Code, result.Response.Log)
+	}
+
+	var simulationResult sdk.Result
+	if err := c.cdc().UnmarshalBinaryLengthPrefixed(result.Response.Value, &simulationResult); err != nil {
+		return 0, err
+	}
+
+	return simulationResult.GasUsed, nil
+}
+
+// Fees returns the fees for gas at the given gas prices, rounded up
+func Fees(gasPrices string, gas uint64) (sdk.Coins, error) {
+	prices, err := sdk.ParseDecCoins(gasPrices)
+	if err != nil {
+		return nil, err
+	}
+
+	fees := make(sdk.Coins, len(prices))
+	for i, price := range prices {
+		fees[i] = sdk.NewCoin(price.Denom, price.Amount.MulInt64(int64(gas)).Ceil().RoundInt())
+	}
+	return fees.Sort(), nil
+}
+
+// chainLease serializes access to the process wide sdk config, which holds
+// the bech32 prefixes and coin type. Any number of requests for the same
+// chain can hold the lease at once, requests for another chain wait until
+// they have all released it. next is the first chain waiting for the lease.
+var chainLease = struct {
+	sync.Mutex
+	cond    *sync.Cond
+	current *Chain
+	next    *Chain
+	holders int
+}{}
+
+func init() {
+	chainLease.cond = sync.NewCond(&chainLease.Mutex)
+}
+
+// encode runs fn with the chain's prefixes and coin type applied to the sdk
+// config. The lease is only held while fn runs, so fn should only encode or
+// decode addresses and never wait on the node, a signer or the keybase. fn
+// must not call encode itself, the nested lease can wait on another chain.
+func (c Chain) encode(fn func() error) error {
+	defer c.use()()
+	return fn()
+}
+
+// keyOutput returns the output of a key with the chain's prefixes
+func (c Chain) keyOutput(info ckeys.Info) (ko ckeys.KeyOutput, err error) {
+	err = c.encode(func() (err error) {
+		ko, err = ckeys.Bech32KeyOutput(info)
+		return err
+	})
+	return ko, err
+}
+
+// addressOutput returns the output of a watch-only address with the chain's prefixes
+func (c Chain) addressOutput(name string, addr sdk.AccAddress, bechPrefix string) (ko ckeys.KeyOutput) {
+	c.encode(func() error {
+		ko = addressKeyOutput(name, addr, bechPrefix)
+		return nil
+	})
+	return ko
+}
+
+// use applies the chain's prefixes and coin type to the sdk config, the
+// returned function must be called once the caller no longer encodes or
+// decodes addresses. Once a request for another chain waits, new requests
+// for the current chain queue behind it so a busy chain can't starve others.
+func (c Chain) use() (release func()) {
+	chainLease.Lock()
+	for (chainLease.holders > 0 && *chainLease.current != c) || (chainLease.next != nil && *chainLease.next != c) {
+		if chainLease.next == nil {
+			chainLease.next = &c
+		}
+		chainLease.cond.Wait()
+	}
+	if chainLease.next != nil && *chainLease.next == c {
+		chainLease.next = nil
+	}
+	if chainLease.holders == 0 {
+		c.apply()
+		chainLease.current = &c
+	}
+	chainLease.holders++
+	chainLease.Unlock()
+
+	return func() {
+		chainLease.Lock()
+		chainLease.holders--
+		if chainLease.holders == 0 {
+			chainLease.cond.Broadcast()
+		}
+		chainLease.Unlock()
+	}
+}
+
+func (c Chain) apply() {
+	config := sdk.GetConfig()
+	config.SetBech32PrefixForAccount(c.Bech32Prefix, c.Bech32Prefix+sdk.PrefixPublic)
+	config.SetBech32PrefixForValidator(
+		c.Bech32Prefix+sdk.PrefixValidator+sdk.PrefixOperator,
+		c.Bech32Prefix+sdk.PrefixValidator+sdk.PrefixOperator+sdk.PrefixPublic,
+	)
+	config.SetBech32PrefixForConsensusNode(
+		c.Bech32Prefix+sdk.PrefixValidator+sdk.PrefixConsensus,
+		c.Bech32Prefix+sdk.PrefixValidator+sdk.PrefixConsensus+sdk.PrefixPublic,
+	)
+	config.SetCoinType(c.CoinType)
+	config.SetFullFundraiserPath(fmt.Sprintf("44'/%d'/0'/0/0", c.CoinType))
+}
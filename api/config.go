@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+)
+
+// Validate checks the configuration before serving, so a bad config fails at
+// startup rather than on the first request
+func (s *Server) Validate() error {
+	if s.Port < 1 || s.Port > 65535 {
+		return fmt.Errorf("invalid port %d, must be between 1 and 65535", s.Port)
+	}
+	if err := validateNode(s.Node); err != nil {
+		return err
+	}
+	if err := validateKeyDir(s.KeyDir); err != nil {
+		return err
+	}
+	switch s.Keystore.Type {
+	case "", KeystoreLevelDB, KeystoreFile, KeystoreMemory:
+	default:
+		return fmt.Errorf("unknown keystore type %s, must be leveldb, file or memory", s.Keystore.Type)
+	}
+	if s.MnemonicWords != 0 {
+		if _, err := newMnemonic(s.MnemonicWords); err != nil {
+			return err
+		}
+	}
+	if _, err := NewLogger(ioutil.Discard, s.LogFormat, s.LogLevel); err != nil {
+		return err
+	}
+	return s.ValidateChains()
+}
+
+// validateNode checks that node is the url of a tendermint rpc endpoint
+func validateNode(node string) error {
+	u, err := url.Parse(node)
+	if err != nil {
+		return fmt.Errorf("invalid node %s: %s", node, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "tcp":
+	default:
+		return fmt.Errorf("invalid node %q, must be an http://, https:// or tcp:// url", node)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid node %s, the host is missing", node)
+	}
+	return nil
+}
+
+// validateKeyDir checks that dir is set and, if it exists, is a directory.
+// It doesn't create it, Validate also checks configs that aren't served.
+func validateKeyDir(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("key_dir is required")
+	}
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("invalid key_dir %s: %s", dir, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("invalid key_dir %s, it isn't a directory", dir)
+	}
+	return nil
+}
+
+// CreateKeyDir creates the key directory if it doesn't exist and checks that
+// it is writable, the server calls it once at startup
+func (s *Server) CreateKeyDir() error {
+	if err := os.MkdirAll(s.KeyDir, 0700); err != nil {
+		return fmt.Errorf("invalid key_dir %s: %s", s.KeyDir, err)
+	}
+	f, err := ioutil.TempFile(s.KeyDir, ".write-test")
+	if err != nil {
+		return fmt.Errorf("key_dir %s isn't writable: %s", s.KeyDir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,346 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	ckeys "github.com/cosmos/cosmos-sdk/crypto/keys"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/hd"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/keyerror"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	bip39 "github.com/cosmos/go-bip39"
+	"github.com/tendermint/tendermint/crypto"
+	"github.com/tendermint/tendermint/crypto/secp256k1"
+)
+
+const (
+	// maxDeriveKeys caps how many keys a compute only derive request returns
+	maxDeriveKeys = 1000
+
+	// maxDeriveStoredKeys caps how many keys a derive request stores, each
+	// one is encrypted with bcrypt which takes a noticeable fraction of a second
+	maxDeriveStoredKeys = 100
+)
+
+// DeriveKeysBody is the body for a bulk derivation request. Keys are derived
+// from Mnemonic, or from the retained mnemonic of BaseKey which Password
+// decrypts, at m/44'/CoinType'/account'/0/index for every account and index in
+// the Accounts and Indexes ranges, e.g. "0-99" or "7", both default to "0".
+// Derived keys are stored under NameTemplate, where {account} and {index} are
+// replaced, encrypted with Password, unless ComputeOnly is set in which case
+// only their addresses and public keys are returned.
+type DeriveKeysBody struct {
+	BaseKey         string   `json:"base_key,omitempty"`
+	Mnemonic        string   `json:"mnemonic,omitempty"`
+	BIP39Passphrase string   `json:"bip39_passphrase,omitempty"`
+	Password        string   `json:"password,omitempty"`
+	CoinType        int      `json:"coin_type,string,omitempty"`
+	Accounts        string   `json:"accounts,omitempty"`
+	Indexes         string   `json:"indexes,omitempty"`
+	NameTemplate    string   `json:"name_template,omitempty"`
+	Labels          []string `json:"labels,omitempty"`
+	ComputeOnly     bool     `json:"compute_only,omitempty"`
+}
+
+// Marshal returns the json byte representation of the derive body
+func (db DeriveKeysBody) Marshal() []byte {
+	out, err := json.Marshal(db)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// DerivedKey is a key returned by POST /keys/derive, Name is empty for compute only requests
+type DerivedKey struct {
+	Name    string `json:"name,omitempty"`
+	HDPath  string `json:"hd_path"`
+	Address string `json:"address"`
+	PubKey  string `json:"pubkey"`
+}
+
+// DeriveKeys is the handler for the POST /keys/derive
+func (s *Server) DeriveKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var m DeriveKeysBody
+
+	chain, err := s.chain(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if (m.BaseKey == "") == (m.Mnemonic == "") {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("must include either a base_key or a mnemonic with request"))
+		return
+	}
+
+	if m.Password == "" && (m.BaseKey != "" || !m.ComputeOnly) {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("must include password with request"))
+		return
+	}
+
+	// computing keys from a mnemonic doesn't touch the keybase
+	var kb ckeys.Keybase
+	if m.BaseKey != "" || !m.ComputeOnly {
+		if kb, err = s.keybase(); err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	sd := seed{Mnemonic: m.Mnemonic, BIP39Passphrase: m.BIP39Passphrase}
+	if m.BaseKey != "" {
+		var status int
+		sd, status, err = s.retainedSeed(kb, m.BaseKey, m.Password)
+		if err != nil {
+			writeError(w, r, status, err)
+			return
+		}
+	} else if !bip39.IsMnemonicValid(sd.Mnemonic) {
+		writeError(w, r, http.StatusBadRequest, withCode(CodeInvalidMnemonic, fmt.Errorf("invalid mnemonic")))
+		return
+	}
+
+	if m.CoinType < 0 || m.CoinType > maxValidCoinTypeValue {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid coin type"))
+		return
+	}
+	coinType := chain.CoinType
+	if m.CoinType != 0 {
+		coinType = uint32(m.CoinType)
+	}
+
+	accounts, err := parseRange("accounts", m.Accounts, maxValidAccountValue)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	indexes, err := parseRange("indexes", m.Indexes, maxValidIndexalue)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	max := maxDeriveStoredKeys
+	if m.ComputeOnly {
+		max = maxDeriveKeys
+	}
+	if count := len(accounts) * len(indexes); count > max {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("request derives %d keys, at most %d can be derived at once", count, max))
+		return
+	}
+
+	var params []hd.BIP44Params
+	for _, account := range accounts {
+		for _, index := range indexes {
+			params = append(params, *hd.NewFundraiserParams(account, coinType, index))
+		}
+	}
+
+	var derived []DerivedKey
+	if m.ComputeOnly {
+		derived, err = computeKeys(chain, sd, params)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err)
+			return
+		}
+	} else {
+		var status int
+		derived, status, err = s.storeDerivedKeys(kb, chain, sd, m, params)
+		if err != nil {
+			writeError(w, r, status, err)
+			return
+		}
+	}
+
+	out, err := json.Marshal(derived)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+	return
+}
+
+// retainedSeed decrypts the mnemonic retained with the named key, it returns
+// the status to respond with on error
+func (s *Server) retainedSeed(kb ckeys.Keybase, name, password string) (seed, int, error) {
+	if _, err := kb.Get(name); keyerror.IsErrKeyNotFound(err) {
+		return seed{}, http.StatusNotFound, err
+	} else if err != nil {
+		return seed{}, http.StatusInternalServerError, err
+	}
+
+	meta, err := s.getMeta(name)
+	if err != nil {
+		return seed{}, http.StatusInternalServerError, err
+	}
+	if meta.Seed == nil {
+		return seed{}, http.StatusBadRequest, fmt.Errorf("key %s has no retained mnemonic, create it with retain_mnemonic to derive from it", name)
+	}
+
+	sd, err := meta.Seed.open(password)
+	if keyerror.IsErrWrongPassword(err) {
+		return seed{}, http.StatusUnauthorized, err
+	} else if err != nil {
+		return seed{}, http.StatusInternalServerError, err
+	}
+	return sd, 0, nil
+}
+
+// computeKeys derives the public keys and addresses at each path without
+// touching the keybase, the keys are derived before taking the chain's lease
+// to encode them
+func computeKeys(chain Chain, sd seed, params []hd.BIP44Params) ([]DerivedKey, error) {
+	bz, err := bip39.NewSeedWithErrorChecking(sd.Mnemonic, sd.BIP39Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	master, ch := hd.ComputeMastersFromSeed(bz)
+
+	pubs := make([]crypto.PubKey, 0, len(params))
+	for _, p := range params {
+		priv, err := hd.DerivePrivateKeyForPath(master, ch, p.String())
+		if err != nil {
+			return nil, err
+		}
+		pubs = append(pubs, secp256k1.PrivKeySecp256k1(priv).PubKey())
+	}
+
+	derived := make([]DerivedKey, 0, len(params))
+	err = chain.encode(func() error {
+		for i, pub := range pubs {
+			bechPub, err := sdk.Bech32ifyAccPub(pub)
+			if err != nil {
+				return err
+			}
+			derived = append(derived, DerivedKey{
+				HDPath:  params[i].String(),
+				Address: sdk.AccAddress(pub.Address()).String(),
+				PubKey:  bechPub,
+			})
+		}
+		return nil
+	})
+	return derived, err
+}
+
+// storeDerivedKeys derives and stores a key at each path, if any key fails
+// the keys already stored are removed. It returns the status to respond with on error.
+func (s *Server) storeDerivedKeys(kb ckeys.Keybase, chain Chain, sd seed, m DeriveKeysBody, params []hd.BIP44Params) ([]DerivedKey, int, error) {
+	if m.NameTemplate == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("must include name_template with request unless compute_only is set")
+	}
+
+	labels, err := normalizeLabels(m.Labels)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	names := make([]string, len(params))
+	seen := make(map[string]bool, len(params))
+	for i, p := range params {
+		names[i] = keyName(m.NameTemplate, p)
+		if seen[names[i]] {
+			return nil, http.StatusBadRequest, fmt.Errorf("name_template %s gives more than one key the name %s, use {account} and {index}", m.NameTemplate, names[i])
+		}
+		seen[names[i]] = true
+
+		exists, err := s.keyExists(kb, names[i])
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		} else if exists {
+			return nil, http.StatusConflict, errKeyExists(names[i])
+		}
+	}
+
+	derived := make([]DerivedKey, 0, len(params))
+	rollback := func() {
+		for _, d := range derived {
+			kb.Delete(d.Name, "", true)
+			s.deleteMeta(d.Name)
+		}
+	}
+
+	infos := make([]ckeys.Info, 0, len(params))
+	for i, p := range params {
+		info, err := kb.Derive(names[i], sd.Mnemonic, sd.BIP39Passphrase, m.Password, p)
+		if err != nil {
+			rollback()
+			return nil, http.StatusInternalServerError, err
+		}
+		derived = append(derived, DerivedKey{Name: names[i], HDPath: p.String()})
+		infos = append(infos, info)
+
+		if err = s.setMeta(names[i], keyMeta{HDPath: p.String(), Labels: labels}); err != nil {
+			rollback()
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	err = chain.encode(func() error {
+		for i, info := range infos {
+			ko, err := ckeys.Bech32KeyOutput(info)
+			if err != nil {
+				return err
+			}
+			derived[i].Address, derived[i].PubKey = ko.Address, ko.PubKey
+		}
+		return nil
+	})
+	if err != nil {
+		rollback()
+		return nil, http.StatusInternalServerError, err
+	}
+	return derived, 0, nil
+}
+
+// keyName fills the {account} and {index} placeholders of a name template
+func keyName(template string, p hd.BIP44Params) string {
+	return strings.NewReplacer(
+		"{account}", strconv.FormatUint(uint64(p.Account), 10),
+		"{index}", strconv.FormatUint(uint64(p.AddressIndex), 10),
+	).Replace(template)
+}
+
+// parseRange parses an inclusive range such as 0-99, or a single number
+func parseRange(field, rng string, max int) ([]uint32, error) {
+	if rng == "" {
+		return []uint32{0}, nil
+	}
+
+	parts := strings.SplitN(rng, "-", 2)
+	start, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil || start > uint64(max) {
+		return nil, fmt.Errorf("invalid %s %s", field, rng)
+	}
+	end := start
+	if len(parts) == 2 {
+		end, err = strconv.ParseUint(parts[1], 10, 32)
+		if err != nil || end > uint64(max) || end < start {
+			return nil, fmt.Errorf("invalid %s %s", field, rng)
+		}
+	}
+	if end-start >= maxDeriveKeys {
+		return nil, fmt.Errorf("%s %s covers more than %d values", field, rng, maxDeriveKeys)
+	}
+
+	out := make([]uint32, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		out = append(out, uint32(i))
+	}
+	return out, nil
+}
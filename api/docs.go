@@ -0,0 +1,114 @@
+package api
+
+import "net/http"
+
+// Docs is the handler for GET /docs, a page rendering /openapi.json. It is
+// self contained so the docs work without loading scripts from a CDN.
+func (s *Server) Docs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(docsPage))
+}
+
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>keyserver API</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 960px; color: #222; }
+h2 { border-bottom: 1px solid #ccc; padding-bottom: .2em; margin-top: 2em; }
+details { border: 1px solid #ddd; border-radius: 4px; margin: .5em 0; }
+summary { cursor: pointer; padding: .5em; }
+details > div { padding: 0 1em 1em; }
+.method { display: inline-block; width: 5em; font-weight: bold; font-family: monospace; }
+.get { color: #0a6; } .post { color: #06c; } .put { color: #a60; } .patch { color: #a0a; } .delete { color: #c00; }
+code, pre { font-family: monospace; background: #f5f5f5; }
+pre { padding: .5em; overflow-x: auto; }
+table { border-collapse: collapse; } td, th { text-align: left; padding: .2em .8em .2em 0; vertical-align: top; }
+</style>
+</head>
+<body>
+<h1 id="title">keyserver API</h1>
+<p>The raw specification is served at <a href="openapi.json">/openapi.json</a>.</p>
+<div id="ops"></div>
+<h2>Schemas</h2>
+<div id="schemas"></div>
+<script>
+function el(tag, attrs, children) {
+  var e = document.createElement(tag);
+  for (var k in attrs || {}) e.setAttribute(k, attrs[k]);
+  (children || []).forEach(function (c) {
+    e.appendChild(typeof c === "string" ? document.createTextNode(c) : c);
+  });
+  return e;
+}
+function json(v) { return el("pre", {}, [JSON.stringify(v, null, 2)]); }
+function refName(s) { return s && s.$ref ? s.$ref.split("/").pop() : null; }
+function schemaLink(s) {
+  var name = refName(s) || refName(s && s.items);
+  if (name) return el("a", {href: "#schema-" + name}, [s.items ? name + "[]" : name]);
+  return json(s);
+}
+fetch("openapi.json").then(function (r) { return r.json(); }).then(function (spec) {
+  document.getElementById("title").textContent = spec.info.title + " " + (spec.info.version || "");
+  var ops = document.getElementById("ops");
+  (spec.tags || []).forEach(function (tag) {
+    ops.appendChild(el("h2", {}, [tag.name]));
+    ops.appendChild(el("p", {}, [tag.description || ""]));
+    Object.keys(spec.paths).sort().forEach(function (path) {
+      Object.keys(spec.paths[path]).forEach(function (method) {
+        var op = spec.paths[path][method];
+        if (op.tags.indexOf(tag.name) < 0) return;
+        var body = el("div");
+        body.appendChild(el("p", {}, [op.summary]));
+        if (op.parameters) {
+          var table = el("table", {}, [el("tr", {}, [el("th", {}, ["parameter"]), el("th", {}, ["in"]), el("th", {}, ["description"])])]);
+          op.parameters.forEach(function (p) {
+            table.appendChild(el("tr", {}, [el("td", {}, [el("code", {}, [p.name])]), el("td", {}, [p.in]), el("td", {}, [p.description || ""])]));
+          });
+          body.appendChild(table);
+        }
+        if (op.requestBody) {
+          body.appendChild(el("h4", {}, ["Request body"]));
+          body.appendChild(schemaLink(op.requestBody.content["application/json"].schema));
+        }
+        Object.keys(op.responses).forEach(function (status) {
+          var resp = op.responses[status];
+          body.appendChild(el("h4", {}, [status + " " + resp.description]));
+          var content = resp.content || {};
+          Object.keys(content).forEach(function (type) {
+            body.appendChild(el("p", {}, [type]));
+            body.appendChild(schemaLink(content[type].schema));
+          });
+        });
+        ops.appendChild(el("details", {}, [
+          el("summary", {}, [el("span", {"class": "method " + method}, [method.toUpperCase()]), el("code", {}, [path])]),
+          body
+        ]));
+      });
+    });
+  });
+  var schemas = document.getElementById("schemas");
+  Object.keys(spec.components.schemas).sort().forEach(function (name) {
+    schemas.appendChild(el("details", {id: "schema-" + name}, [
+      el("summary", {}, [el("code", {}, [name])]),
+      el("div", {}, [json(spec.components.schemas[name])])
+    ]));
+  });
+  if (location.hash) {
+    var target = document.getElementById(location.hash.slice(1));
+    if (target) target.open = true;
+  }
+  window.addEventListener("hashchange", function () {
+    var target = document.getElementById(location.hash.slice(1));
+    if (target) target.open = true;
+  });
+}).catch(function (err) {
+  document.getElementById("ops").appendChild(el("p", {}, ["Failed to load the specification: " + err]));
+});
+</script>
+</body>
+</html>
+`
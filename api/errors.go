@@ -1,13 +1,132 @@
 package api
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/cosmos/cosmos-sdk/crypto/keys/keyerror"
+)
+
+// Error codes are returned in the code field of error responses. Unlike the
+// error messages they are stable, clients should match on them.
+const (
+	CodeInvalidRequest      = "invalid_request"
+	CodeUnknownChain        = "unknown_chain"
+	CodeKeyNotFound         = "key_not_found"
+	CodeKeyExists           = "key_exists"
+	CodeWrongPassword       = "wrong_password"
+	CodeInvalidMnemonic     = "invalid_mnemonic"
+	CodeSimulationFailed    = "simulation_failed"
+	CodeChainRejected       = "chain_rejected"
+	CodeNodeUnavailable     = "node_unavailable"
+	CodeSignerUnavailable   = "signer_unavailable"
+	CodeKeystoreUnavailable = "keystore_unavailable"
+	CodeInternal            = "internal"
+)
+
+// codeStatus is the http status of each error code
+var codeStatus = map[string]int{
+	CodeInvalidRequest:      http.StatusBadRequest,
+	CodeUnknownChain:        http.StatusBadRequest,
+	CodeKeyNotFound:         http.StatusNotFound,
+	CodeKeyExists:           http.StatusConflict,
+	CodeWrongPassword:       http.StatusUnauthorized,
+	CodeInvalidMnemonic:     http.StatusBadRequest,
+	CodeSimulationFailed:    http.StatusUnprocessableEntity,
+	CodeChainRejected:       http.StatusUnprocessableEntity,
+	CodeNodeUnavailable:     http.StatusBadGateway,
+	CodeSignerUnavailable:   http.StatusBadGateway,
+	CodeKeystoreUnavailable: http.StatusServiceUnavailable,
+	CodeInternal:            http.StatusInternalServerError,
+}
+
+// codedError is an error with an error code, and for errors returned by the
+// chain the codespace and code of the ABCI response
+type codedError struct {
+	code      string
+	err       error
+	details   map[string]interface{}
+	codespace string
+	abciCode  uint32
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+// withCode returns err with the error code, details are key value pairs
+func withCode(code string, err error, details ...interface{}) error {
+	ce := &codedError{code: code, err: err}
+	for i := 0; i+1 < len(details); i += 2 {
+		if ce.details == nil {
+			ce.details = make(map[string]interface{})
+		}
+		ce.details[fmt.Sprint(details[i])] = details[i+1]
+	}
+	return ce
+}
+
+// abciError returns an error with the code for a transaction the chain
+// failed, carrying the codespace and code of its ABCI response
+func abciError(code, codespace string, abciCode uint32, log string) error {
+	return &codedError{code: code, err: fmt.Errorf("%s", log), codespace: codespace, abciCode: abciCode}
+}
+
+// errKeyExists is returned when a key can't be stored under a name in use
+func errKeyExists(name string) error {
+	return withCode(CodeKeyExists, fmt.Errorf("key %s already exists", name), "name", name)
+}
 
 type restError struct {
-	Error string `json:"error"`
+	Error     string                 `json:"error"`
+	Code      string                 `json:"code"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	Codespace string                 `json:"codespace,omitempty"`
+	ABCICode  uint32                 `json:"abci_code,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
 }
 
-func newError(err error) restError {
-	return restError{err.Error()}
+// writeError writes the error response for a failed request, the error is
+// also recorded for the request's log line. Errors with a code and keybase
+// errors are written with the status of their code, other errors with status
+// and the generic code for it.
+func writeError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if rs, ok := r.Context().Value(requestStateKey).(*requestState); ok {
+		rs.err = err
+	}
+
+	re := restError{Error: err.Error(), RequestID: requestID(r)}
+	switch e := err.(type) {
+	case *codedError:
+		re.Code, re.Details, re.Codespace, re.ABCICode = e.code, e.details, e.codespace, e.abciCode
+		status = codeStatus[re.Code]
+	default:
+		switch {
+		case keyerror.IsErrKeyNotFound(err):
+			re.Code, status = CodeKeyNotFound, codeStatus[CodeKeyNotFound]
+		case keyerror.IsErrWrongPassword(err):
+			re.Code, status = CodeWrongPassword, codeStatus[CodeWrongPassword]
+		default:
+			re.Code = statusCode(status)
+		}
+	}
+
+	w.WriteHeader(status)
+	w.Write(re.marshal())
+}
+
+// statusCode returns the error code for an error written with status
+func statusCode(status int) string {
+	switch {
+	case status == http.StatusNotFound:
+		return CodeKeyNotFound
+	case status == http.StatusUnauthorized:
+		return CodeWrongPassword
+	case status >= http.StatusInternalServerError:
+		return CodeInternal
+	}
+	return CodeInvalidRequest
 }
 
 func (e restError) marshal() []byte {
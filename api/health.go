@@ -0,0 +1,177 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	amino "github.com/tendermint/go-amino"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	rpctypes "github.com/tendermint/tendermint/rpc/lib/types"
+)
+
+// readyTimeout bounds how long /readyz waits on a node before reporting it unavailable
+var readyTimeout = 5 * time.Second
+
+var rpccdc = amino.NewCodec()
+
+func init() {
+	ctypes.RegisterAmino(rpccdc)
+}
+
+// Healthz handles the /healthz route, it only reports that the process is serving requests
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// Readyz handles the /readyz route, it checks that the keybase opens and that
+// the default node and the node of every chain profile answer /status
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	rd := Readiness{Keybase: s.checkKeybase()}
+	rd.Ready = rd.Keybase.OK
+
+	if s.Node != "" || len(s.Chains) == 0 {
+		node := checkNode(s.Node)
+		rd.Node = &node
+		rd.Ready = rd.Ready && node.ready()
+	}
+
+	// the nodes of the chain profiles are checked at once
+	if len(s.Chains) > 0 {
+		nodes := make([]NodeStatus, len(s.Chains))
+		var wg sync.WaitGroup
+		for i, c := range s.Chains {
+			wg.Add(1)
+			go func(i int, node string) {
+				defer wg.Done()
+				nodes[i] = checkNode(node)
+			}(i, c.Node)
+		}
+		wg.Wait()
+
+		rd.Chains = make(map[string]NodeStatus, len(s.Chains))
+		for i, c := range s.Chains {
+			rd.Chains[c.Name] = nodes[i]
+			rd.Ready = rd.Ready && nodes[i].ready()
+		}
+	}
+
+	if !rd.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(rd.marshal())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rd.marshal())
+	return
+}
+
+// Readiness is the body of /readyz
+type Readiness struct {
+	Ready   bool                  `json:"ready"`
+	Keybase KeybaseStatus         `json:"keybase"`
+	Node    *NodeStatus           `json:"node,omitempty"`
+	Chains  map[string]NodeStatus `json:"chains,omitempty"`
+}
+
+// KeybaseStatus reports whether the keybase opens
+type KeybaseStatus struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// NodeStatus reports whether a node answers /status and is synced
+type NodeStatus struct {
+	OK                bool   `json:"ok"`
+	ChainID           string `json:"chain_id,omitempty"`
+	LatestBlockHeight int64  `json:"latest_block_height,string"`
+	CatchingUp        bool   `json:"catching_up"`
+	Error             string `json:"error,omitempty"`
+}
+
+func (ns NodeStatus) ready() bool {
+	return ns.OK && !ns.CatchingUp
+}
+
+func (rd Readiness) marshal() []byte {
+	out, err := json.Marshal(rd)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func (s *Server) checkKeybase() (ks KeybaseStatus) {
+	// the leveldb keybase panics if the key directory can't be created
+	defer func() {
+		if r := recover(); r != nil {
+			ks = KeybaseStatus{Error: fmt.Sprint(r)}
+		}
+	}()
+
+	kb, err := s.keybase()
+	if err != nil {
+		return KeybaseStatus{Error: err.Error()}
+	}
+
+	if _, err = kb.List(); err != nil {
+		return KeybaseStatus{Error: err.Error()}
+	}
+
+	return KeybaseStatus{OK: true}
+}
+
+func checkNode(node string) NodeStatus {
+	status, err := nodeStatus(node)
+	if err != nil {
+		return NodeStatus{Error: err.Error()}
+	}
+	return NodeStatus{
+		OK:                true,
+		ChainID:           status.NodeInfo.Network,
+		LatestBlockHeight: status.SyncInfo.LatestBlockHeight,
+		CatchingUp:        status.SyncInfo.CatchingUp,
+	}
+}
+
+// nodeStatus calls /status on node with a client that gives up after
+// readyTimeout, the tendermint rpc client has no timeout
+func nodeStatus(node string) (*ctypes.ResultStatus, error) {
+	u, err := url.Parse(node)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "tcp" {
+		u.Scheme = "http"
+	}
+	req, err := json.Marshal(rpctypes.NewRPCRequest(rpctypes.JSONRPCStringID("keyserver"), "status", nil))
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: readyTimeout}
+	resp, err := client.Post(u.String(), "application/json", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res rpctypes.RPCResponse
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("node %s answered /status with %s: %s", node, resp.Status, err)
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	status := &ctypes.ResultStatus{}
+	return status, rpccdc.UnmarshalJSON(res.Result, status)
+}
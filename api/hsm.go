@@ -0,0 +1,14 @@
+package api
+
+// SignerHSM is the backend name of keys held by a PKCS#11 hardware security module
+const SignerHSM = "hsm"
+
+// HSMConfig configures the PKCS#11 signer. Module is the path of the
+// PKCS#11 library, e.g. /usr/lib/softhsm/libsofthsm2.so, Token the label of
+// the token holding the keys and PIN the token's user PIN. Keys are looked up
+// by the label their key pair was generated with.
+type HSMConfig struct {
+	Module string `json:"module,omitempty"`
+	Token  string `json:"token,omitempty"`
+	PIN    string `json:"pin,omitempty"`
+}
@@ -0,0 +1,14 @@
+//go:build !pkcs11
+// +build !pkcs11
+
+package api
+
+import "errors"
+
+// NewHSMSigner returns an error, PKCS#11 support requires cgo and the pkcs11 build tag
+func NewHSMSigner(hc HSMConfig) (Signer, error) {
+	return nil, errors.New("the keyserver was built without PKCS#11 support, rebuild it with -tags pkcs11")
+}
+
+// CloseHSM does nothing, no PKCS#11 modules are loaded without PKCS#11 support
+func CloseHSM() {}
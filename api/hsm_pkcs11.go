@@ -0,0 +1,270 @@
+//go:build pkcs11
+// +build pkcs11
+
+package api
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/miekg/pkcs11"
+	"github.com/tendermint/tendermint/crypto"
+	"github.com/tendermint/tendermint/crypto/secp256k1"
+)
+
+// secp256k1Params is the DER encoded secp256k1 curve OID, 1.3.132.0.10
+var secp256k1Params = []byte{0x06, 0x05, 0x2b, 0x81, 0x04, 0x00, 0x0a}
+
+// hsmModules holds the PKCS#11 modules loaded by the process, each module
+// can only be initialized once
+var (
+	hsmModules   = make(map[string]*hsmModule)
+	hsmModulesMu sync.Mutex
+)
+
+// hsmModule is a loaded PKCS#11 module and a logged in session for each
+// token signed with
+type hsmModule struct {
+	ctx      *pkcs11.Ctx
+	sessions map[string]*hsmSession
+}
+
+// hsmSession is kept open and logged in between requests, a session can't
+// be used concurrently so mu serializes the requests signing with the token
+type hsmSession struct {
+	mu     sync.Mutex
+	open   bool
+	handle pkcs11.SessionHandle
+}
+
+// hsmSigner signs with secp256k1 keys held by a PKCS#11 token
+type hsmSigner struct {
+	module *hsmModule
+	token  string
+	pin    string
+}
+
+// NewHSMSigner returns a signer for the PKCS#11 token described by hc
+func NewHSMSigner(hc HSMConfig) (Signer, error) {
+	if hc.Module == "" || hc.Token == "" {
+		return nil, errors.New("hsm module and token aren't configured")
+	}
+
+	hsmModulesMu.Lock()
+	defer hsmModulesMu.Unlock()
+
+	module, ok := hsmModules[hc.Module]
+	if !ok {
+		ctx := pkcs11.New(hc.Module)
+		if ctx == nil {
+			return nil, fmt.Errorf("can't load PKCS#11 module %s", hc.Module)
+		}
+		if err := ctx.Initialize(); err != nil {
+			ctx.Destroy()
+			return nil, fmt.Errorf("initializing PKCS#11 module %s: %s", hc.Module, err)
+		}
+		module = &hsmModule{ctx: ctx, sessions: make(map[string]*hsmSession)}
+		hsmModules[hc.Module] = module
+	}
+	if module.sessions[hc.Token] == nil {
+		module.sessions[hc.Token] = &hsmSession{}
+	}
+	return &hsmSigner{module: module, token: hc.Token, pin: hc.PIN}, nil
+}
+
+// CloseHSM logs out of the tokens and finalizes the PKCS#11 modules loaded
+// by the process, signing with them afterwards loads them again
+func CloseHSM() {
+	hsmModulesMu.Lock()
+	defer hsmModulesMu.Unlock()
+
+	for path, module := range hsmModules {
+		for _, sess := range module.sessions {
+			sess.mu.Lock()
+			if sess.open {
+				module.ctx.Logout(sess.handle)
+				module.ctx.CloseSession(sess.handle)
+				sess.open = false
+			}
+			sess.mu.Unlock()
+		}
+		module.ctx.Finalize()
+		module.ctx.Destroy()
+		delete(hsmModules, path)
+	}
+}
+
+// PubKey reads the public key with the label keyName
+func (hs *hsmSigner) PubKey(keyName string) (pub crypto.PubKey, err error) {
+	err = hs.session(func(sh pkcs11.SessionHandle) error {
+		pub, err = hs.pubKey(sh, keyName)
+		return err
+	})
+	return
+}
+
+// Sign has the token sign the sha256 hash of msg like the keybase does and
+// returns the signature in the low S, R || S form tendermint expects
+func (hs *hsmSigner) Sign(keyName string, msg []byte) (sig []byte, err error) {
+	err = hs.session(func(sh pkcs11.SessionHandle) error {
+		priv, err := hs.findKey(sh, pkcs11.CKO_PRIVATE_KEY, keyName)
+		if err != nil {
+			return err
+		}
+
+		if err = hs.module.ctx.SignInit(sh, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, priv); err != nil {
+			return err
+		}
+		raw, err := hs.module.ctx.Sign(sh, crypto.Sha256(msg))
+		if err != nil {
+			return err
+		}
+		if len(raw) != 64 {
+			return fmt.Errorf("invalid signature from hsm: expected 64 bytes, got %d", len(raw))
+		}
+		sig, err = compactRS(new(big.Int).SetBytes(raw[:32]), new(big.Int).SetBytes(raw[32:]))
+		return err
+	})
+	return
+}
+
+// session runs fn in the token's logged in session, opening it on first
+// use or after the token dropped it
+func (hs *hsmSigner) session(fn func(sh pkcs11.SessionHandle) error) error {
+	hsmModulesMu.Lock()
+	sess := hs.module.sessions[hs.token]
+	hsmModulesMu.Unlock()
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if !sess.open {
+		sh, err := hs.login()
+		if err != nil {
+			return err
+		}
+		sess.handle, sess.open = sh, true
+	}
+
+	err := fn(sess.handle)
+	if perr, ok := err.(pkcs11.Error); ok && sessionLost(perr) {
+		hs.module.ctx.CloseSession(sess.handle)
+		sess.open = false
+	}
+	return err
+}
+
+// login opens a session on the configured token and logs the user in
+func (hs *hsmSigner) login() (pkcs11.SessionHandle, error) {
+	slot, err := hs.slot()
+	if err != nil {
+		return 0, err
+	}
+
+	sh, err := hs.module.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return 0, err
+	}
+
+	// the login is shared by the application's sessions on the token
+	err = hs.module.ctx.Login(sh, pkcs11.CKU_USER, hs.pin)
+	if perr, ok := err.(pkcs11.Error); err != nil && !(ok && perr == pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		hs.module.ctx.CloseSession(sh)
+		return 0, fmt.Errorf("logging in to token %s: %s", hs.token, err)
+	}
+	return sh, nil
+}
+
+// sessionLost reports whether err means the session has to be opened again
+func sessionLost(err pkcs11.Error) bool {
+	switch err {
+	case pkcs11.CKR_SESSION_HANDLE_INVALID, pkcs11.CKR_SESSION_CLOSED, pkcs11.CKR_USER_NOT_LOGGED_IN,
+		pkcs11.CKR_DEVICE_REMOVED, pkcs11.CKR_TOKEN_NOT_PRESENT:
+		return true
+	}
+	return false
+}
+
+// slot returns the slot holding the configured token
+func (hs *hsmSigner) slot() (uint, error) {
+	slots, err := hs.module.ctx.GetSlotList(true)
+	if err != nil {
+		return 0, err
+	}
+	for _, slot := range slots {
+		info, err := hs.module.ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, err
+		}
+		if info.Label == hs.token {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("token %s not found", hs.token)
+}
+
+// findKey returns the EC key of the given class labeled keyName
+func (hs *hsmSigner) findKey(sh pkcs11.SessionHandle, class uint, keyName string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, keyName),
+	}
+	if err := hs.module.ctx.FindObjectsInit(sh, template); err != nil {
+		return 0, err
+	}
+	objs, _, err := hs.module.ctx.FindObjects(sh, 2)
+	hs.module.ctx.FindObjectsFinal(sh)
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(objs) {
+	case 0:
+		return 0, fmt.Errorf("key %s not found on token %s", keyName, hs.token)
+	case 1:
+		return objs[0], nil
+	}
+	return 0, fmt.Errorf("more than one key labeled %s on token %s", keyName, hs.token)
+}
+
+// pubKey reads the EC point of the public key labeled keyName into a
+// compressed tendermint public key
+func (hs *hsmSigner) pubKey(sh pkcs11.SessionHandle, keyName string) (crypto.PubKey, error) {
+	obj, err := hs.findKey(sh, pkcs11.CKO_PUBLIC_KEY, keyName)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := hs.module.ctx.GetAttributeValue(sh, obj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(attrs[0].Value, secp256k1Params) {
+		return nil, fmt.Errorf("key %s isn't a secp256k1 key, only secp256k1 keys can sign transactions", keyName)
+	}
+
+	// the point should be wrapped in a DER octet string, some modules return it raw
+	point := attrs[1].Value
+	var unwrapped []byte
+	if rest, err := asn1.Unmarshal(point, &unwrapped); err == nil && len(rest) == 0 {
+		point = unwrapped
+	}
+
+	key, err := btcec.ParsePubKey(point, btcec.S256())
+	if err != nil {
+		return nil, fmt.Errorf("invalid secp256k1 public key: %s", err)
+	}
+
+	var pub secp256k1.PubKeySecp256k1
+	copy(pub[:], key.SerializeCompressed())
+	return pub, nil
+}
@@ -0,0 +1,130 @@
+//go:build pkcs11
+// +build pkcs11
+
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cosmos/cosmos-sdk/x/auth"
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHSMSigner signs with a key generated on a fresh SoftHSM token, it runs
+// when SOFTHSM2_MODULE is the path of libsofthsm2.so
+func TestHSMSigner(t *testing.T) {
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		t.Skip("SOFTHSM2_MODULE isn't set")
+	}
+
+	dir := tempDir(t)
+	conf := filepath.Join(dir, "softhsm2.conf")
+	require.NoError(t, ioutil.WriteFile(conf, []byte(fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\n", dir)), 0600))
+	require.NoError(t, os.Setenv("SOFTHSM2_CONF", conf))
+	initSoftHSMToken(t, module, "keyserver", "1234", "validator")
+
+	s := &Server{KeyDir: tempDir(t), Node: "tcp://127.0.0.1:1", HSM: HSMConfig{Module: module, Token: "keyserver", PIN: "1234"}}
+	server := httptest.NewServer(s.Router())
+	defer server.Close()
+	defer CloseHSM()
+
+	// test registering the token's key
+	rb := RemoteKeyBody{Name: "operator", Backend: SignerHSM, KeyName: "validator", Password: testPass}
+	key := unmarshalLabeledKey(postRoute(t, fmt.Sprintf("%s/keys/remote", server.URL), rb.Marshal(), 200))
+	require.Equal(t, SignerHSM, key.Type)
+	rb.Name, rb.KeyName = "missing", "missing"
+	postRoute(t, fmt.Sprintf("%s/keys/remote", server.URL), rb.Marshal(), 502)
+
+	// test signing requires the key's password
+	sb := SignBody{Tx: []byte(`{"type":"cosmos-sdk/StdTx","value":{"msg":[],"fee":{"amount":[],"gas":"0"},"signatures":null,"memo":"hsm"}}`), Name: "operator", ChainID: "testing", AccountNumber: "3", Sequence: "7"}
+	postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 401)
+
+	// test concurrent requests share the token's session
+	sb.Passphrase = testPass
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			var tx auth.StdTx
+			bz, err := signRoute(fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal())
+			if err == nil {
+				err = cdc.UnmarshalJSON(bz, &tx)
+			}
+			if err == nil && !tx.Signatures[0].PubKey.VerifyBytes(auth.StdSignBytes("testing", 3, 7, tx.Fee, tx.Msgs, tx.Memo), tx.Signatures[0].Signature) {
+				err = fmt.Errorf("invalid signature")
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		require.NoError(t, <-errs)
+	}
+
+	// test the module is loaded again after it was finalized
+	CloseHSM()
+	postRoute(t, fmt.Sprintf("%s/tx/sign", server.URL), sb.Marshal(), 200)
+}
+
+// signRoute posts data to route, returning an error unless it responds with 200
+func signRoute(route string, data []byte) ([]byte, error) {
+	resp, err := http.Post(route, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bz, err := ioutil.ReadAll(resp.Body)
+	if err == nil && resp.StatusCode != 200 {
+		err = fmt.Errorf("status %d: %s", resp.StatusCode, bz)
+	}
+	return bz, err
+}
+
+// initSoftHSMToken initializes a token labeled token in the first free slot
+// and generates a secp256k1 key pair labeled keyName on it
+func initSoftHSMToken(t *testing.T, module, token, pin, keyName string) {
+	ctx := pkcs11.New(module)
+	require.NotNil(t, ctx)
+	require.NoError(t, ctx.Initialize())
+	defer ctx.Destroy()
+	defer ctx.Finalize()
+
+	slots, err := ctx.GetSlotList(false)
+	require.NoError(t, err)
+	require.NoError(t, ctx.InitToken(slots[0], pin, token))
+
+	// softhsm moves an initialized token to a new slot
+	hs := &hsmSigner{module: &hsmModule{ctx: ctx}, token: token}
+	slot, err := hs.slot()
+	require.NoError(t, err)
+	sh, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	require.NoError(t, err)
+	defer ctx.CloseSession(sh)
+
+	require.NoError(t, ctx.Login(sh, pkcs11.CKU_SO, pin))
+	require.NoError(t, ctx.InitPIN(sh, pin))
+	require.NoError(t, ctx.Logout(sh))
+	require.NoError(t, ctx.Login(sh, pkcs11.CKU_USER, pin))
+	defer ctx.Logout(sh)
+
+	_, _, err = ctx.GenerateKeyPair(sh, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, secp256k1Params),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, keyName),
+		},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, keyName),
+		})
+	require.NoError(t, err)
+}
@@ -2,52 +2,89 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
-	"github.com/cosmos/cosmos-sdk/client/keys"
 	ckeys "github.com/cosmos/cosmos-sdk/crypto/keys"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/hd"
 	"github.com/cosmos/cosmos-sdk/crypto/keys/keyerror"
 	bip39 "github.com/cosmos/go-bip39"
 	"github.com/gorilla/mux"
 )
 
-// GetKeys is the handler for the GET /keys
+// GetKeys is the handler for the GET /keys, see parseKeyFilter for the
+// query parameters. When a limit cuts the list short the name to pass as the
+// next cursor is returned in the X-Next-Cursor header.
 func (s *Server) GetKeys(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	kb, err := keys.NewKeyBaseFromDir(s.KeyDir)
+	kb, err := s.keybase()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	infos, err := kb.List()
+	filter, err := parseKeyFilter(r.URL.Query())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	if len(infos) == 0 {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("[]"))
+	chain, err := s.chain(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	keysOutput, err := ckeys.Bech32KeysOutput(infos)
+	infos, err := kb.List()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
+	metas, err := s.listMeta()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	keysOutput := make([]KeyOutput, 0, len(infos))
+	err = chain.encode(func() error {
+		for _, info := range infos {
+			ko, err := ckeys.Bech32KeyOutput(info)
+			if err != nil {
+				return err
+			}
+			keysOutput = append(keysOutput, newKeyOutput(ko, metas[info.GetName()]))
+		}
+
+		// watch-only addresses only exist in the metadata
+		for name, meta := range metas {
+			if meta.Address != nil {
+				keysOutput = append(keysOutput, newKeyOutput(addressKeyOutput(name, meta.Address, "acc"), meta))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	sort.Slice(keysOutput, func(i, j int) bool { return keysOutput[i].Name < keysOutput[j].Name })
+
+	keysOutput, next := filter.apply(keysOutput)
+	if next != "" {
+		w.Header().Set(NextCursorHeader, next)
+	}
+
 	out, err := json.Marshal(keysOutput)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -56,13 +93,67 @@ func (s *Server) GetKeys(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-// AddNewKey is the necessary data for adding a new key
+// KeyOutput is a key as returned by the keys routes
+type KeyOutput struct {
+	ckeys.KeyOutput
+	HDPath   string            `json:"hd_path,omitempty"`
+	Labels   []string          `json:"labels,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	MnemonicRetained bool `json:"mnemonic_retained,omitempty"`
+
+	// BackupWords are the positions of the mnemonic words that confirm an unconfirmed backup
+	BackupUnconfirmed bool  `json:"backup_unconfirmed,omitempty"`
+	BackupWords       []int `json:"backup_words,omitempty"`
+}
+
+func newKeyOutput(keyOutput ckeys.KeyOutput, meta keyMeta) KeyOutput {
+	ko := KeyOutput{
+		KeyOutput:        keyOutput,
+		HDPath:           meta.HDPath,
+		Labels:           meta.Labels,
+		Metadata:         meta.Metadata,
+		MnemonicRetained: meta.Seed != nil,
+	}
+	if meta.Signer != nil {
+		ko.Type = meta.Signer.Backend
+	}
+	if meta.Backup != nil {
+		ko.BackupUnconfirmed = true
+		ko.BackupWords = meta.Backup.Words
+	}
+	return ko
+}
+
+// AddNewKey is the necessary data for adding a new key, the key is derived
+// at HDPath if given, otherwise at m/44'/CoinType'/Account'/0/Index where
+// CoinType defaults to the chain's coin type. A mnemonic of MnemonicWords
+// words is generated if none is given.
 type AddNewKey struct {
 	Name     string `json:"name"`
 	Password string `json:"password"`
 	Mnemonic string `json:"mnemonic,omitempty"`
 	Account  int    `json:"account,string,omitempty"`
 	Index    int    `json:"index,string,omitempty"`
+	CoinType int    `json:"coin_type,string,omitempty"`
+	HDPath   string `json:"hd_path,omitempty"`
+
+	// BIP39Passphrase is the optional "25th word" mixed into the seed
+	BIP39Passphrase string `json:"bip39_passphrase,omitempty"`
+
+	// MnemonicWords is the length of the generated mnemonic when none is given
+	MnemonicWords int `json:"mnemonic_words,string,omitempty"`
+
+	Labels   []string          `json:"labels,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// RetainMnemonic keeps the mnemonic encrypted with Password so more
+	// keys can be derived from this one with POST /keys/derive
+	RetainMnemonic bool `json:"retain_mnemonic,omitempty"`
+
+	// VerifyBackup keeps the mnemonic encrypted with Password until the
+	// operator confirms the backup with POST /keys/{name}/backup/confirm
+	VerifyBackup bool `json:"verify_backup,omitempty"`
 }
 
 func (ak AddNewKey) Marshal() []byte {
@@ -77,86 +168,137 @@ func (ak AddNewKey) Marshal() []byte {
 func (s *Server) PostKeys(w http.ResponseWriter, r *http.Request) {
 	var m AddNewKey
 
-	kb, err := keys.NewKeyBaseFromDir(s.KeyDir)
+	kb, err := s.keybase()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	chain, err := s.chain(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
 	err = json.Unmarshal(body, &m)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
 	if m.Name == "" || m.Password == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(fmt.Errorf("must include both password and name with request")).marshal())
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("must include both password and name with request"))
 		return
 	}
 
 	// if mnemonic is empty, generate one
 	mnemonic := m.Mnemonic
 	if mnemonic == "" {
-		_, mnemonic, _ = ckeys.NewInMemory().CreateMnemonic("inmemorykey", ckeys.English, "123456789", ckeys.Secp256k1)
+		words := m.MnemonicWords
+		if words == 0 {
+			words = s.mnemonicWords()
+		}
+		mnemonic, err = newMnemonic(words)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err)
+			return
+		}
 	}
 
 	if !bip39.IsMnemonicValid(mnemonic) {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(fmt.Errorf("invalid mnemonic")).marshal())
+		writeError(w, r, http.StatusBadRequest, withCode(CodeInvalidMnemonic, fmt.Errorf("invalid mnemonic")))
 		return
 	}
 
 	if m.Account < 0 || m.Account > maxValidAccountValue {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(fmt.Errorf("invalid account number")).marshal())
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid account number"))
 		return
 	}
 
 	if m.Index < 0 || m.Index > maxValidIndexalue {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(fmt.Errorf("invalid index number")).marshal())
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid index number"))
+		return
+	}
+
+	if m.CoinType < 0 || m.CoinType > maxValidCoinTypeValue {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid coin type"))
+		return
+	}
+
+	params, err := m.hdParams(chain)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	labels, err := normalizeLabels(m.Labels)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	_, err = kb.Get(m.Name)
-	if err == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(fmt.Errorf("key %s already exists", m.Name)).marshal())
+	if err = validateMetadata(m.Metadata); err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	account := uint32(m.Account)
-	index := uint32(m.Index)
-	info, err := kb.CreateAccount(m.Name, mnemonic, ckeys.DefaultBIP39Passphrase, m.Password, account, index)
+	exists, err := s.keyExists(kb, m.Name)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	} else if exists {
+		writeError(w, r, http.StatusConflict, errKeyExists(m.Name))
 		return
 	}
 
-	keyOutput, err := ckeys.Bech32KeyOutput(info)
+	info, err := kb.Derive(m.Name, mnemonic, m.BIP39Passphrase, m.Password, *params)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	meta := keyMeta{HDPath: params.String(), Labels: labels, Metadata: m.Metadata}
+	sd := seed{Mnemonic: mnemonic, BIP39Passphrase: m.BIP39Passphrase}
+	if m.RetainMnemonic {
+		meta.Seed, err = sealSeed(sd, m.Password)
+		if err != nil {
+			kb.Delete(m.Name, "", true)
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if m.VerifyBackup {
+		meta.Backup, err = newBackupCheck(sd, m.Password)
+		if err != nil {
+			kb.Delete(m.Name, "", true)
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err = s.setMeta(m.Name, meta); err != nil {
+		kb.Delete(m.Name, "", true)
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	keyOutput, err := chain.keyOutput(info)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	keyOutput.Mnemonic = mnemonic
 
-	out, err := json.Marshal(keyOutput)
+	out, err := json.Marshal(newKeyOutput(keyOutput, meta))
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -169,10 +311,15 @@ func (s *Server) PostKeys(w http.ResponseWriter, r *http.Request) {
 func (s *Server) GetKey(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	kb, err := keys.NewKeyBaseFromDir(s.KeyDir)
+	kb, err := s.keybase()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	chain, err := s.chain(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
@@ -186,33 +333,40 @@ func (s *Server) GetKey(w http.ResponseWriter, r *http.Request) {
 
 	bechKeyOut, err := getBechKeyOut(bechPrefix)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	info, err := kb.Get(name)
-	if keyerror.IsErrKeyNotFound(err) {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write(newError(err).marshal())
-		return
-	} else if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+	meta, err := s.getMeta(name)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	keyOutput, err := bechKeyOut(info)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+	var keyOutput ckeys.KeyOutput
+	info, err := kb.Get(name)
+	if keyerror.IsErrKeyNotFound(err) && meta.Address != nil {
+		keyOutput = chain.addressOutput(name, meta.Address, bechPrefix)
+	} else if keyerror.IsErrKeyNotFound(err) {
+		writeError(w, r, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
+	} else {
+		err = chain.encode(func() (err error) {
+			keyOutput, err = bechKeyOut(info)
+			return err
+		})
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
 	}
 
-	out, err := json.Marshal(keyOutput)
+	out, err := json.Marshal(newKeyOutput(keyOutput, meta))
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -221,6 +375,54 @@ func (s *Server) GetKey(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// newMnemonic generates a BIP39 mnemonic with the given number of words
+func newMnemonic(words int) (string, error) {
+	switch words {
+	case 12, 15, 18, 21, 24:
+	default:
+		return "", fmt.Errorf("invalid mnemonic length %d, must be 12, 15, 18, 21 or 24 words", words)
+	}
+
+	entropy, err := bip39.NewEntropy(words / 3 * 32)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// hdParams returns the BIP44 params the key should be derived at
+func (ak AddNewKey) hdParams(chain Chain) (*hd.BIP44Params, error) {
+	if ak.HDPath == "" {
+		coinType := chain.CoinType
+		if ak.CoinType != 0 {
+			coinType = uint32(ak.CoinType)
+		}
+		return hd.NewFundraiserParams(uint32(ak.Account), coinType, uint32(ak.Index)), nil
+	}
+
+	if ak.CoinType != 0 || ak.Account != 0 || ak.Index != 0 {
+		return nil, errors.New("hd_path can't be combined with coin_type, account or index")
+	}
+	return parseHDPath(ak.HDPath)
+}
+
+// parseHDPath parses a full BIP44 path such as m/44'/118'/0'/0/0, the
+// purpose, coin type and account must be hardened, change and index must not
+func parseHDPath(path string) (*hd.BIP44Params, error) {
+	trimmed := strings.TrimPrefix(path, "m/")
+	for _, segment := range strings.Split(trimmed, "/") {
+		if _, err := strconv.ParseUint(strings.TrimSuffix(segment, "'"), 10, 31); err != nil {
+			return nil, fmt.Errorf("invalid hd_path %s: segment %s must be a number less than 2^31", path, segment)
+		}
+	}
+
+	params, err := hd.NewParamsFromPath(trimmed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hd_path %s: %s", path, err)
+	}
+	return params, nil
+}
+
 type bechKeyOutFn func(keyInfo ckeys.Info) (ckeys.KeyOutput, error)
 
 func getBechKeyOut(bechPrefix string) (bechKeyOutFn, error) {
@@ -256,33 +458,62 @@ func (s *Server) PutKey(w http.ResponseWriter, r *http.Request) {
 	name := vars["name"]
 	var m UpdateKeyBody
 
-	kb, err := keys.NewKeyBaseFromDir(s.KeyDir)
+	kb, err := s.keybase()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	err = decoder.Decode(&m)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	meta, err := s.getMeta(name)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if meta.Signer != nil {
+		err = s.updateRemotePassword(name, meta.Signer, m.OldPassword, m.NewPassword)
+		if keyerror.IsErrWrongPassword(err) {
+			writeError(w, r, http.StatusUnauthorized, err)
+			return
+		} else if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// a retained mnemonic is encrypted with the key's password too, it is
+	// resealed first and put back if the keybase's password can't be changed
+	restore, err := s.resealSeed(name, m.OldPassword, m.NewPassword)
+	if keyerror.IsErrWrongPassword(err) {
+		writeError(w, r, http.StatusUnauthorized, err)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	err = kb.Update(name, m.OldPassword, func() (string, error) { return m.NewPassword, nil })
+	if err != nil {
+		if rerr := restore(); rerr != nil {
+			requestLogger(r).Error("restoring retained mnemonic", "name", name, "err", rerr)
+		}
+	}
 	if keyerror.IsErrKeyNotFound(err) {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusNotFound, err)
 		return
 	} else if keyerror.IsErrWrongPassword(err) {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusUnauthorized, err)
 		return
 	} else if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -309,33 +540,53 @@ func (s *Server) DeleteKey(w http.ResponseWriter, r *http.Request) {
 	name := vars["name"]
 	var m DeleteKeyBody
 
-	kb, err := keys.NewKeyBaseFromDir(s.KeyDir)
+	kb, err := s.keybase()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	err = decoder.Decode(&m)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
+	// the keybase doesn't check the password of the offline infos remote keys
+	// are registered as, keys registered without a password can be deleted
+	meta, err := s.getMeta(name)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if meta.Signer != nil && len(meta.Signer.PasswordHash) > 0 {
+		if err = meta.Signer.checkPassword(name, m.Password); err != nil {
+			writeError(w, r, http.StatusUnauthorized, err)
+			return
+		}
+	}
+
 	err = kb.Delete(name, m.Password, false)
 	if keyerror.IsErrKeyNotFound(err) {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write(newError(err).marshal())
+		if meta.Address != nil {
+			err = nil
+		}
+	}
+
+	if keyerror.IsErrKeyNotFound(err) {
+		writeError(w, r, http.StatusNotFound, err)
 		return
 	} else if keyerror.IsErrWrongPassword(err) {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusUnauthorized, err)
 		return
 	} else if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err = s.deleteMeta(name); err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -0,0 +1,390 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cosmos/cosmos-sdk/client/keys"
+	ckeys "github.com/cosmos/cosmos-sdk/crypto/keys"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/hd"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/tendermint/tendermint/crypto"
+	"github.com/tendermint/tendermint/crypto/xsalsa20symmetric"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Keystore types selectable in the keystore section of the config
+const (
+	KeystoreLevelDB = "leveldb"
+	KeystoreFile    = "file"
+	KeystoreMemory  = "memory"
+)
+
+// Keystore holds the keys and the metadata the keyserver keeps about them
+type Keystore interface {
+	// Keybase returns the keybase holding the keys, handlers call it once per request
+	Keybase() (ckeys.Keybase, error)
+
+	// Meta runs fn against the db holding key metadata, writes are persisted when fn returns
+	Meta(fn func(db dbm.DB) error) error
+}
+
+// KeystoreConfig selects the keystore. The leveldb keystore keeps keys in
+// KeyDir. The file keystore keeps keys and metadata in a single file at Path,
+// encrypted with Password, which defaults to keys.enc in KeyDir. The memory
+// keystore loses every key when the keyserver stops.
+type KeystoreConfig struct {
+	Type     string `json:"type"`
+	Path     string `json:"path,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// OpenKeystore opens the keystore selected in the config
+func (s *Server) OpenKeystore() (err error) {
+	switch s.Keystore.Type {
+	case "", KeystoreLevelDB:
+		s.keystore = NewLevelDBKeystore(s.KeyDir)
+	case KeystoreMemory:
+		s.keystore = NewMemoryKeystore()
+	case KeystoreFile:
+		path := s.Keystore.Path
+		if path == "" {
+			path = filepath.Join(s.KeyDir, "keys.enc")
+		}
+		s.keystore, err = NewFileKeystore(path, s.Keystore.Password)
+	default:
+		err = fmt.Errorf("unknown keystore type %s, must be leveldb, file or memory", s.Keystore.Type)
+	}
+	return
+}
+
+// SetKeystore sets the keystore used by the server, it should be called
+// before serving as reloaded configs copy the keystore
+func (s *Server) SetKeystore(ks Keystore) {
+	s.keystore = ks
+}
+
+// store returns the server's keystore, the leveldb keystore at KeyDir if none has been opened
+func (s *Server) store() Keystore {
+	if s.keystore == nil {
+		return NewLevelDBKeystore(s.KeyDir)
+	}
+	return s.keystore
+}
+
+func (s *Server) keybase() (ckeys.Keybase, error) {
+	kb, err := s.store().Keybase()
+	if err != nil {
+		return nil, withCode(CodeKeystoreUnavailable, err)
+	}
+	return kb, nil
+}
+
+// levelDBKeystore keeps keys in the keys directory of dir, opening the
+// leveldb for each operation like the cosmos sdk's key commands
+type levelDBKeystore struct {
+	dir string
+}
+
+// NewLevelDBKeystore returns the keystore kept in the keys directory of dir
+func NewLevelDBKeystore(dir string) Keystore {
+	return levelDBKeystore{dir}
+}
+
+func (ks levelDBKeystore) Keybase() (ckeys.Keybase, error) {
+	return keys.NewKeyBaseFromDir(ks.dir)
+}
+
+func (ks levelDBKeystore) Meta(fn func(db dbm.DB) error) error {
+	db, err := sdk.NewLevelDB("meta", filepath.Join(ks.dir, "keys"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return fn(db)
+}
+
+// memoryKeystore keeps keys in memory only
+type memoryKeystore struct {
+	kb   ckeys.Keybase
+	meta *dbm.MemDB
+}
+
+// NewMemoryKeystore returns an empty keystore that is never persisted
+func NewMemoryKeystore() Keystore {
+	return &memoryKeystore{kb: ckeys.NewInMemory(), meta: dbm.NewMemDB()}
+}
+
+func (ks *memoryKeystore) Keybase() (ckeys.Keybase, error) {
+	return ks.kb, nil
+}
+
+func (ks *memoryKeystore) Meta(fn func(db dbm.DB) error) error {
+	return fn(ks.meta)
+}
+
+// fileKeystore keeps keys in memory and writes the whole keystore to a
+// single encrypted file after every change. The file is locked while it's
+// open, since another process writing it would erase the keys created here.
+type fileKeystore struct {
+	memoryKeystore
+
+	path    string
+	salt    []byte
+	key     []byte
+	release func() error
+
+	mu sync.Mutex
+}
+
+// fileContents is the plaintext of the keystore file, Keys holds the
+// keybase's armored key infos, whose private keys are encrypted with the
+// keys' passwords on top of the file encryption
+type fileContents struct {
+	Keys map[string]string `json:"keys"`
+	Meta map[string][]byte `json:"meta"`
+}
+
+// NewFileKeystore opens the keystore file at path, creating it if it doesn't
+// exist. It fails if another process has the file open, the lock is held
+// until the process exits.
+func NewFileKeystore(path, password string) (_ Keystore, err error) {
+	if password == "" {
+		return nil, errors.New("the file keystore requires a password")
+	}
+
+	ks := &fileKeystore{
+		memoryKeystore: memoryKeystore{kb: ckeys.NewInMemory(), meta: dbm.NewMemDB()},
+		path:           path,
+	}
+
+	// the file is replaced on every write, so the lock is on a file beside it
+	if ks.release, err = lockFile(path + ".lock"); err != nil {
+		return nil, fmt.Errorf("keystore file %s is in use: %s", path, err)
+	}
+	defer func() {
+		if err != nil {
+			ks.release()
+		}
+	}()
+
+	bz, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		ks.salt = crypto.CRandBytes(16)
+		if ks.key, err = sealingKey(ks.salt, password); err != nil {
+			return nil, err
+		}
+		return ks, ks.flush()
+	} else if err != nil {
+		return nil, err
+	}
+
+	var sealed sealedSecret
+	if err = json.Unmarshal(bz, &sealed); err != nil {
+		return nil, fmt.Errorf("invalid keystore file %s: %s", path, err)
+	}
+	ks.salt = sealed.Salt
+	if ks.key, err = sealingKey(ks.salt, password); err != nil {
+		return nil, err
+	}
+
+	plain, err := xsalsa20symmetric.DecryptSymmetric(sealed.Ciphertext, ks.key)
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt keystore file %s, wrong password?", path)
+	}
+
+	var contents fileContents
+	if err = json.Unmarshal(plain, &contents); err != nil {
+		return nil, fmt.Errorf("invalid keystore file %s: %s", path, err)
+	}
+	for name, armor := range contents.Keys {
+		if err = ks.kb.Import(name, armor); err != nil {
+			return nil, fmt.Errorf("invalid key %s in keystore file %s: %s", name, path, err)
+		}
+	}
+	for name, bz := range contents.Meta {
+		ks.meta.Set([]byte(name), bz)
+	}
+	return ks, nil
+}
+
+// close releases the lock on the keystore file
+func (ks *fileKeystore) close() error {
+	return ks.release()
+}
+
+func (ks *fileKeystore) Keybase() (ckeys.Keybase, error) {
+	return fileKeybase{ks.kb, ks}, nil
+}
+
+func (ks *fileKeystore) Meta(fn func(db dbm.DB) error) error {
+	db := &dirtyDB{DB: ks.meta}
+	err := fn(db)
+
+	// reads leave the file as it is, writes are flushed even if fn failed
+	// afterwards so the file matches what is held in memory
+	if db.dirty {
+		if ferr := ks.flush(); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+// dirtyDB records whether anything was written to the db
+type dirtyDB struct {
+	dbm.DB
+	dirty bool
+}
+
+func (db *dirtyDB) Set(key, value []byte) {
+	db.dirty = true
+	db.DB.Set(key, value)
+}
+
+func (db *dirtyDB) SetSync(key, value []byte) {
+	db.dirty = true
+	db.DB.SetSync(key, value)
+}
+
+func (db *dirtyDB) Delete(key []byte) {
+	db.dirty = true
+	db.DB.Delete(key)
+}
+
+func (db *dirtyDB) DeleteSync(key []byte) {
+	db.dirty = true
+	db.DB.DeleteSync(key)
+}
+
+func (db *dirtyDB) NewBatch() dbm.Batch {
+	return dirtyBatch{db.DB.NewBatch(), db}
+}
+
+// dirtyBatch marks its db dirty when it is written
+type dirtyBatch struct {
+	dbm.Batch
+	db *dirtyDB
+}
+
+func (b dirtyBatch) Write() {
+	b.db.dirty = true
+	b.Batch.Write()
+}
+
+func (b dirtyBatch) WriteSync() {
+	b.db.dirty = true
+	b.Batch.WriteSync()
+}
+
+// flush writes the keystore to a temporary file and moves it over the keystore file
+func (ks *fileKeystore) flush() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	contents := fileContents{Keys: make(map[string]string), Meta: make(map[string][]byte)}
+	infos, err := ks.kb.List()
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if contents.Keys[info.GetName()], err = ks.kb.Export(info.GetName()); err != nil {
+			return err
+		}
+	}
+
+	iter := ks.meta.Iterator(nil, nil)
+	for ; iter.Valid(); iter.Next() {
+		contents.Meta[string(iter.Key())] = iter.Value()
+	}
+	iter.Close()
+
+	plain, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+	bz, err := json.Marshal(sealedSecret{Salt: ks.salt, Ciphertext: xsalsa20symmetric.EncryptSymmetric(plain, ks.key)})
+	if err != nil {
+		return err
+	}
+
+	tmp := ks.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, bz, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ks.path)
+}
+
+// fileKeybase writes the keystore file after every change to the keybase
+type fileKeybase struct {
+	ckeys.Keybase
+	ks *fileKeystore
+}
+
+func (kb fileKeybase) CreateMnemonic(name string, language ckeys.Language, passwd string, algo ckeys.SigningAlgo) (ckeys.Info, string, error) {
+	info, mnemonic, err := kb.Keybase.CreateMnemonic(name, language, passwd, algo)
+	if err != nil {
+		return nil, "", err
+	}
+	return info, mnemonic, kb.ks.flush()
+}
+
+func (kb fileKeybase) CreateAccount(name, mnemonic, bip39Passwd, encryptPasswd string, account uint32, index uint32) (ckeys.Info, error) {
+	return kb.flushInfo(kb.Keybase.CreateAccount(name, mnemonic, bip39Passwd, encryptPasswd, account, index))
+}
+
+func (kb fileKeybase) Derive(name, mnemonic, bip39Passwd, encryptPasswd string, params hd.BIP44Params) (ckeys.Info, error) {
+	return kb.flushInfo(kb.Keybase.Derive(name, mnemonic, bip39Passwd, encryptPasswd, params))
+}
+
+func (kb fileKeybase) CreateLedger(name string, algo ckeys.SigningAlgo, hrp string, account, index uint32) (ckeys.Info, error) {
+	return kb.flushInfo(kb.Keybase.CreateLedger(name, algo, hrp, account, index))
+}
+
+func (kb fileKeybase) CreateOffline(name string, pubkey crypto.PubKey) (ckeys.Info, error) {
+	return kb.flushInfo(kb.Keybase.CreateOffline(name, pubkey))
+}
+
+func (kb fileKeybase) CreateMulti(name string, pubkey crypto.PubKey) (ckeys.Info, error) {
+	return kb.flushInfo(kb.Keybase.CreateMulti(name, pubkey))
+}
+
+func (kb fileKeybase) Update(name, oldpass string, getNewpass func() (string, error)) error {
+	return kb.flushErr(kb.Keybase.Update(name, oldpass, getNewpass))
+}
+
+func (kb fileKeybase) Delete(name, passphrase string, skipPass bool) error {
+	return kb.flushErr(kb.Keybase.Delete(name, passphrase, skipPass))
+}
+
+func (kb fileKeybase) Import(name string, armor string) error {
+	return kb.flushErr(kb.Keybase.Import(name, armor))
+}
+
+func (kb fileKeybase) ImportPrivKey(name, armor, passphrase string) error {
+	return kb.flushErr(kb.Keybase.ImportPrivKey(name, armor, passphrase))
+}
+
+func (kb fileKeybase) ImportPubKey(name string, armor string) error {
+	return kb.flushErr(kb.Keybase.ImportPubKey(name, armor))
+}
+
+func (kb fileKeybase) flushInfo(info ckeys.Info, err error) (ckeys.Info, error) {
+	if err != nil {
+		return nil, err
+	}
+	return info, kb.ks.flush()
+}
+
+func (kb fileKeybase) flushErr(err error) error {
+	if err != nil {
+		return err
+	}
+	return kb.ks.flush()
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	ckeys "github.com/cosmos/cosmos-sdk/crypto/keys"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/keyerror"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// NextCursorHeader carries the cursor for the next page of GET /keys
+	NextCursorHeader = "X-Next-Cursor"
+
+	maxLabelLength         = 64
+	maxMetadataKeyLength   = 64
+	maxMetadataValueLength = 1024
+)
+
+// keyFilter selects and pages the keys returned by GET /keys
+type keyFilter struct {
+	labels []string
+	typ    string
+	prefix string
+	backup string
+	limit  int
+	cursor string
+}
+
+// parseKeyFilter reads the GET /keys query parameters: keys must carry every
+// label given, be of the given type and have a name starting with prefix.
+// With backup=unconfirmed only keys whose backup is unconfirmed are listed.
+// Keys are ordered by name, cursor is the last name of the previous page and
+// limit caps the page size, all keys are returned when it isn't set.
+func parseKeyFilter(q url.Values) (f keyFilter, err error) {
+	f.labels = q["label"]
+	f.typ = q.Get("type")
+	f.prefix = q.Get("prefix")
+	f.cursor = q.Get("cursor")
+	f.backup = q.Get("backup")
+	if f.backup != "" && f.backup != "unconfirmed" {
+		return f, fmt.Errorf("invalid backup %s, must be unconfirmed", f.backup)
+	}
+	if limit := q.Get("limit"); limit != "" {
+		f.limit, err = strconv.Atoi(limit)
+		if err != nil || f.limit <= 0 {
+			return f, fmt.Errorf("invalid limit %s, must be a positive number", limit)
+		}
+	}
+	return f, nil
+}
+
+func (f keyFilter) match(ko KeyOutput) bool {
+	if f.typ != "" && ko.Type != f.typ {
+		return false
+	}
+	if !strings.HasPrefix(ko.Name, f.prefix) {
+		return false
+	}
+	if f.backup != "" && !ko.BackupUnconfirmed {
+		return false
+	}
+	if f.cursor != "" && ko.Name <= f.cursor {
+		return false
+	}
+	for _, label := range f.labels {
+		if !hasLabel(ko.Labels, label) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply filters keys, which must be sorted by name, and returns the page
+// along with the cursor for the next one if there are more matches
+func (f keyFilter) apply(keys []KeyOutput) (page []KeyOutput, next string) {
+	page = make([]KeyOutput, 0, len(keys))
+	for _, ko := range keys {
+		if !f.match(ko) {
+			continue
+		}
+		if f.limit > 0 && len(page) == f.limit {
+			return page, page[len(page)-1].Name
+		}
+		page = append(page, ko)
+	}
+	return page, ""
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeLabels validates labels and returns them sorted without duplicates
+func normalizeLabels(labels []string) ([]string, error) {
+	seen := make(map[string]bool, len(labels))
+	out := make([]string, 0, len(labels))
+	for _, label := range labels {
+		if label == "" || len(label) > maxLabelLength || strings.ContainsAny(label, " \t\r\n,") {
+			return nil, fmt.Errorf("invalid label %q, labels must be 1 to %d characters without whitespace or commas", label, maxLabelLength)
+		}
+		if !seen[label] {
+			seen[label] = true
+			out = append(out, label)
+		}
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// validateMetadata checks the size of free-form key metadata
+func validateMetadata(metadata map[string]string) error {
+	for k, v := range metadata {
+		if k == "" || len(k) > maxMetadataKeyLength {
+			return fmt.Errorf("invalid metadata key %q, keys must be 1 to %d characters", k, maxMetadataKeyLength)
+		}
+		if len(v) > maxMetadataValueLength {
+			return fmt.Errorf("metadata value for %s is longer than %d characters", k, maxMetadataValueLength)
+		}
+	}
+	return nil
+}
+
+// PatchKeyBody edits a key's labels and metadata. Labels, when present,
+// replace the key's labels. Metadata is merged into the key's metadata,
+// entries set to null are removed.
+type PatchKeyBody struct {
+	Labels   *[]string          `json:"labels,omitempty"`
+	Metadata map[string]*string `json:"metadata,omitempty"`
+}
+
+// Marshal returns the json byte representation of the patch body
+func (pb PatchKeyBody) Marshal() []byte {
+	out, err := json.Marshal(pb)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// apply sets the labels and merges the metadata of the patch into meta
+func (pb PatchKeyBody) apply(meta *keyMeta) (err error) {
+	if pb.Labels != nil {
+		meta.Labels, err = normalizeLabels(*pb.Labels)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(pb.Metadata) > 0 {
+		metadata := make(map[string]string, len(meta.Metadata)+len(pb.Metadata))
+		for k, v := range meta.Metadata {
+			metadata[k] = v
+		}
+		for k, v := range pb.Metadata {
+			if v == nil {
+				delete(metadata, k)
+			} else {
+				metadata[k] = *v
+			}
+		}
+		if err = validateMetadata(metadata); err != nil {
+			return err
+		}
+		if len(metadata) == 0 {
+			metadata = nil
+		}
+		meta.Metadata = metadata
+	}
+	return nil
+}
+
+// PatchKey is the handler for the PATCH /keys/{name}
+func (s *Server) PatchKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	name := vars["name"]
+	var m PatchKeyBody
+
+	kb, err := s.keybase()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	chain, err := s.chain(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	meta, err := s.getMeta(name)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	var keyOutput ckeys.KeyOutput
+	info, err := kb.Get(name)
+	if keyerror.IsErrKeyNotFound(err) && meta.Address != nil {
+		keyOutput = chain.addressOutput(name, meta.Address, "acc")
+	} else if keyerror.IsErrKeyNotFound(err) {
+		writeError(w, r, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	} else {
+		keyOutput, err = chain.keyOutput(info)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	// the patch is applied to the stored metadata under the lock, so
+	// concurrent patches of the same key don't overwrite each other
+	status := http.StatusInternalServerError
+	meta, err = s.updateMeta(name, func(meta *keyMeta) error {
+		status = http.StatusBadRequest
+		if err := m.apply(meta); err != nil {
+			return err
+		}
+		status = http.StatusInternalServerError
+		return nil
+	})
+	if err != nil {
+		writeError(w, r, status, err)
+		return
+	}
+
+	out, err := json.Marshal(newKeyOutput(keyOutput, meta))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+	return
+}
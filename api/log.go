@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	kitlog "github.com/go-kit/kit/log"
+	kitlevel "github.com/go-kit/kit/log/level"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveFields are never written to the logs, any key containing one of them is redacted
+var sensitiveFields = []string{"password", "passphrase", "mnemonic"}
+
+// NewLogger returns a leveled logger writing to w in either the json or logfmt format.
+// Values for keys containing password, passphrase or mnemonic are always redacted.
+func NewLogger(w io.Writer, format, level string) (log.Logger, error) {
+	var src kitlog.Logger
+	switch format {
+	case "", "json":
+		src = kitlog.NewJSONLogger(kitlog.NewSyncWriter(w))
+	case "logfmt":
+		src = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(w))
+	default:
+		return nil, fmt.Errorf("invalid log format %s, expected json or logfmt", format)
+	}
+
+	if level == "" {
+		level = "info"
+	}
+	allow, err := log.AllowLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	src = kitlog.With(src, "ts", kitlog.DefaultTimestampUTC)
+	return leveledLogger{log.NewFilter(kitLogger{src}, allow), level == "debug"}, nil
+}
+
+// leveledLogger records whether debug messages are logged, so callers can
+// skip building expensive debug values
+type leveledLogger struct {
+	log.Logger
+	debug bool
+}
+
+func (l leveledLogger) With(keyvals ...interface{}) log.Logger {
+	return leveledLogger{l.Logger.With(keyvals...), l.debug}
+}
+
+// debugEnabled reports whether logger writes debug messages, only loggers
+// returned by NewLogger are known to
+func debugEnabled(logger log.Logger) bool {
+	l, ok := logger.(leveledLogger)
+	return ok && l.debug
+}
+
+// Logger returns the logger of the server's current config, logging is
+// disabled if none has been set
+func (s *Server) Logger() log.Logger {
+	if logger := s.config().logger; logger != nil {
+		return logger
+	}
+	return log.NewNopLogger()
+}
+
+// SetLogger sets the logger used by the server, it should be called before
+// serving as a reloaded config brings its own logger
+func (s *Server) SetLogger(logger log.Logger) {
+	s.logger = logger
+}
+
+// kitLogger adapts a go-kit logger to the tendermint logger interface
+type kitLogger struct {
+	src kitlog.Logger
+}
+
+func (l kitLogger) Debug(msg string, keyvals ...interface{}) {
+	l.log(kitlevel.Debug(l.src), msg, keyvals)
+}
+
+func (l kitLogger) Info(msg string, keyvals ...interface{}) {
+	l.log(kitlevel.Info(l.src), msg, keyvals)
+}
+
+func (l kitLogger) Error(msg string, keyvals ...interface{}) {
+	l.log(kitlevel.Error(l.src), msg, keyvals)
+}
+
+func (l kitLogger) With(keyvals ...interface{}) log.Logger {
+	return kitLogger{kitlog.With(l.src, redact(keyvals)...)}
+}
+
+func (l kitLogger) log(src kitlog.Logger, msg string, keyvals []interface{}) {
+	src.Log(append([]interface{}{"msg", msg}, redact(keyvals)...)...)
+}
+
+// redact returns a copy of keyvals with sensitive values removed, raw
+// bodies and structs are walked so nested sensitive fields are removed too
+func redact(keyvals []interface{}) []interface{} {
+	out := make([]interface{}, len(keyvals))
+	copy(out, keyvals)
+	for i := 1; i < len(out); i += 2 {
+		if key, ok := out[i-1].(string); ok && isSensitive(key) {
+			out[i] = redacted
+			continue
+		}
+		out[i] = redactValue(out[i])
+	}
+	return out
+}
+
+func redactValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case json.RawMessage:
+		return redactJSON(v)
+	case []byte:
+		return redactJSON(v)
+	case nil, string, error, fmt.Stringer:
+		return v
+	}
+
+	switch reflect.Indirect(reflect.ValueOf(val)).Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		out, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprintf("unloggable value of type %T", val)
+		}
+		return redactJSON(out)
+	}
+	return val
+}
+
+// redactJSON removes sensitive fields from a JSON document, anything that
+// isn't valid JSON is dropped entirely since it can't be inspected
+func redactJSON(bz []byte) interface{} {
+	var doc interface{}
+	if err := json.Unmarshal(bz, &doc); err != nil {
+		return fmt.Sprintf("%d bytes of non-JSON data", len(bz))
+	}
+	out, err := json.Marshal(redactDoc(doc))
+	if err != nil {
+		return fmt.Sprintf("%d bytes of unloggable data", len(bz))
+	}
+	return json.RawMessage(out)
+}
+
+func redactDoc(doc interface{}) interface{} {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			if isSensitive(key) {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactDoc(val)
+		}
+	case []interface{}:
+		for i, val := range v {
+			v[i] = redactDoc(val)
+		}
+	}
+	return doc
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, field := range sensitiveFields {
+		if strings.Contains(key, field) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"sync"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// metaMu guards the metadata db, which the leveldb keystore opens per operation
+var metaMu sync.Mutex
+
+// keyMeta is what the keyserver stores about a key beyond the keybase's Info
+type keyMeta struct {
+	HDPath string `json:"hd_path,omitempty"`
+
+	Labels   []string          `json:"labels,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Seed is the key's mnemonic encrypted with its password, retained on
+	// request so more keys can be derived from it
+	Seed *sealedSecret `json:"seed,omitempty"`
+
+	// Signer is set for keys whose private key is held by a signing backend
+	Signer *remoteKey `json:"signer,omitempty"`
+
+	// Backup is set until the operator confirms they backed up the mnemonic
+	Backup *backupCheck `json:"backup,omitempty"`
+
+	// Address is set for watch-only addresses, which have no entry in the keybase
+	Address []byte `json:"address,omitempty"`
+}
+
+// withMeta runs fn against the keystore's metadata db
+func (s *Server) withMeta(fn func(db dbm.DB) error) error {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+
+	return s.store().Meta(fn)
+}
+
+// getMeta returns the metadata for the named key, keys without any have a zero keyMeta
+func (s *Server) getMeta(name string) (meta keyMeta, err error) {
+	err = s.withMeta(func(db dbm.DB) (err error) {
+		meta, err = readMeta(db, name)
+		return err
+	})
+	return
+}
+
+// readMeta returns the metadata for the named key from db
+func readMeta(db dbm.DB, name string) (meta keyMeta, err error) {
+	if bz := db.Get([]byte(name)); bz != nil {
+		err = json.Unmarshal(bz, &meta)
+	}
+	return
+}
+
+// setMeta stores the metadata for the named key
+func (s *Server) setMeta(name string, meta keyMeta) error {
+	bz, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return s.withMeta(func(db dbm.DB) error {
+		db.SetSync([]byte(name), bz)
+		return nil
+	})
+}
+
+// updateMeta applies fn to the metadata of the named key and stores the
+// result, reading and writing under one lock so concurrent updates aren't
+// lost. Nothing is stored if fn returns an error.
+func (s *Server) updateMeta(name string, fn func(meta *keyMeta) error) (meta keyMeta, err error) {
+	err = s.withMeta(func(db dbm.DB) (err error) {
+		if meta, err = readMeta(db, name); err != nil {
+			return err
+		}
+		if err := fn(&meta); err != nil {
+			return err
+		}
+		bz, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		db.SetSync([]byte(name), bz)
+		return nil
+	})
+	return
+}
+
+// deleteMeta removes the metadata for the named key
+func (s *Server) deleteMeta(name string) error {
+	return s.withMeta(func(db dbm.DB) error {
+		db.DeleteSync([]byte(name))
+		return nil
+	})
+}
+
+// listMeta returns the metadata of every key that has any
+func (s *Server) listMeta() (metas map[string]keyMeta, err error) {
+	metas = make(map[string]keyMeta)
+	err = s.withMeta(func(db dbm.DB) error {
+		iter := db.Iterator(nil, nil)
+		defer iter.Close()
+		for ; iter.Valid(); iter.Next() {
+			var meta keyMeta
+			if err := json.Unmarshal(iter.Value(), &meta); err != nil {
+				return err
+			}
+			metas[string(iter.Key())] = meta
+		}
+		return nil
+	})
+	return
+}
+
+// moveMeta stores the metadata under the new name and removes the old entry
+// in one batch, the caller holds the metadata lock
+func moveMeta(db dbm.DB, name, newName string, meta keyMeta) error {
+	bz, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	batch := db.NewBatch()
+	defer batch.Close()
+	batch.Set([]byte(newName), bz)
+	batch.Delete([]byte(name))
+	batch.WriteSync()
+	return nil
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+// RequestIDHeader is the header used to propagate request IDs, it is
+// generated if missing and echoed on every response
+const RequestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,128}$`)
+
+type ctxKey int
+
+const requestStateKey ctxKey = iota
+
+// requestState carries per request logging context through the handlers
+type requestState struct {
+	id     string
+	config *Server
+	logger log.Logger
+	err    error
+}
+
+// requestMiddleware assigns a request ID and logs one structured line per request
+func (s *Server) requestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// the request is served with the config current when it started
+		cfg := s.config()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		rs := &requestState{id: id, config: cfg, logger: cfg.Logger().With("request_id", id)}
+		r = r.WithContext(context.WithValue(r.Context(), requestStateKey, rs))
+
+		if debugEnabled(rs.logger) && r.Body != nil {
+			logBody(rs.logger, r)
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		keyvals := []interface{}{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status(),
+			"bytes", sw.bytes,
+			"duration_ms", time.Since(start).Nanoseconds() / int64(time.Millisecond),
+			"remote", r.RemoteAddr,
+		}
+		if rs.err != nil {
+			keyvals = append(keyvals, "err", rs.err)
+		}
+
+		if sw.status() >= http.StatusInternalServerError {
+			rs.logger.Error("request", keyvals...)
+			return
+		}
+		rs.logger.Info("request", keyvals...)
+	})
+}
+
+// maxLoggedBody is the size of the largest request body logged at debug
+const maxLoggedBody = 64 << 10
+
+// logBody logs the request body, bodies larger than maxLoggedBody are only
+// logged by size. The part read is put back for the handler.
+func logBody(logger log.Logger, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxLoggedBody+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || len(body) == 0 {
+		return
+	}
+	if len(body) > maxLoggedBody {
+		logger.Debug("request body", "body", fmt.Sprintf("more than %d bytes, not logged", maxLoggedBody))
+		return
+	}
+	logger.Debug("request body", "body", json.RawMessage(body))
+}
+
+// requestLogger returns the logger for the request, tagged with its request ID
+func requestLogger(r *http.Request) log.Logger {
+	if rs, ok := r.Context().Value(requestStateKey).(*requestState); ok {
+		return rs.logger
+	}
+	return log.NewNopLogger()
+}
+
+// requestID returns the ID assigned to the request by requestMiddleware
+func requestID(r *http.Request) string {
+	if rs, ok := r.Context().Value(requestStateKey).(*requestState); ok {
+		return rs.id
+	}
+	return ""
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// statusWriter records the status code and size of a response
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.code == 0 {
+		sw.code = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.code == 0 {
+		sw.code = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += n
+	return n, err
+}
+
+func (sw *statusWriter) status() int {
+	if sw.code == 0 {
+		return http.StatusOK
+	}
+	return sw.code
+}
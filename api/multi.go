@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/cosmos/cosmos-sdk/crypto/keys/keyerror"
+	"github.com/tendermint/tendermint/crypto"
+	"github.com/tendermint/tendermint/crypto/multisig"
+)
+
+// MultiKeyBody registers a multisig key from the public keys of the keys
+// named in Keys, any Threshold of them can sign for it. Watch-only keys
+// registered from a public key can be members.
+type MultiKeyBody struct {
+	Name      string   `json:"name"`
+	Keys      []string `json:"keys"`
+	Threshold int      `json:"threshold"`
+}
+
+// Marshal returns the json byte representation of the multisig key body
+func (mb MultiKeyBody) Marshal() []byte {
+	out, err := json.Marshal(mb)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// PostMultiKey is the handler for the POST /keys/multi
+func (s *Server) PostMultiKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var m MultiKeyBody
+
+	kb, err := s.keybase()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	chain, err := s.chain(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if m.Name == "" || len(m.Keys) == 0 {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("must include a name and the member keys with request"))
+		return
+	}
+	if m.Threshold < 1 || m.Threshold > len(m.Keys) {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid threshold %d, must be between 1 and the number of keys", m.Threshold))
+		return
+	}
+
+	exists, err := s.keyExists(kb, m.Name)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	} else if exists {
+		writeError(w, r, http.StatusConflict, errKeyExists(m.Name))
+		return
+	}
+
+	pubs := make([]crypto.PubKey, len(m.Keys))
+	seen := make(map[string]bool, len(m.Keys))
+	for i, name := range m.Keys {
+		if seen[name] {
+			writeError(w, r, http.StatusBadRequest, fmt.Errorf("key %s is listed more than once", name))
+			return
+		}
+		seen[name] = true
+
+		info, err := kb.Get(name)
+		if keyerror.IsErrKeyNotFound(err) {
+			// watch-only addresses are only in the metadata and have no public key
+			if meta, merr := s.getMeta(name); merr == nil && meta.Address != nil {
+				writeError(w, r, http.StatusBadRequest, withCode(CodeInvalidRequest, fmt.Errorf("key %s is a watch-only address without a public key, it can't be a multisig member", name), "name", name))
+				return
+			}
+			writeError(w, r, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		pubs[i] = info.GetPubKey()
+	}
+
+	info, err := kb.CreateMulti(m.Name, multisig.NewPubKeyMultisigThreshold(m.Threshold, pubs))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	keyOutput, err := chain.keyOutput(info)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	out, err := json.Marshal(newKeyOutput(keyOutput, keyMeta{}))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+	return
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	ckeys "github.com/cosmos/cosmos-sdk/crypto/keys"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/tendermint/tendermint/crypto"
+)
+
+// keyTypeAddress is the type of watch-only addresses registered without a public key
+const keyTypeAddress = "address"
+
+// OfflineKeyBody registers a watch-only key from a bech32 account public key,
+// or from a bech32 account address when the public key isn't known
+type OfflineKeyBody struct {
+	Name    string `json:"name"`
+	PubKey  string `json:"pubkey,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// Marshal returns the json byte representation of the offline key body
+func (ob OfflineKeyBody) Marshal() []byte {
+	out, err := json.Marshal(ob)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// PostOfflineKey is the handler for the POST /keys/offline
+func (s *Server) PostOfflineKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var m OfflineKeyBody
+
+	kb, err := s.keybase()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	chain, err := s.chain(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if m.Name == "" || (m.PubKey == "") == (m.Address == "") {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("must include a name and either a pubkey or an address with request"))
+		return
+	}
+
+	exists, err := s.keyExists(kb, m.Name)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	} else if exists {
+		writeError(w, r, http.StatusConflict, errKeyExists(m.Name))
+		return
+	}
+
+	var keyOutput ckeys.KeyOutput
+	if m.PubKey != "" {
+		var pub crypto.PubKey
+		err = chain.encode(func() (err error) {
+			pub, err = sdk.GetAccPubKeyBech32(m.PubKey)
+			return err
+		})
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid pubkey %s: %s", m.PubKey, err))
+			return
+		}
+
+		info, err := kb.CreateOffline(m.Name, pub)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		keyOutput, err = chain.keyOutput(info)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	} else {
+		var addr sdk.AccAddress
+		err = chain.encode(func() (err error) {
+			addr, err = sdk.AccAddressFromBech32(m.Address)
+			return err
+		})
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid address %s: %s", m.Address, err))
+			return
+		}
+
+		if err = s.setMeta(m.Name, keyMeta{Address: addr}); err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		keyOutput = chain.addressOutput(m.Name, addr, "acc")
+	}
+
+	out, err := json.Marshal(newKeyOutput(keyOutput, keyMeta{}))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+	return
+}
+
+// keyExists reports whether the name is taken by a key or a watch-only address
+func (s *Server) keyExists(kb ckeys.Keybase, name string) (bool, error) {
+	if _, err := kb.Get(name); err == nil {
+		return true, nil
+	}
+	meta, err := s.getMeta(name)
+	if err != nil {
+		return false, err
+	}
+	return meta.Address != nil, nil
+}
+
+// addressKeyOutput returns the output for a watch-only address with the acc, val or cons prefix
+func addressKeyOutput(name string, addr sdk.AccAddress, bechPrefix string) ckeys.KeyOutput {
+	bech := addr.String()
+	switch bechPrefix {
+	case "val":
+		bech = sdk.ValAddress(addr).String()
+	case "cons":
+		bech = sdk.ConsAddress(addr).String()
+	}
+	return ckeys.NewKeyOutput(name, keyTypeAddress, bech, "")
+}
+
+// canSign returns an error explaining why the key can't sign on this keyserver, if it can't
+func canSign(info ckeys.Info) error {
+	if info.GetType() != ckeys.TypeLocal {
+		return errWatchOnly(info.GetName(), info.GetType().String())
+	}
+	return nil
+}
+
+// errWatchOnly is returned when signing with a key whose private key isn't
+// held by the keyserver
+func errWatchOnly(name, keyType string) error {
+	return withCode(CodeInvalidRequest, fmt.Errorf("key %s is a watch-only %s key, its private key isn't held by the keyserver so it can't sign", name, keyType), "name", name, "type", keyType)
+}
@@ -0,0 +1,465 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// OpenAPIVersion is the version of the OpenAPI specification served at
+// /openapi.json. 3.1 is used because the delete route takes a body.
+const OpenAPIVersion = "3.1.0"
+
+// operation documents a route registered in Router, the request and
+// response bodies are described by the schemas of the zero values given
+type operation struct {
+	method, path string
+	summary      string
+	tag          string
+	query        []parameter
+	chain        bool
+	request      interface{}
+	optionalBody bool
+	status       int
+	response     interface{}
+	contentType  string
+}
+
+// parameter is a query parameter of an operation
+type parameter struct {
+	name, description string
+	schema            map[string]interface{}
+}
+
+// Marker types for bodies whose schemas are written by hand rather than
+// derived from a go type
+type (
+	empty    struct{}
+	health   struct{}
+	metrics  struct{}
+	spec     struct{}
+	docs     struct{}
+	rawTx    struct{}
+	stdTx    struct{}
+	txResult struct{}
+)
+
+var (
+	stringParam  = map[string]interface{}{"type": "string"}
+	integerParam = map[string]interface{}{"type": "integer", "minimum": 1}
+	arrayParam   = map[string]interface{}{"type": "array", "items": stringParam}
+)
+
+// operations are the routes of the keyserver, TestOpenAPI fails when a route
+// registered in Router is missing here
+var operations = []operation{
+	{method: "GET", path: "/version", tag: "server", summary: "Version of the keyserver", status: 200, response: VersionInfo{}},
+	{method: "GET", path: "/healthz", tag: "server", summary: "Liveness, ok while the process serves", status: 200, response: health{}},
+	{method: "GET", path: "/readyz", tag: "server", summary: "Readiness of the keybase and nodes, 503 when not ready", status: 200, response: Readiness{}},
+	{method: "GET", path: "/metrics", tag: "server", summary: "Config reload metrics in the prometheus text format", status: 200, response: metrics{}, contentType: "text/plain"},
+	{method: "GET", path: "/openapi.json", tag: "server", summary: "This specification", status: 200, response: spec{}},
+	{method: "GET", path: "/docs", tag: "server", summary: "Documentation page rendering this specification", status: 200, response: docs{}, contentType: "text/html"},
+
+	{method: "GET", path: "/keys", tag: "keys", summary: "List keys, the next page cursor is returned in the X-Next-Cursor header", chain: true, status: 200, response: []KeyOutput{}, query: []parameter{
+		{"label", "Only keys carrying the label, repeat for several", arrayParam},
+		{"type", "Only keys of the type, local, ledger, offline, multi or a remote backend", stringParam},
+		{"prefix", "Only keys whose name starts with prefix", stringParam},
+		{"backup", "unconfirmed lists only keys whose mnemonic backup is unconfirmed", map[string]interface{}{"type": "string", "enum": []string{"unconfirmed"}}},
+		{"limit", "Maximum number of keys returned", integerParam},
+		{"cursor", "Name of the last key of the previous page", stringParam},
+	}},
+	{method: "POST", path: "/keys", tag: "keys", summary: "Create a key, from a new mnemonic unless one is given", chain: true, request: AddNewKey{}, status: 200, response: KeyOutput{}},
+	{method: "GET", path: "/keys/{name}", tag: "keys", summary: "Get a key", chain: true, status: 200, response: KeyOutput{}, query: []parameter{
+		{"bech", "Bech32 prefix type of the address and pubkey", map[string]interface{}{"type": "string", "enum": []string{"acc", "val", "cons"}, "default": "acc"}},
+	}},
+	{method: "PUT", path: "/keys/{name}", tag: "keys", summary: "Change the password of a key", request: UpdateKeyBody{}, status: 204, response: empty{}},
+	{method: "PATCH", path: "/keys/{name}", tag: "keys", summary: "Set the labels and metadata of a key, null metadata values are removed", chain: true, request: PatchKeyBody{}, status: 200, response: KeyOutput{}},
+	{method: "DELETE", path: "/keys/{name}", tag: "keys", summary: "Delete a key", request: DeleteKeyBody{}, status: 200, response: empty{}},
+	{method: "POST", path: "/keys/import", tag: "keys", summary: "Import an ascii armored private key", chain: true, request: ImportKeyBody{}, status: 200, response: KeyOutput{}},
+	{method: "POST", path: "/keys/offline", tag: "keys", summary: "Store a pubkey or address without its private key", chain: true, request: OfflineKeyBody{}, status: 200, response: KeyOutput{}},
+	{method: "POST", path: "/keys/derive", tag: "keys", summary: "Derive keys in bulk from a mnemonic or a key's retained mnemonic", chain: true, request: DeriveKeysBody{}, status: 200, response: []DerivedKey{}},
+	{method: "POST", path: "/keys/remote", tag: "keys", summary: "Store a key held by a remote signer", chain: true, request: RemoteKeyBody{}, status: 200, response: KeyOutput{}},
+	{method: "POST", path: "/keys/multi", tag: "keys", summary: "Store a multisig key from the public keys of registered keys, watch-only ones included", chain: true, request: MultiKeyBody{}, status: 200, response: KeyOutput{}},
+	{method: "POST", path: "/keys/{name}/export", tag: "keys", summary: "Export a key as an ascii armored private key, or its pubkey with pubkey=true", request: ExportKeyBody{}, optionalBody: true, status: 200, response: KeyArmor{}, query: []parameter{
+		{"pubkey", "Export the armored pubkey, no body is needed", map[string]interface{}{"type": "boolean"}},
+	}},
+	{method: "POST", path: "/keys/{name}/rename", tag: "keys", summary: "Rename a key", chain: true, request: RenameKeyBody{}, status: 200, response: KeyOutput{}},
+	{method: "POST", path: "/keys/{name}/backup/confirm", tag: "keys", summary: "Confirm the mnemonic backup of a key with the requested words", chain: true, request: BackupConfirmBody{}, status: 200, response: KeyOutput{}},
+	{method: "POST", path: "/keys/{name}/backup/reveal", tag: "keys", summary: "Reveal the retained mnemonic of a key", request: BackupRevealBody{}, status: 200, response: Mnemonic{}},
+
+	{method: "POST", path: "/tx/sign", tag: "tx", summary: "Sign a transaction, chain_id defaults to the chain profile's and is required if it has none", chain: true, request: SignBody{}, status: 200, response: stdTx{}},
+	{method: "POST", path: "/tx/verify", tag: "tx", summary: "Verify the signatures of a transaction and name the registered key of each signer", chain: true, request: VerifyBody{}, status: 200, response: VerifyResult{}},
+	{method: "POST", path: "/tx/broadcast", tag: "tx", summary: "Broadcast a signed transaction, returning once the node has checked it but before it is in a block, 422 if the check fails", chain: true, request: rawTx{}, status: 200, response: txResult{}},
+	{method: "POST", path: "/tx/bank/send", tag: "tx", summary: "Build an unsigned bank send transaction", chain: true, request: BankSendBody{}, status: 200, response: stdTx{}},
+}
+
+// OpenAPI is the handler for GET /openapi.json
+func (s *Server) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	out, err := json.Marshal(s.openAPISpec())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+// openAPISpec returns the OpenAPI document for the routes in operations
+func (s *Server) openAPISpec() map[string]interface{} {
+	sg := newSchemaGen()
+	errorSchema := sg.schema(reflect.TypeOf(restError{}))
+	// list the error codes so clients can match on them
+	codes := []string{}
+	for code := range codeStatus {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	errorProps := sg.components["Error"].(map[string]interface{})["properties"].(map[string]interface{})
+	errorProps["code"] = map[string]interface{}{"type": "string", "enum": codes}
+
+	chains := []string{}
+	for _, c := range s.Chains {
+		chains = append(chains, c.Name)
+	}
+	chainParam := map[string]interface{}{"type": "string"}
+	if len(chains) > 0 {
+		chainParam["enum"] = chains
+	}
+
+	paths := map[string]map[string]interface{}{}
+	for _, op := range operations {
+		params := []interface{}{}
+		for _, name := range pathParams(op.path) {
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "path", "required": true, "schema": stringParam,
+			})
+		}
+		if op.chain {
+			params = append(params, map[string]interface{}{
+				"name": "chain", "in": "query", "schema": chainParam,
+				"description": "Chain profile to use, the default node and prefixes when not set",
+			})
+		}
+		for _, p := range op.query {
+			params = append(params, map[string]interface{}{
+				"name": p.name, "in": "query", "description": p.description, "schema": p.schema,
+			})
+		}
+
+		responses := map[string]interface{}{
+			"default": map[string]interface{}{
+				"description": "Error, the code is stable and sets the status",
+				"content":     jsonContent(errorSchema),
+			},
+		}
+		responses[strconv.Itoa(op.status)] = sg.response(op)
+
+		o := map[string]interface{}{
+			"operationId": operationID(op),
+			"summary":     op.summary,
+			"tags":        []string{op.tag},
+			"responses":   responses,
+		}
+		if len(params) > 0 {
+			o["parameters"] = params
+		}
+		if op.request != nil {
+			o["requestBody"] = map[string]interface{}{
+				"required": !op.optionalBody,
+				"content":  jsonContent(sg.schema(reflect.TypeOf(op.request))),
+			}
+		}
+		if paths[op.path] == nil {
+			paths[op.path] = map[string]interface{}{}
+		}
+		paths[op.path][strings.ToLower(op.method)] = o
+	}
+
+	return map[string]interface{}{
+		"openapi": OpenAPIVersion,
+		"info": map[string]interface{}{
+			"title":       "keyserver",
+			"description": "A key management server for cosmos-sdk chains",
+			"version":     s.Version,
+		},
+		"tags": []interface{}{
+			map[string]string{"name": "server", "description": "Health, metrics and documentation"},
+			map[string]string{"name": "keys", "description": "Create, list and manage keys"},
+			map[string]string{"name": "tx", "description": "Build, sign and broadcast transactions"},
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": sg.components},
+	}
+}
+
+var pathParamRe = regexp.MustCompile(`{([^}]+)}`)
+
+// pathParams returns the names of the variables in a route path
+func pathParams(path string) (names []string) {
+	for _, m := range pathParamRe.FindAllStringSubmatch(path, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// operationID returns a unique id for op such as getKeysName
+func operationID(op operation) string {
+	id := strings.ToLower(op.method)
+	for _, part := range strings.FieldsFunc(op.path, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '.'
+	}) {
+		id += strings.Title(part)
+	}
+	return id
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// schemaGen derives JSON schemas from go types by their json tags, exported
+// structs are added to the components and referenced
+type schemaGen struct {
+	components map[string]interface{}
+}
+
+func newSchemaGen() *schemaGen {
+	sg := &schemaGen{components: map[string]interface{}{}}
+	sg.components["StdTx"] = stdTxSchema
+	sg.components["TxResponse"] = txResponseSchema
+	return sg
+}
+
+// response returns the response object of op
+func (sg *schemaGen) response(op operation) map[string]interface{} {
+	resp := map[string]interface{}{"description": http.StatusText(op.status)}
+	var schema interface{}
+	switch op.response.(type) {
+	case empty:
+		return resp
+	case health:
+		schema = map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"status": map[string]interface{}{"type": "string", "enum": []string{"ok"}}},
+		}
+	case metrics, docs:
+		resp["content"] = map[string]interface{}{op.contentType: map[string]interface{}{"schema": stringParam}}
+		return resp
+	case spec:
+		schema = map[string]interface{}{"type": "object", "description": "OpenAPI " + OpenAPIVersion + " document"}
+	case stdTx:
+		schema = ref("StdTx")
+	case txResult:
+		schema = ref("TxResponse")
+	default:
+		schema = sg.schema(reflect.TypeOf(op.response))
+	}
+	resp["content"] = jsonContent(schema)
+	if op.path == "/keys" && op.method == "GET" {
+		resp["headers"] = map[string]interface{}{
+			NextCursorHeader: map[string]interface{}{
+				"description": "Cursor of the next page, not set on the last page",
+				"schema":      stringParam,
+			},
+		}
+	}
+	return resp
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+var (
+	accAddressType = reflect.TypeOf(sdk.AccAddress{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	rawTxType      = reflect.TypeOf(rawTx{})
+	apiPkgPath     = reflect.TypeOf((*Server)(nil)).Elem().PkgPath()
+)
+
+// componentName returns the name t is referenced by, or "" to inline it
+func componentName(t reflect.Type) string {
+	switch {
+	case t == reflect.TypeOf(restError{}):
+		return "Error"
+	case t.PkgPath() == apiPkgPath && exported(t.Name()):
+		return t.Name()
+	}
+	return ""
+}
+
+// exported reports whether name is exported
+func exported(name string) bool {
+	return name != "" && strings.ToUpper(name[:1]) == name[:1]
+}
+
+// schema returns the JSON schema of values of t
+func (sg *schemaGen) schema(t reflect.Type) interface{} {
+	switch t {
+	case accAddressType:
+		return map[string]interface{}{"type": "string", "description": "bech32 account address"}
+	case rawMessageType:
+		return map[string]interface{}{"description": "amino JSON"}
+	case rawTxType:
+		return map[string]interface{}{
+			"type":       "object",
+			"required":   []string{"tx"},
+			"properties": map[string]interface{}{"tx": ref("StdTx")},
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return sg.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": sg.schema(t.Elem())}
+	case reflect.Map:
+		obj := map[string]interface{}{"type": "object", "additionalProperties": sg.schema(t.Elem())}
+		if t.Elem().Kind() == reflect.Ptr {
+			// a nil value is encoded as null, as PatchKeyBody uses to delete metadata
+			obj["additionalProperties"] = map[string]interface{}{"anyOf": []interface{}{sg.schema(t.Elem()), map[string]interface{}{"type": "null"}}}
+		}
+		if t.Key().Kind() != reflect.String {
+			obj["propertyNames"] = map[string]interface{}{"pattern": "^-?[0-9]+$"}
+		}
+		return obj
+	case reflect.Struct:
+		name := componentName(t)
+		if name == "" {
+			return sg.object(t)
+		}
+		if _, ok := sg.components[name]; !ok {
+			// reserve the name first so recursive types terminate
+			sg.components[name] = nil
+			sg.components[name] = sg.object(t)
+		}
+		return ref(name)
+	}
+	return map[string]interface{}{}
+}
+
+// object returns the schema of a struct, the fields of embedded structs are
+// promoted as encoding/json does
+func (sg *schemaGen) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	required := []string{}
+	sg.fields(t, props, &required)
+	obj := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		sort.Strings(required)
+		obj["required"] = required
+	}
+	return obj
+}
+
+func (sg *schemaGen) fields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		if f.Anonymous && opts[0] == "" && f.Type.Kind() == reflect.Struct {
+			sg.fields(f.Type, props, required)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		name := opts[0]
+		if name == "" {
+			name = f.Name
+		}
+
+		var schema interface{} = sg.schema(f.Type)
+		omitempty := false
+		for _, opt := range opts[1:] {
+			switch opt {
+			case "omitempty":
+				omitempty = true
+			case "string":
+				schema = map[string]interface{}{"type": "string", "pattern": "^-?[0-9]+$"}
+			}
+		}
+		props[name] = schema
+		if !omitempty && f.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// stdTxSchema is the amino JSON of an auth.StdTx
+var stdTxSchema = map[string]interface{}{
+	"type":     "object",
+	"required": []string{"type", "value"},
+	"properties": map[string]interface{}{
+		"type": map[string]interface{}{"type": "string", "enum": []string{"cosmos-sdk/StdTx"}},
+		"value": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"msg": map[string]interface{}{"type": "array", "items": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"type": stringParam, "value": map[string]interface{}{"type": "object"}},
+				}},
+				"fee": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"amount": coinsSchema,
+						"gas":    map[string]interface{}{"type": "string", "pattern": "^[0-9]+$"},
+					},
+				},
+				"signatures": map[string]interface{}{"type": "array", "items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"pub_key":   map[string]interface{}{"type": "object", "properties": map[string]interface{}{"type": stringParam, "value": stringParam}},
+						"signature": stringParam,
+					},
+				}},
+				"memo": stringParam,
+			},
+		},
+	},
+}
+
+var coinsSchema = map[string]interface{}{"type": "array", "items": map[string]interface{}{
+	"type":       "object",
+	"properties": map[string]interface{}{"denom": stringParam, "amount": map[string]interface{}{"type": "string", "pattern": "^[0-9]+$"}},
+}}
+
+// txResponseSchema is the amino JSON of an sdk.TxResponse
+var txResponseSchema = map[string]interface{}{
+	"type":     "object",
+	"required": []string{"height", "txhash"},
+	"properties": map[string]interface{}{
+		"height":     map[string]interface{}{"type": "string", "pattern": "^[0-9]+$"},
+		"txhash":     stringParam,
+		"code":       map[string]interface{}{"type": "integer"},
+		"data":       stringParam,
+		"raw_log":    stringParam,
+		"logs":       map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}},
+		"info":       stringParam,
+		"gas_wanted": map[string]interface{}{"type": "string", "pattern": "^[0-9]+$"},
+		"gas_used":   map[string]interface{}{"type": "string", "pattern": "^[0-9]+$"},
+		"codespace":  stringParam,
+	},
+}
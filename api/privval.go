@@ -0,0 +1,220 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tendermint/tendermint/crypto/ed25519"
+	cmn "github.com/tendermint/tendermint/libs/common"
+	"github.com/tendermint/tendermint/libs/log"
+	"github.com/tendermint/tendermint/privval"
+	"github.com/tendermint/tendermint/types"
+)
+
+const (
+	// privValDir holds the consensus keys and their last sign states in the key directory
+	privValDir = "privval"
+
+	// privValTimeout bounds reads and writes on the connection to the node,
+	// which pings the signer every two seconds
+	privValTimeout = 3 * time.Second
+)
+
+// privValFiles returns the paths of the key and last sign state files of the consensus key name
+func (s *Server) privValFiles(name string) (keyFile, stateFile string, err error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", "", fmt.Errorf("invalid consensus key name %q", name)
+	}
+	dir := filepath.Join(s.KeyDir, privValDir)
+	return filepath.Join(dir, name+"_key.json"), filepath.Join(dir, name+"_state.json"), nil
+}
+
+// PrivValidator returns the ed25519 consensus key stored as name, generating
+// it if it doesn't exist. The height, round and step of the last vote or
+// proposal it signed are persisted before the signature is returned, and it
+// refuses to sign conflicting messages for them. Consensus keys aren't kept
+// in the Keystore, they are tendermint's plaintext priv_validator_key.json
+// files in the privval directory of KeyDir, so that directory needs the same
+// protection as the node's config directory.
+func (s *Server) PrivValidator(name string) (*privval.FilePV, error) {
+	keyFile, stateFile, err := s.privValFiles(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cmn.FileExists(keyFile) {
+		if err = os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+			return nil, err
+		}
+		pv := privval.GenFilePV(keyFile, stateFile)
+		pv.Save()
+		return pv, nil
+	}
+
+	// LoadFilePV exits the process on missing or invalid files
+	if err = checkPrivValFile(keyFile, &privval.FilePVKey{}); err != nil {
+		return nil, err
+	}
+	if !cmn.FileExists(stateFile) {
+		return nil, fmt.Errorf("last sign state %s of consensus key %s is missing, restore it before signing to avoid double signing", stateFile, name)
+	}
+	if err = checkPrivValFile(stateFile, &privval.FilePVLastSignState{}); err != nil {
+		return nil, err
+	}
+	return privval.LoadFilePV(keyFile, stateFile), nil
+}
+
+// ImportPrivValidator stores the tendermint priv_validator_key.json at keyFile
+// as the consensus key name. The node's priv_validator_state.json should be
+// passed as stateFile so its double signing protection carries over, an empty
+// stateFile starts the key without a last sign state, at height 0.
+func (s *Server) ImportPrivValidator(name, keyFile, stateFile string) error {
+	newKeyFile, newStateFile, err := s.privValFiles(name)
+	if err != nil {
+		return err
+	}
+	if cmn.FileExists(newKeyFile) {
+		return fmt.Errorf("consensus key %s already exists", name)
+	}
+
+	var key privval.FilePVKey
+	if err = checkPrivValFile(keyFile, &key); err != nil {
+		return err
+	}
+	if _, ok := key.PrivKey.(ed25519.PrivKeyEd25519); !ok {
+		return fmt.Errorf("%s doesn't hold an ed25519 key", keyFile)
+	}
+	if stateFile != "" {
+		if err = checkPrivValFile(stateFile, &privval.FilePVLastSignState{}); err != nil {
+			return err
+		}
+	}
+
+	if err = os.MkdirAll(filepath.Dir(newKeyFile), 0700); err != nil {
+		return err
+	}
+	if err = copyPrivValFile(keyFile, newKeyFile); err != nil {
+		return err
+	}
+	if stateFile != "" {
+		err = copyPrivValFile(stateFile, newStateFile)
+	} else {
+		privval.LoadFilePVEmptyState(newKeyFile, newStateFile).LastSignState.Save()
+	}
+	if err != nil {
+		os.Remove(newKeyFile)
+	}
+	return err
+}
+
+// LockPrivValidator takes an exclusive lock on the last sign state of the
+// consensus key name, which is held until release is called or the process
+// exits. Two signers sharing a state could each sign a conflicting vote. The
+// state file is replaced on every write, so the lock is on a file beside it.
+func (s *Server) LockPrivValidator(name string) (release func() error, err error) {
+	_, stateFile, err := s.privValFiles(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(stateFile), 0700); err != nil {
+		return nil, err
+	}
+	release, err = lockFile(stateFile + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("consensus key %s is in use: %s", name, err)
+	}
+	return release, nil
+}
+
+func checkPrivValFile(path string, ptr interface{}) error {
+	bz, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = cdc.UnmarshalJSON(bz, ptr); err != nil {
+		return fmt.Errorf("invalid file %s: %s", path, err)
+	}
+	return nil
+}
+
+func copyPrivValFile(src, dst string) error {
+	bz, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return cmn.WriteFileAtomic(dst, bz, 0600)
+}
+
+// ServePrivValidator connects to the priv_validator_laddr of a tendermint
+// node, a tcp:// or unix:// address, and signs votes and proposals for
+// chainID with pv until stop is closed. Tcp connections are encrypted with
+// SecretConnection. The node is dialed until it accepts the connection and
+// again whenever the connection drops.
+func ServePrivValidator(logger log.Logger, pv types.PrivValidator, chainID, addr string, stop <-chan struct{}) error {
+	var dialer privval.SocketDialer
+	proto, address := cmn.ProtocolAndAddress(addr)
+	switch proto {
+	case "tcp":
+		dialer = privval.DialTCPFn(address, privValTimeout, ed25519.GenPrivKey())
+	case "unix":
+		dialer = privval.DialUnixFn(address)
+	default:
+		return fmt.Errorf("invalid address %s, must be tcp:// or unix://", addr)
+	}
+
+	for {
+		closed := make(chan struct{})
+		endpoint := privval.NewSignerServiceEndpoint(logger, chainID, pv, notifyingDialer(dialer, closed))
+		privval.SignerServiceEndpointTimeoutReadWrite(privValTimeout)(endpoint)
+		if err := endpoint.Start(); err != nil {
+			logger.Error("connecting to node failed, retrying", "addr", addr, "err", err)
+			select {
+			case <-stop:
+				return nil
+			case <-time.After(privValTimeout):
+			}
+			continue
+		}
+		logger.Info("connected to node", "addr", addr, "chain_id", chainID)
+
+		select {
+		case <-stop:
+			return endpoint.Stop()
+		case <-closed:
+			endpoint.Stop()
+			logger.Error("connection to node lost, reconnecting", "addr", addr)
+		}
+	}
+}
+
+// notifyingDialer wraps the connections of dialer to close closed once reading from them fails
+func notifyingDialer(dialer privval.SocketDialer, closed chan struct{}) privval.SocketDialer {
+	return func() (net.Conn, error) {
+		conn, err := dialer()
+		if err != nil {
+			return nil, err
+		}
+		return &notifyingConn{Conn: conn, closed: closed}, nil
+	}
+}
+
+// notifyingConn closes closed on the first read error
+type notifyingConn struct {
+	net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *notifyingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.once.Do(func() { close(c.closed) })
+	}
+	return n, err
+}
@@ -0,0 +1,29 @@
+//go:build windows
+// +build windows
+
+package api
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// errSharingViolation is ERROR_SHARING_VIOLATION, which syscall doesn't define
+const errSharingViolation syscall.Errno = 32
+
+// lockFile opens the file at path without sharing it, creating it if it
+// doesn't exist. The lock is released when release is called or the process
+// exits.
+func lockFile(path string) (release func() error, err error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err == errSharingViolation {
+		return nil, fmt.Errorf("%s is locked by another process", path)
+	} else if err != nil {
+		return nil, err
+	}
+	return func() error { return syscall.CloseHandle(h) }, nil
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// reloadStats counts config reloads for the metrics
+type reloadStats struct {
+	sync.Mutex
+	succeeded   uint64
+	failed      uint64
+	lastFailed  bool
+	lastSuccess time.Time
+}
+
+func (rs *reloadStats) record(err error) {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.lastFailed = err != nil
+	if err != nil {
+		rs.failed++
+		return
+	}
+	rs.succeeded++
+	rs.lastSuccess = time.Now()
+}
+
+// Reload applies the config returned by load while serving. The node, chain
+// profiles, signing backends, mnemonic length and logger of a copy of the
+// current config are replaced and requests started afterwards are served with
+// the copy, requests in flight keep the config they started with. The fields
+// of s aren't changed. An invalid config is rejected and the current one is
+// kept. The port, key directory and keystore are only read at startup,
+// changes to them are returned as restart.
+func (s *Server) Reload(load func() (*Server, error)) (restart []string, err error) {
+	cfg, err := load()
+	if err == nil {
+		err = cfg.Validate()
+	}
+	s.reloads.record(err)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cfg.Port != s.Port {
+		restart = append(restart, "port")
+	}
+	if cfg.KeyDir != s.KeyDir {
+		restart = append(restart, "key_dir")
+	}
+	if cfg.Keystore != s.Keystore {
+		restart = append(restart, "keystore")
+	}
+
+	next := s.config().copyConfig()
+	next.Node = cfg.Node
+	next.Chains = cfg.Chains
+	next.Vault = cfg.Vault
+	next.HSM = cfg.HSM
+	next.MnemonicWords = cfg.MnemonicWords
+	next.LogLevel = cfg.LogLevel
+	next.LogFormat = cfg.LogFormat
+	if cfg.logger != nil {
+		next.logger = cfg.logger
+	}
+	s.current.Store(next)
+	return restart, nil
+}
+
+// copyConfig returns a server with the config, logger and keystore of s
+func (s *Server) copyConfig() *Server {
+	return &Server{
+		Port:          s.Port,
+		KeyDir:        s.KeyDir,
+		Node:          s.Node,
+		Chains:        s.Chains,
+		Keystore:      s.Keystore,
+		Vault:         s.Vault,
+		HSM:           s.HSM,
+		MnemonicWords: s.MnemonicWords,
+		LogLevel:      s.LogLevel,
+		LogFormat:     s.LogFormat,
+		Version:       s.Version,
+		Commit:        s.Commit,
+		Branch:        s.Branch,
+		logger:        s.logger,
+		keystore:      s.keystore,
+	}
+}
+
+// Metrics is the handler for GET /metrics, it reports config reloads in the
+// prometheus text format
+func (s *Server) Metrics(w http.ResponseWriter, r *http.Request) {
+	s.reloads.Lock()
+	succeeded, failed, lastFailed, lastSuccess := s.reloads.succeeded, s.reloads.failed, s.reloads.lastFailed, s.reloads.lastSuccess
+	s.reloads.Unlock()
+
+	successful, timestamp := 1, int64(0)
+	if lastFailed {
+		successful = 0
+	}
+	if !lastSuccess.IsZero() {
+		timestamp = lastSuccess.Unix()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `# HELP keyserver_config_reloads_total Config reloads by result.
+# TYPE keyserver_config_reloads_total counter
+keyserver_config_reloads_total{result="success"} %d
+keyserver_config_reloads_total{result="failure"} %d
+# HELP keyserver_config_last_reload_successful Whether the last config reload succeeded.
+# TYPE keyserver_config_last_reload_successful gauge
+keyserver_config_last_reload_successful %d
+# HELP keyserver_config_last_reload_success_timestamp_seconds Time of the last successful config reload, 0 if there was none.
+# TYPE keyserver_config_last_reload_success_timestamp_seconds gauge
+keyserver_config_last_reload_success_timestamp_seconds %d
+`, succeeded, failed, successful, timestamp)
+}
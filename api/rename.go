@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	ckeys "github.com/cosmos/cosmos-sdk/crypto/keys"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/keyerror"
+	"github.com/gorilla/mux"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// RenameKeyBody is the body for a rename request, Password is required for local keys
+type RenameKeyBody struct {
+	NewName  string `json:"new_name"`
+	Password string `json:"password,omitempty"`
+}
+
+// Marshal returns the json byte representation of the rename body
+func (rb RenameKeyBody) Marshal() []byte {
+	out, err := json.Marshal(rb)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// RenameKey is the handler for the POST /keys/{name}/rename, the key and its
+// metadata are moved to the new name and the old name is freed
+func (s *Server) RenameKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	name := vars["name"]
+	var m RenameKeyBody
+
+	kb, err := s.keybase()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	chain, err := s.chain(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if m.NewName == "" {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("must include new_name with request"))
+		return
+	}
+
+	// the metadata lock is held for the whole rename, so neither the key's
+	// metadata nor the new name can change before the metadata is moved
+	var (
+		meta   keyMeta
+		info   ckeys.Info
+		status = http.StatusInternalServerError
+	)
+	err = s.withMeta(func(db dbm.DB) (err error) {
+		if meta, err = readMeta(db, name); err != nil {
+			return err
+		}
+
+		info, err = kb.Get(name)
+		if keyerror.IsErrKeyNotFound(err) && meta.Address == nil {
+			status = http.StatusNotFound
+			return err
+		} else if err != nil && !keyerror.IsErrKeyNotFound(err) {
+			return err
+		}
+
+		newMeta, err := readMeta(db, m.NewName)
+		if err != nil {
+			return err
+		}
+		if _, err = kb.Get(m.NewName); err == nil || newMeta.Address != nil {
+			status = http.StatusConflict
+			return errKeyExists(m.NewName)
+		}
+
+		// watch-only addresses only exist in the metadata
+		var renameErr error
+		if info != nil {
+			if info, status, renameErr = renameInfo(kb, info, m); info == nil {
+				return renameErr
+			}
+		}
+		status = http.StatusInternalServerError
+		if err = moveMeta(db, name, m.NewName, meta); err != nil {
+			return err
+		}
+		return renameErr
+	})
+	if err != nil {
+		writeError(w, r, status, err)
+		return
+	}
+
+	var keyOutput ckeys.KeyOutput
+	if info == nil {
+		keyOutput = chain.addressOutput(m.NewName, meta.Address, "acc")
+	} else {
+		keyOutput, err = chain.keyOutput(info)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	out, err := json.Marshal(newKeyOutput(keyOutput, meta))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+	return
+}
+
+// renameInfo moves a keybase entry to the new name. The new entry is
+// written before the old one is deleted, so the key is always held under one
+// of its names. Both entries share the address index, which deleting the old
+// entry removes, so the new entry is written again afterwards. It returns the
+// status to respond with on error, and the renamed key's info along with the
+// error if only indexing it failed.
+func renameInfo(kb ckeys.Keybase, info ckeys.Info, m RenameKeyBody) (ckeys.Info, int, error) {
+	name := info.GetName()
+
+	// create adds the key under a name, rewrite stores it again under a name it already has
+	var create, rewrite func(name string) error
+	switch info.GetType() {
+	case ckeys.TypeLocal:
+		armor, err := kb.ExportPrivKey(name, m.Password, m.Password)
+		if keyerror.IsErrWrongPassword(err) {
+			return nil, http.StatusUnauthorized, err
+		} else if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		create = func(name string) error { return kb.ImportPrivKey(name, armor, m.Password) }
+		rewrite = func(name string) error {
+			return kb.Update(name, m.Password, func() (string, error) { return m.Password, nil })
+		}
+	case ckeys.TypeOffline:
+		create = func(name string) error {
+			_, err := kb.CreateOffline(name, info.GetPubKey())
+			return err
+		}
+		rewrite = create
+	case ckeys.TypeMulti:
+		create = func(name string) error {
+			_, err := kb.CreateMulti(name, info.GetPubKey())
+			return err
+		}
+		rewrite = create
+	default:
+		return nil, http.StatusBadRequest, fmt.Errorf("%s keys can't be renamed, the device is needed to add them again", info.GetType())
+	}
+
+	if err := create(m.NewName); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if err := kb.Delete(name, "", true); err != nil {
+		// the old entry still holds the key, drop the new one again
+		rerr := kb.Delete(m.NewName, "", true)
+		if rerr == nil {
+			rerr = rewrite(name)
+		}
+		if rerr != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("renaming %s failed: %s, removing %s failed: %s", name, err, m.NewName, rerr)
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+
+	renamed, err := kb.Get(m.NewName)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if err = rewrite(m.NewName); err != nil {
+		return renamed, http.StatusInternalServerError, fmt.Errorf("key %s was renamed to %s but its address couldn't be indexed: %s", name, m.NewName, err)
+	}
+	return renamed, 0, nil
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cosmos/cosmos-sdk/crypto/keys/keyerror"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/mintkey"
+	"github.com/tendermint/crypto/bcrypt"
+	"github.com/tendermint/tendermint/crypto"
+	"github.com/tendermint/tendermint/crypto/xsalsa20symmetric"
+)
+
+// sealedSecret is a secret encrypted with a key's password the same way the
+// keybase encrypts private keys, bcrypt for the key and xsalsa20 for the cipher
+type sealedSecret struct {
+	Salt       []byte `json:"salt"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// seed is what's needed to derive more keys from a key's mnemonic
+type seed struct {
+	Mnemonic        string `json:"mnemonic"`
+	BIP39Passphrase string `json:"bip39_passphrase,omitempty"`
+}
+
+// sealSeed encrypts the seed with password
+func sealSeed(sd seed, password string) (*sealedSecret, error) {
+	bz, err := json.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := crypto.CRandBytes(16)
+	key, err := sealingKey(salt, password)
+	if err != nil {
+		return nil, err
+	}
+	return &sealedSecret{Salt: salt, Ciphertext: xsalsa20symmetric.EncryptSymmetric(bz, key)}, nil
+}
+
+// open decrypts the seed, a wrong password returns the keybase's wrong password error
+func (ss *sealedSecret) open(password string) (sd seed, err error) {
+	key, err := sealingKey(ss.Salt, password)
+	if err != nil {
+		return
+	}
+
+	bz, err := xsalsa20symmetric.DecryptSymmetric(ss.Ciphertext, key)
+	if err != nil {
+		return sd, keyerror.NewErrWrongPassword()
+	}
+	err = json.Unmarshal(bz, &sd)
+	return
+}
+
+func sealingKey(salt []byte, password string) ([]byte, error) {
+	key, err := bcrypt.GenerateFromPassword(salt, []byte(password), mintkey.BcryptSecurityParameter)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Sha256(key), nil
+}
+
+// sealedSeeds are the secrets of a key sealed with its password
+type sealedSeeds struct {
+	seed, backup *sealedSecret
+}
+
+func (meta keyMeta) sealedSeeds() (ss sealedSeeds) {
+	ss.seed = meta.Seed
+	if meta.Backup != nil {
+		ss.backup = meta.Backup.Seed
+	}
+	return ss
+}
+
+// resealSeed encrypts the named key's retained mnemonics, if any, with its
+// new password, it is called before the keybase's password is changed and
+// restore puts back the mnemonics sealed with the old password if that
+// fails. Sealing is slow so it isn't done under the metadata lock, the
+// resealed mnemonics are only stored if they weren't changed meanwhile.
+func (s *Server) resealSeed(name, oldPassword, newPassword string) (restore func() error, err error) {
+	meta, err := s.getMeta(name)
+	if err != nil {
+		return nil, err
+	}
+	old := meta.sealedSeeds()
+	if old.seed == nil && old.backup == nil {
+		return func() error { return nil }, nil
+	}
+
+	var resealed sealedSeeds
+	if resealed.seed, err = old.seed.reseal(oldPassword, newPassword); err != nil {
+		return nil, err
+	}
+	if resealed.backup, err = old.backup.reseal(oldPassword, newPassword); err != nil {
+		return nil, err
+	}
+
+	swap := func(from, to sealedSeeds) error {
+		_, err := s.updateMeta(name, func(meta *keyMeta) error {
+			current := meta.sealedSeeds()
+			if !current.seed.equal(from.seed) || !current.backup.equal(from.backup) {
+				return fmt.Errorf("the retained mnemonic of key %s changed while its password was updated, try again", name)
+			}
+			meta.Seed = to.seed
+			if meta.Backup != nil {
+				meta.Backup.Seed = to.backup
+			}
+			return nil
+		})
+		return err
+	}
+	if err = swap(old, resealed); err != nil {
+		return nil, err
+	}
+	return func() error { return swap(resealed, old) }, nil
+}
+
+// equal reports whether both secrets are the same ciphertext, or both nil
+func (ss *sealedSecret) equal(other *sealedSecret) bool {
+	if ss == nil || other == nil {
+		return ss == other
+	}
+	return bytes.Equal(ss.Salt, other.Salt) && bytes.Equal(ss.Ciphertext, other.Ciphertext)
+}
+
+func (ss *sealedSecret) reseal(oldPassword, newPassword string) (*sealedSecret, error) {
+	if ss == nil {
+		return nil, nil
+	}
+	sd, err := ss.open(oldPassword)
+	if err != nil {
+		return nil, err
+	}
+	return sealSeed(sd, newPassword)
+}
@@ -14,13 +14,14 @@ import (
 
 // BankSendBody contains the necessary data to make a send transaction
 type BankSendBody struct {
-	Sender        sdk.AccAddress `json:"sender"`
-	Reciever      sdk.AccAddress `json:"reciever"`
-	Amount        string         `json:"amount"`
-	ChainID       string         `json:"chain-id"`
-	Memo          string         `json:"memo,omitempty"`
-	Fees          string         `json:"fees,omitempty"`
-	GasAdjustment string         `json:"gas_adjustment,omitempty"`
+	Sender        string `json:"sender"`
+	Reciever      string `json:"reciever"`
+	Amount        string `json:"amount"`
+	ChainID       string `json:"chain-id"`
+	Memo          string `json:"memo,omitempty"`
+	Fees          string `json:"fees,omitempty"`
+	GasAdjustment string `json:"gas_adjustment,omitempty"`
+	GasPrices     string `json:"gas_prices,omitempty"`
 }
 
 func (sb BankSendBody) Marshal() []byte {
@@ -35,24 +36,44 @@ func (sb BankSendBody) Marshal() []byte {
 func (s *Server) BankSend(w http.ResponseWriter, r *http.Request) {
 	var sb BankSendBody
 
+	chain, err := s.chain(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	cdc := chain.cdc()
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	err = cdc.UnmarshalJSON(body, &sb)
+	err = json.Unmarshal(body, &sb)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	// the addresses are bech32 with the chain's prefix
+	var from, to sdk.AccAddress
+	err = chain.encode(func() (err error) {
+		if from, err = sdk.AccAddressFromBech32(sb.Sender); err != nil {
+			return fmt.Errorf("invalid sender %s: %s", sb.Sender, err)
+		}
+		if to, err = sdk.AccAddressFromBech32(sb.Reciever); err != nil {
+			return fmt.Errorf("invalid reciever %s: %s", sb.Reciever, err)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
 	coins, err := sdk.ParseCoins(sb.Amount)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(fmt.Errorf("failed to parse amount %s into sdk.Coins", sb.Amount)).marshal())
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("failed to parse amount %s into sdk.Coins", sb.Amount))
 		return
 	}
 
@@ -60,45 +81,61 @@ func (s *Server) BankSend(w http.ResponseWriter, r *http.Request) {
 	if sb.Fees != "" {
 		fees, err = sdk.ParseCoins(sb.Fees)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write(newError(fmt.Errorf("failed to parse fees %s into sdk.Coins", sb.Fees)).marshal())
+			writeError(w, r, http.StatusBadRequest, fmt.Errorf("failed to parse fees %s into sdk.Coins", sb.Fees))
 			return
 		}
 	}
 
 	stdTx := auth.NewStdTx(
-		[]sdk.Msg{bank.MsgSend{sb.Sender, sb.Reciever, coins}},
+		[]sdk.Msg{bank.MsgSend{FromAddress: from, ToAddress: to, Amount: coins}},
 		auth.NewStdFee(20000, fees),
 		[]auth.StdSignature{{}},
 		sb.Memo,
 	)
 
-	gas, err := s.SimulateGas(cdc.MustMarshalBinaryLengthPrefixed(stdTx))
+	gas, err := chain.SimulateGas(cdc.MustMarshalBinaryLengthPrefixed(stdTx))
 
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
 	if gas != 0 && sb.GasAdjustment != "" {
 		adj, err := strconv.ParseFloat(sb.GasAdjustment, 64)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write(newError(fmt.Errorf("failed to parse gasAdjustment %s into float64", sb.GasAdjustment)).marshal())
+			writeError(w, r, http.StatusBadRequest, fmt.Errorf("failed to parse gasAdjustment %s into float64", sb.GasAdjustment))
 			return
 		}
 		gas = uint64(adj * float64(gas))
 	}
 
+	// without explicit fees, pay for the simulated gas at the requested or chain's default gas prices
+	gasPrices := sb.GasPrices
+	if gasPrices == "" {
+		gasPrices = chain.GasPrices
+	}
+	if sb.Fees == "" && gasPrices != "" {
+		fees, err = Fees(gasPrices, gas)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Errorf("failed to parse gas prices %s into sdk.DecCoins", gasPrices))
+			return
+		}
+	}
+
 	stdTx = auth.NewStdTx(
 		stdTx.Msgs,
-		auth.NewStdFee(gas, stdTx.Fee.Amount),
+		auth.NewStdFee(gas, fees),
 		[]auth.StdSignature{},
 		stdTx.Memo,
 	)
 
+	var out []byte
+	chain.encode(func() error {
+		out = cdc.MustMarshalJSON(stdTx)
+		return nil
+	})
+
 	w.WriteHeader(http.StatusOK)
-	w.Write(cdc.MustMarshalJSON(stdTx))
+	w.Write(out)
 	return
 }
@@ -2,13 +2,16 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strconv"
 
-	"github.com/cosmos/cosmos-sdk/client/keys"
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/keyerror"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	"github.com/cosmos/cosmos-sdk/x/auth"
+	"github.com/tendermint/tendermint/crypto"
 )
 
 // SignBody is the body for a sign request
@@ -30,8 +33,8 @@ func (sb SignBody) Marshal() []byte {
 	return out
 }
 
-// StdSignMsg returns a StdSignMsg from a SignBody request
-func (sb SignBody) StdSignMsg() (stdSign auth.StdSignMsg, stdTx auth.StdTx, err error) {
+// StdSignMsg returns a StdSignMsg from a SignBody request, decoding the tx with cdc
+func (sb SignBody) StdSignMsg(cdc *codec.Codec) (stdSign auth.StdSignMsg, stdTx auth.StdTx, err error) {
 	err = cdc.UnmarshalJSON(sb.Tx, &stdTx)
 	if err != nil {
 		return
@@ -61,55 +64,121 @@ func (sb SignBody) StdSignMsg() (stdSign auth.StdSignMsg, stdTx auth.StdTx, err
 	return
 }
 
-// Sign handles the /tx/sign route
+// Sign handles the /tx/sign route, keys held by a signing backend are signed with by the backend
 func (s *Server) Sign(w http.ResponseWriter, r *http.Request) {
 	var m SignBody
 
-	kb, err := keys.NewKeyBaseFromDir(s.KeyDir)
+	kb, err := s.keybase()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
+	chain, err := s.chain(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	cdc := chain.cdc()
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
 	err = cdc.UnmarshalJSON(body, &m)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	stdSign, stdTx, err := m.StdSignMsg()
+	if m.ChainID == "" {
+		m.ChainID = chain.ChainID
+	}
+	if m.ChainID == "" {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("must include a chain_id with request"))
+		return
+	}
+
+	// the messages are decoded and their sign bytes encoded with the chain's
+	// prefixes, the lease isn't held while signing
+	var stdTx auth.StdTx
+	var signBytes []byte
+	err = chain.encode(func() error {
+		stdSign, tx, err := m.StdSignMsg(cdc)
+		if err != nil {
+			return err
+		}
+		stdTx, signBytes = tx, sdk.MustSortJSON(cdc.MustMarshalJSON(stdSign))
+		return nil
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	sigBytes, pubkey, err := kb.Sign(m.Name, m.Passphrase, sdk.MustSortJSON(cdc.MustMarshalJSON(stdSign)))
+	// watch-only addresses are only in the metadata, so check it first
+	meta, err := s.getMeta(m.Name)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if meta.Address != nil {
+		writeError(w, r, http.StatusBadRequest, errWatchOnly(m.Name, keyTypeAddress))
 		return
 	}
 
+	info, err := kb.Get(m.Name)
+	if keyerror.IsErrKeyNotFound(err) {
+		writeError(w, r, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	var sigBytes []byte
+	var pubkey crypto.PubKey
+	if meta.Signer != nil {
+		// the backend signs for any caller, so the key's password is checked first
+		if err = meta.Signer.checkPassword(m.Name, m.Passphrase); err != nil {
+			writeError(w, r, http.StatusUnauthorized, err)
+			return
+		}
+
+		sigBytes, pubkey, err = s.signRemote(info, meta.Signer, signBytes)
+		if err != nil {
+			writeError(w, r, http.StatusBadGateway, withCode(CodeSignerUnavailable, err))
+			return
+		}
+	} else {
+		// offline and multisig keys would make the keybase prompt on stdin for a signature
+		if err = canSign(info); err != nil {
+			writeError(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		sigBytes, pubkey, err = kb.Sign(m.Name, m.Passphrase, signBytes)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
 	sigs := append(stdTx.GetSignatures(), auth.StdSignature{
 		PubKey:    pubkey,
 		Signature: sigBytes,
 	})
 
 	signedStdTx := auth.NewStdTx(stdTx.GetMsgs(), stdTx.Fee, sigs, stdTx.GetMemo())
-	out, err := cdc.MarshalJSON(signedStdTx)
+	var out []byte
+	err = chain.encode(func() (err error) {
+		out, err = cdc.MarshalJSON(signedStdTx)
+		return err
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(newError(err).marshal())
+		writeError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	ckeys "github.com/cosmos/cosmos-sdk/crypto/keys"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/keyerror"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/mintkey"
+	"github.com/tendermint/crypto/bcrypt"
+	"github.com/tendermint/tendermint/crypto"
+	cryptoAmino "github.com/tendermint/tendermint/crypto/encoding/amino"
+)
+
+// Signer signs with private keys held outside the keybase. Keys it holds are
+// registered in the keybase as offline keys, with the backend and the key's
+// name in the backend recorded in their metadata.
+type Signer interface {
+	// PubKey returns the public key of the backend's key
+	PubKey(keyName string) (crypto.PubKey, error)
+
+	// Sign signs msg the way the keybase does, returning the signature
+	Sign(keyName string, msg []byte) ([]byte, error)
+}
+
+// remoteKey records where the private key of a key held by a Signer is.
+// Backends sign for whoever holds the keyserver's credentials, so signing
+// also requires the password the key was registered with, which only its
+// bcrypt hash is kept of. PubKey caches the backend's public key.
+type remoteKey struct {
+	Backend      string `json:"backend"`
+	KeyName      string `json:"key_name"`
+	PubKey       []byte `json:"pub_key"`
+	PasswordHash []byte `json:"password_hash"`
+}
+
+// newRemoteKey returns the record of a backend's key protected by password
+func newRemoteKey(backend, keyName string, pub crypto.PubKey, password string) (*remoteKey, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteKey{Backend: backend, KeyName: keyName, PubKey: pub.Bytes(), PasswordHash: hash}, nil
+}
+
+func hashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword(crypto.CRandBytes(16), []byte(password), mintkey.BcryptSecurityParameter)
+}
+
+// checkPassword returns the keybase's wrong password error unless password
+// is the one the key was registered with
+func (key *remoteKey) checkPassword(name, password string) error {
+	if len(key.PasswordHash) == 0 {
+		return fmt.Errorf("key %s was registered without a password, delete it and register it again with one", name)
+	}
+	if bcrypt.CompareHashAndPassword(key.PasswordHash, []byte(password)) != nil {
+		return keyerror.NewErrWrongPassword()
+	}
+	return nil
+}
+
+// updateRemotePassword replaces the password of the remote key name, the
+// keybase can't update the offline info it is registered as
+func (s *Server) updateRemotePassword(name string, key *remoteKey, oldPassword, newPassword string) error {
+	if err := key.checkPassword(name, oldPassword); err != nil {
+		return err
+	}
+	if newPassword == "" {
+		return withCode(CodeInvalidRequest, fmt.Errorf("remote key %s requires a password", name), "name", name)
+	}
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	_, err = s.updateMeta(name, func(meta *keyMeta) error {
+		if meta.Signer == nil || !bytes.Equal(meta.Signer.PasswordHash, key.PasswordHash) {
+			return fmt.Errorf("the password of key %s changed while it was updated, try again", name)
+		}
+		meta.Signer.PasswordHash = hash
+		return nil
+	})
+	return err
+}
+
+// signer returns the signing backend with the given name
+func (s *Server) signer(backend string) (Signer, error) {
+	switch backend {
+	case SignerVault:
+		return NewVaultSigner(s.Vault)
+	case SignerHSM:
+		return NewHSMSigner(s.HSM)
+	}
+	return nil, fmt.Errorf("unknown signer backend %s", backend)
+}
+
+// RemoteKeyBody registers a key held by a signing backend under Name,
+// KeyName is the key's name in the backend and defaults to Name. Password is
+// required to sign with the key.
+type RemoteKeyBody struct {
+	Name     string `json:"name"`
+	Backend  string `json:"backend"`
+	KeyName  string `json:"key_name,omitempty"`
+	Password string `json:"password"`
+}
+
+// Marshal returns the json byte representation of the remote key body
+func (rb RemoteKeyBody) Marshal() []byte {
+	out, err := json.Marshal(rb)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// PostRemoteKey is the handler for the POST /keys/remote
+func (s *Server) PostRemoteKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var m RemoteKeyBody
+
+	kb, err := s.keybase()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	chain, err := s.chain(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if m.Name == "" || m.Backend == "" || m.Password == "" {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("must include name, backend and password with request"))
+		return
+	}
+	if m.KeyName == "" {
+		m.KeyName = m.Name
+	}
+
+	signer, err := s.signer(m.Backend)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if m.Backend == SignerVault {
+		if err = checkVaultKeyName(m.KeyName); err != nil {
+			writeError(w, r, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	exists, err := s.keyExists(kb, m.Name)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	} else if exists {
+		writeError(w, r, http.StatusConflict, errKeyExists(m.Name))
+		return
+	}
+
+	pub, err := signer.PubKey(m.KeyName)
+	if err != nil {
+		writeError(w, r, http.StatusBadGateway, withCode(CodeSignerUnavailable, fmt.Errorf("fetching %s key %s: %s", m.Backend, m.KeyName, err)))
+		return
+	}
+
+	key, err := newRemoteKey(m.Backend, m.KeyName, pub, m.Password)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	info, err := kb.CreateOffline(m.Name, pub)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	meta := keyMeta{Signer: key}
+	if err = s.setMeta(m.Name, meta); err != nil {
+		kb.Delete(m.Name, "", true)
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	keyOutput, err := chain.keyOutput(info)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	out, err := json.Marshal(newKeyOutput(keyOutput, meta))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+	return
+}
+
+// signRemote signs msg with the backend holding the key, checking the
+// signature against the public key registered in the keybase. The caller
+// checks the key's password.
+func (s *Server) signRemote(info ckeys.Info, key *remoteKey, msg []byte) ([]byte, crypto.PubKey, error) {
+	pub, err := cryptoAmino.PubKeyFromBytes(key.PubKey)
+	if err != nil || !pub.Equals(info.GetPubKey()) {
+		return nil, nil, fmt.Errorf("the %s public key cached for %s doesn't match its registered public key", key.Backend, info.GetName())
+	}
+
+	signer, err := s.signer(key.Backend)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sig, err := signer.Sign(key.KeyName, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !pub.VerifyBytes(msg, sig) {
+		return nil, nil, fmt.Errorf("%s key %s didn't sign with the public key registered for %s", key.Backend, key.KeyName, info.GetName())
+	}
+	return sig, pub, nil
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/tendermint/tendermint/crypto"
+	"github.com/tendermint/tendermint/crypto/secp256k1"
+)
+
+// SignerVault is the backend name of keys held by a Vault transit compatible engine
+const SignerVault = "vault"
+
+// vaultTimeout bounds each request to Vault
+const vaultTimeout = 10 * time.Second
+
+// vaultKeyName matches the names Vault accepts for transit keys, key names
+// are put in the request path so anything else could reach other endpoints
+var vaultKeyName = regexp.MustCompile(`^\w([\w.-]*\w)?$`)
+
+// checkVaultKeyName returns an error unless keyName is a valid transit key name
+func checkVaultKeyName(keyName string) error {
+	if !vaultKeyName.MatchString(keyName) {
+		return withCode(CodeInvalidRequest, fmt.Errorf("invalid vault key name %q, only letters, digits, _, - and . are allowed", keyName), "key_name", keyName)
+	}
+	return nil
+}
+
+// VaultConfig configures the Vault transit signer, Address and Token default
+// to the VAULT_ADDR and VAULT_TOKEN environment variables and Mount to transit
+type VaultConfig struct {
+	Address string `json:"address,omitempty"`
+	Token   string `json:"token,omitempty"`
+	Mount   string `json:"mount,omitempty"`
+}
+
+// vaultSigner signs with ecdsa-secp256k1 keys through a Vault transit compatible API
+type vaultSigner struct {
+	address string
+	token   string
+	mount   string
+	client  *http.Client
+}
+
+// NewVaultSigner returns a signer for the Vault transit engine described by vc
+func NewVaultSigner(vc VaultConfig) (Signer, error) {
+	vs := &vaultSigner{
+		address: vc.Address,
+		token:   vc.Token,
+		mount:   vc.Mount,
+		client:  &http.Client{Timeout: vaultTimeout},
+	}
+	if vs.address == "" {
+		vs.address = os.Getenv("VAULT_ADDR")
+	}
+	if vs.token == "" {
+		vs.token = os.Getenv("VAULT_TOKEN")
+	}
+	if vs.mount == "" {
+		vs.mount = "transit"
+	}
+	if vs.address == "" {
+		return nil, errors.New("vault address isn't configured")
+	}
+	vs.address = strings.TrimSuffix(vs.address, "/")
+	return vs, nil
+}
+
+// PubKey reads the latest version of the transit key
+func (vs *vaultSigner) PubKey(keyName string) (crypto.PubKey, error) {
+	var res struct {
+		Data struct {
+			Type          string `json:"type"`
+			LatestVersion int    `json:"latest_version"`
+			Keys          map[string]struct {
+				PublicKey string `json:"public_key"`
+			} `json:"keys"`
+		} `json:"data"`
+	}
+	if err := checkVaultKeyName(keyName); err != nil {
+		return nil, err
+	}
+	if err := vs.do(http.MethodGet, "keys/"+keyName, nil, &res); err != nil {
+		return nil, err
+	}
+
+	if res.Data.Type != "ecdsa-secp256k1" {
+		return nil, fmt.Errorf("key %s is a %s key, only ecdsa-secp256k1 keys can sign transactions", keyName, res.Data.Type)
+	}
+	key, ok := res.Data.Keys[strconv.Itoa(res.Data.LatestVersion)]
+	if !ok {
+		return nil, fmt.Errorf("key %s has no version %d", keyName, res.Data.LatestVersion)
+	}
+	return parseSecp256k1PEM(key.PublicKey)
+}
+
+// Sign has Vault sign the sha256 hash of msg like the keybase does and
+// returns the signature in the low S, R || S form tendermint expects
+func (vs *vaultSigner) Sign(keyName string, msg []byte) ([]byte, error) {
+	if err := checkVaultKeyName(keyName); err != nil {
+		return nil, err
+	}
+	req := map[string]interface{}{
+		"input":          base64.StdEncoding.EncodeToString(crypto.Sha256(msg)),
+		"prehashed":      true,
+		"hash_algorithm": "sha2-256",
+	}
+	var res struct {
+		Data struct {
+			Signature string `json:"signature"`
+		} `json:"data"`
+	}
+	if err := vs.do(http.MethodPost, "sign/"+keyName, req, &res); err != nil {
+		return nil, err
+	}
+
+	// signatures look like vault:v1:base64-der
+	parts := strings.Split(res.Data.Signature, ":")
+	der, err := base64.StdEncoding.DecodeString(parts[len(parts)-1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature from vault: %s", err)
+	}
+	return compactSignature(der)
+}
+
+func (vs *vaultSigner) do(method, path string, body, out interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/%s", vs.address, vs.mount, path)
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", vs.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := vs.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var verr struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&verr)
+		return fmt.Errorf("vault %s %s returned %d: %s", method, path, resp.StatusCode, strings.Join(verr.Errors, ", "))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// parseSecp256k1PEM parses a PEM encoded PKIX secp256k1 public key, which
+// crypto/x509 doesn't support, into a compressed tendermint public key
+func parseSecp256k1PEM(in string) (crypto.PubKey, error) {
+	block, _ := pem.Decode([]byte(in))
+	if block == nil {
+		return nil, errors.New("public key isn't PEM encoded")
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(block.Bytes, &spki); err != nil {
+		return nil, fmt.Errorf("invalid public key: %s", err)
+	}
+
+	key, err := btcec.ParsePubKey(spki.PublicKey.Bytes, btcec.S256())
+	if err != nil {
+		return nil, fmt.Errorf("invalid secp256k1 public key: %s", err)
+	}
+
+	var pub secp256k1.PubKeySecp256k1
+	copy(pub[:], key.SerializeCompressed())
+	return pub, nil
+}
+
+// compactSignature converts an ASN.1 DER ecdsa signature to R || S with S in
+// the lower half of the curve order, the only form tendermint accepts
+func compactSignature(der []byte) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("invalid signature: %s", err)
+	}
+	return compactRS(sig.R, sig.S)
+}
+
+// compactRS returns R || S with S in the lower half of the curve order
+func compactRS(r, s *big.Int) ([]byte, error) {
+	n := btcec.S256().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s = new(big.Int).Sub(n, s)
+	}
+
+	out := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	if len(rb) > 32 || len(sb) > 32 {
+		return nil, errors.New("invalid signature: R or S is too large")
+	}
+	copy(out[32-len(rb):32], rb)
+	copy(out[64-len(sb):], sb)
+	return out, nil
+}
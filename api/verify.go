@@ -0,0 +1,194 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	ckeys "github.com/cosmos/cosmos-sdk/crypto/keys"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+)
+
+// VerifyBody is the body for a verify request, Accounts has the account
+// number and sequence of every signer of the transaction in order
+type VerifyBody struct {
+	Tx       json.RawMessage `json:"tx"`
+	ChainID  string          `json:"chain_id"`
+	Accounts []VerifyAccount `json:"accounts"`
+}
+
+// VerifyAccount is the account number and sequence a signer signed with
+type VerifyAccount struct {
+	AccountNumber string `json:"account_number"`
+	Sequence      string `json:"sequence"`
+}
+
+// Marshal returns the json byte representation of the verify body
+func (vb VerifyBody) Marshal() []byte {
+	out, err := json.Marshal(vb)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// VerifyResult reports whether every signer of a transaction signed it
+type VerifyResult struct {
+	Valid   bool           `json:"valid"`
+	Signers []SignerResult `json:"signers"`
+}
+
+// SignerResult reports the signature of one signer, with the name and type
+// of the key registered for its address, including watch-only keys
+type SignerResult struct {
+	Address string `json:"address"`
+	Name    string `json:"name,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Valid   bool   `json:"valid"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Verify handles the /tx/verify route
+func (s *Server) Verify(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var m VerifyBody
+
+	kb, err := s.keybase()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	chain, err := s.chain(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	cdc := chain.cdc()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = json.Unmarshal(body, &m)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if m.ChainID == "" {
+		m.ChainID = chain.ChainID
+	}
+	if m.ChainID == "" {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("must include a chain_id with request"))
+		return
+	}
+
+	// the messages are decoded and their sign bytes encoded with the chain's prefixes
+	var signers []SignerResult
+	var addrs []sdk.AccAddress
+	err = chain.encode(func() error {
+		var stdTx auth.StdTx
+		if err := cdc.UnmarshalJSON(m.Tx, &stdTx); err != nil {
+			return err
+		}
+		addrs = stdTx.GetSigners()
+		if len(m.Accounts) != len(addrs) {
+			return fmt.Errorf("the transaction has %d signers but %d accounts were given", len(addrs), len(m.Accounts))
+		}
+
+		sigs := stdTx.GetSignatures()
+		for i, addr := range addrs {
+			signer := SignerResult{Address: addr.String()}
+			acc, err := strconv.ParseUint(m.Accounts[i].AccountNumber, 10, 64)
+			if err != nil {
+				return err
+			}
+			seq, err := strconv.ParseUint(m.Accounts[i].Sequence, 10, 64)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case i >= len(sigs):
+				signer.Error = "missing signature"
+			case sigs[i].PubKey == nil:
+				signer.Error = "the signature has no public key"
+			case !bytes.Equal(sigs[i].PubKey.Address(), addr):
+				signer.Error = fmt.Sprintf("signed by %s instead", sdk.AccAddress(sigs[i].PubKey.Address()))
+			case !sigs[i].PubKey.VerifyBytes(auth.StdSignBytes(m.ChainID, acc, seq, stdTx.Fee, stdTx.Msgs, stdTx.Memo), sigs[i].Signature):
+				signer.Error = "invalid signature"
+			default:
+				signer.Valid = true
+			}
+			signers = append(signers, signer)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	res := VerifyResult{Valid: len(signers) > 0, Signers: signers}
+	for i, addr := range addrs {
+		res.Valid = res.Valid && signers[i].Valid
+		signers[i].Name, signers[i].Type, err = s.keyByAddress(kb, addr)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	out, err := json.Marshal(res)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+	return
+}
+
+// keyByAddress returns the name and type of the key registered for addr,
+// or empty strings if there is none
+func (s *Server) keyByAddress(kb ckeys.Keybase, addr sdk.AccAddress) (name, keyType string, err error) {
+	// the keybase's address index loses an address when another key with the
+	// same address is deleted, so the keys are searched
+	infos, err := kb.List()
+	if err != nil {
+		return "", "", err
+	}
+	for _, info := range infos {
+		if !bytes.Equal(info.GetAddress(), addr) {
+			continue
+		}
+		meta, err := s.getMeta(info.GetName())
+		if err != nil {
+			return "", "", err
+		}
+		if meta.Signer != nil {
+			return info.GetName(), meta.Signer.Backend, nil
+		}
+		return info.GetName(), info.GetType().String(), nil
+	}
+
+	// watch-only addresses are only in the metadata
+	metas, err := s.listMeta()
+	if err != nil {
+		return "", "", err
+	}
+	for n, meta := range metas {
+		if bytes.Equal(meta.Address, addr) && (name == "" || n < name) {
+			name, keyType = n, keyTypeAddress
+		}
+	}
+	return name, keyType, nil
+}
@@ -11,17 +11,18 @@ func (s *Server) VersionHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(s.newVersion().marshal())
 }
 
-type version struct {
+// VersionInfo is the body of /version
+type VersionInfo struct {
 	Version string `json:"version"`
 	Commit  string `json:"commit"`
 	Branch  string `json:"branch"`
 }
 
-func (s *Server) newVersion() version {
-	return version{s.Version, s.Commit, s.Branch}
+func (s *Server) newVersion() VersionInfo {
+	return VersionInfo{s.Version, s.Commit, s.Branch}
 }
 
-func (v version) marshal() []byte {
+func (v VersionInfo) marshal() []byte {
 	out, err := json.Marshal(v)
 	if err != nil {
 		panic(err)
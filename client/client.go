@@ -0,0 +1,286 @@
+// Package client is a typed Go client for the keyserver API
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/cosmos/gaia/app"
+)
+
+// defaultTimeout bounds each request, storing large batches of derived keys
+// takes a while since every key's private key is encrypted with bcrypt
+const defaultTimeout = 2 * time.Minute
+
+// Client calls the keyserver API at a base URL
+type Client struct {
+	base   *url.URL
+	http   *http.Client
+	header http.Header
+	chain  string
+	cdc    *codec.Codec
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient makes requests with a copy of hc, it should come before the
+// other options, which change the copy rather than hc
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		copied := *hc
+		c.http = &copied
+	}
+}
+
+// WithTimeout bounds each request, two minutes by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.http.Timeout = timeout }
+}
+
+// WithTLSConfig makes requests with the given TLS configuration, e.g. to trust a private CA
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.http.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: cfg}
+	}
+}
+
+// WithBearerToken authenticates requests with the token, for keyservers behind an authenticating proxy
+func WithBearerToken(token string) Option {
+	return func(c *Client) { c.header.Set("Authorization", "Bearer "+token) }
+}
+
+// WithBasicAuth authenticates requests with the username and password
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		req := http.Request{Header: http.Header{}}
+		req.SetBasicAuth(username, password)
+		c.header.Set("Authorization", req.Header.Get("Authorization"))
+	}
+}
+
+// WithHeader sets a header on every request
+func WithHeader(key, value string) Option {
+	return func(c *Client) { c.header.Set(key, value) }
+}
+
+// WithChain selects the keyserver's chain profile with the given name for every request
+func WithChain(name string) Option {
+	return func(c *Client) { c.chain = name }
+}
+
+// WithCodec decodes broadcast results with cdc instead of the gaia codec
+func WithCodec(cdc *codec.Codec) Option {
+	return func(c *Client) { c.cdc = cdc }
+}
+
+// WithHandler serves requests with h in-process instead of sending them over
+// the network, e.g. with the Router of an api.Server that has its keystore open
+func WithHandler(h http.Handler) Option {
+	return func(c *Client) { c.http.Transport = handlerTransport{h} }
+}
+
+// handlerTransport is a RoundTripper that records the response of a handler
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := &responseRecorder{header: http.Header{}}
+	t.handler.ServeHTTP(rec, req)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status)),
+		StatusCode:    rec.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.header,
+		Body:          ioutil.NopCloser(&rec.body),
+		ContentLength: int64(rec.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+// responseRecorder is the http.ResponseWriter a handlerTransport records with
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.WriteHeader(http.StatusOK)
+	return rr.body.Write(b)
+}
+
+// New returns a client for the keyserver at baseURL, e.g. http://localhost:3000
+func New(baseURL string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyserver url %s: %s", baseURL, err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" || base.Host == "" {
+		return nil, fmt.Errorf("invalid keyserver url %s, must be http:// or https://", baseURL)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	c := &Client{
+		base:   base,
+		http:   &http.Client{Timeout: defaultTimeout},
+		header: http.Header{},
+		cdc:    app.MakeCodec(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Codec returns the codec broadcast results are decoded with
+func (c *Client) Codec() *codec.Codec {
+	return c.cdc
+}
+
+// Error is an error response from the keyserver. Code is one of the api
+// package's error codes, and Codespace and ABCICode are set for errors
+// returned by the chain.
+type Error struct {
+	StatusCode int                    `json:"-"`
+	Message    string                 `json:"error"`
+	Code       string                 `json:"code,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	Codespace  string                 `json:"codespace,omitempty"`
+	ABCICode   uint32                 `json:"abci_code,omitempty"`
+	RequestID  string                 `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.RequestID == "" {
+		return fmt.Sprintf("keyserver returned %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("keyserver returned %d: %s (request %s)", e.StatusCode, e.Message, e.RequestID)
+}
+
+// ErrorCode returns the error code of an error response, or an empty string
+// for other errors
+func ErrorCode(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return ""
+}
+
+// IsNotFound returns whether err is a 404 response
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// IsUnauthorized returns whether err is a 401 response, e.g. for a wrong password
+func IsUnauthorized(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusUnauthorized
+}
+
+// do sends body, encoded as json unless it is already a []byte, to path and
+// returns the response body and headers. Responses with an error status
+// return an *Error along with the body.
+func (c *Client) do(method, path string, query url.Values, body interface{}) ([]byte, http.Header, error) {
+	var reqBody []byte
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reqBody = b
+	default:
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.chain != "" {
+		query.Set("chain", c.chain)
+	}
+	u := *c.base
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading response from %s %s: %s", method, path, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &Error{StatusCode: resp.StatusCode}
+		if json.Unmarshal(out, e) != nil || e.Message == "" {
+			e.Message = strings.TrimSpace(string(out))
+			if e.Message == "" {
+				e.Message = http.StatusText(resp.StatusCode)
+			}
+		}
+		return out, resp.Header, e
+	}
+	return out, resp.Header, nil
+}
+
+// call sends body to path and decodes the json response into out
+func (c *Client) call(method, path string, query url.Values, body, out interface{}) error {
+	bz, _, err := c.do(method, path, query, body)
+	if err != nil || out == nil {
+		return err
+	}
+	return decode(bz, out)
+}
+
+func decode(bz []byte, out interface{}) error {
+	if err := json.Unmarshal(bz, out); err != nil {
+		return fmt.Errorf("invalid response: %s", err)
+	}
+	return nil
+}
+
+// keyPath returns the path of the key route for name
+func keyPath(name string, elems ...string) string {
+	return "/keys/" + strings.Join(append([]string{url.PathEscape(name)}, elems...), "/")
+}
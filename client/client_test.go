@@ -0,0 +1,318 @@
+package client
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+	"github.com/cosmos/gaia/app"
+	"github.com/jackzampolin/keyserver/api"
+	"github.com/stretchr/testify/require"
+	amino "github.com/tendermint/go-amino"
+	abci "github.com/tendermint/tendermint/abci/types"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	rpctypes "github.com/tendermint/tendermint/rpc/lib/types"
+)
+
+const (
+	testPass  = "123456789"
+	sMnemonic = "marine intact tone element chest certain school village sound guilt nothing deposit cart skirt unveil bulk unit dust peasant cannon faith lyrics swear regret"
+	sAcc      = "cosmos1yv6alpum5r0nmnzkk4esp3cs5d58h8g95mvs50"
+	sAccPub   = "cosmospub1addwnpepqwf7vcxxzylpk4lgghqeyqdv4dwhh0htk5ukskqr7p383t8udzdkgggdxy3"
+)
+
+func setup(t *testing.T) (*httptest.Server, *Client) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	s := &api.Server{KeyDir: dir, Node: "tcp://127.0.0.1:1", Version: "v1.0.0"}
+	s.SetKeystore(api.NewMemoryKeystore())
+	server := httptest.NewServer(s.Router())
+
+	c, err := New(server.URL + "/")
+	require.NoError(t, err)
+	return server, c
+}
+
+func TestClient(t *testing.T) {
+	server, c := setup(t)
+	defer server.Close()
+
+	// test the status routes
+	v, err := c.Version()
+	require.NoError(t, err)
+	require.Equal(t, "v1.0.0", v.Version)
+	require.NoError(t, c.Health())
+	rd, err := c.Ready()
+	require.Error(t, err)
+	require.False(t, rd.Ready)
+	require.True(t, rd.Keybase.OK)
+
+	// test creating and listing keys
+	for _, name := range []string{"a", "b", "c"} {
+		key, err := c.CreateKey(api.AddNewKey{Name: name, Password: testPass, Labels: []string{"test"}})
+		require.NoError(t, err)
+		require.Equal(t, name, key.Name)
+		require.NotEmpty(t, key.Mnemonic)
+	}
+	keys, next, err := c.Keys(KeysFilter{Labels: []string{"test"}, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, "b", next)
+	keys, next, err = c.Keys(KeysFilter{Cursor: next})
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Empty(t, next)
+
+	// test editing keys
+	key, err := c.PatchKey("a", api.PatchKeyBody{Labels: &[]string{"edited"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"edited"}, key.Labels)
+	require.NoError(t, c.UpdatePassword("a", testPass, "foobarbaz"))
+	_, err = c.ExportKey("a", api.ExportKeyBody{Password: "foobarbaz"})
+	require.NoError(t, err)
+	key, err = c.RenameKey("a", api.RenameKeyBody{NewName: "renamed", Password: "foobarbaz"})
+	require.NoError(t, err)
+	require.Equal(t, "renamed", key.Name)
+
+	// test errors carry the status, code and message
+	_, err = c.Key("a", "")
+	require.True(t, IsNotFound(err))
+	require.Equal(t, api.CodeKeyNotFound, ErrorCode(err))
+	require.NotEmpty(t, err.(*Error).RequestID)
+	err = c.DeleteKey("renamed", testPass)
+	require.True(t, IsUnauthorized(err))
+	require.Equal(t, api.CodeWrongPassword, ErrorCode(err))
+	require.NoError(t, c.DeleteKey("renamed", "foobarbaz"))
+
+	// test signing a transaction
+	tx, err := c.Sign(api.SignBody{
+		Tx:            []byte(`{"type":"cosmos-sdk/StdTx","value":{"msg":[],"fee":{"amount":[],"gas":"0"},"signatures":null,"memo":"client"}}`),
+		Name:          "b",
+		Passphrase:    testPass,
+		ChainID:       "testing",
+		AccountNumber: "1",
+		Sequence:      "0",
+	})
+	require.NoError(t, err)
+	signed := decodeTx(t, tx)
+	require.Len(t, signed.Signatures, 1)
+	require.Equal(t, "client", signed.Memo)
+}
+
+func TestClientHandler(t *testing.T) {
+	s := &api.Server{Version: "v1.0.0"}
+	s.SetKeystore(api.NewMemoryKeystore())
+	c, err := New("http://localhost", WithHandler(s.Router()))
+	require.NoError(t, err)
+
+	v, err := c.Version()
+	require.NoError(t, err)
+	require.Equal(t, "v1.0.0", v.Version)
+	_, err = c.CreateKey(api.AddNewKey{Name: "a", Password: testPass})
+	require.NoError(t, err)
+	_, err = c.Key("b", "")
+	require.True(t, IsNotFound(err))
+}
+
+func TestClientOptions(t *testing.T) {
+	_, err := New("localhost:3000")
+	require.Error(t, err)
+
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		require.Equal(t, "cosmoshub", r.URL.Query().Get("chain"))
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("upstream unavailable"))
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL, WithBearerToken("secret"), WithChain("cosmoshub"), WithHeader("X-Request-ID", "req-1"))
+	require.NoError(t, err)
+	err = c.Health()
+	require.Equal(t, &Error{StatusCode: http.StatusBadGateway, Message: "upstream unavailable"}, err)
+	require.Equal(t, "Bearer secret", header.Get("Authorization"))
+	require.Equal(t, "req-1", header.Get("X-Request-ID"))
+
+	// test options change a copy of the given http client
+	_, err = New(server.URL, WithHTTPClient(http.DefaultClient), WithTimeout(time.Second))
+	require.NoError(t, err)
+	require.Zero(t, http.DefaultClient.Timeout)
+}
+
+func TestClientKeys(t *testing.T) {
+	server, c := setup(t)
+	defer server.Close()
+
+	// test importing an exported key and adding watch-only keys
+	_, err := c.CreateKey(api.AddNewKey{Name: "a", Password: testPass, Mnemonic: sMnemonic})
+	require.NoError(t, err)
+	armor, err := c.ExportKey("a", api.ExportKeyBody{Password: testPass})
+	require.NoError(t, err)
+	key, err := c.ImportKey(api.ImportKeyBody{Name: "imported", Armor: armor.Armor, Passphrase: testPass, Password: testPass})
+	require.NoError(t, err)
+	require.Equal(t, sAcc, key.Address)
+	key, err = c.CreateOfflineKey(api.OfflineKeyBody{Name: "cold", Address: sAcc})
+	require.NoError(t, err)
+	require.Equal(t, sAcc, key.Address)
+	_, err = c.CreateOfflineKey(api.OfflineKeyBody{Name: "cold", PubKey: sAccPub})
+	require.Equal(t, http.StatusConflict, err.(*Error).StatusCode)
+
+	// test deriving keys and confirming their backup
+	derived, err := c.DeriveKeys(api.DeriveKeysBody{Mnemonic: sMnemonic, Indexes: "0-1", ComputeOnly: true})
+	require.NoError(t, err)
+	require.Len(t, derived, 2)
+	require.Equal(t, sAcc, derived[0].Address)
+
+	key, err = c.CreateKey(api.AddNewKey{Name: "b", Password: testPass, VerifyBackup: true})
+	require.NoError(t, err)
+	mn, err := c.RevealMnemonic("b", testPass)
+	require.NoError(t, err)
+	require.Equal(t, key.Mnemonic, mn.Mnemonic)
+	_, err = c.RevealMnemonic("b", "wrongpassword")
+	require.True(t, IsUnauthorized(err))
+
+	words := make(map[int]string)
+	for _, pos := range key.BackupWords {
+		words[pos] = strings.Fields(mn.Mnemonic)[pos-1]
+	}
+	key, err = c.ConfirmBackup("b", api.BackupConfirmBody{Password: testPass, Words: words})
+	require.NoError(t, err)
+	require.False(t, key.BackupUnconfirmed)
+	_, err = c.CreateMultiKey(api.MultiKeyBody{Name: "multi", Keys: []string{"cold", "b"}, Threshold: 1})
+	require.Equal(t, http.StatusBadRequest, err.(*Error).StatusCode)
+	key, err = c.CreateMultiKey(api.MultiKeyBody{Name: "multi", Keys: []string{"a", "b"}, Threshold: 2})
+	require.NoError(t, err)
+	require.Equal(t, "multi", key.Type)
+	_, err = c.RevealMnemonic("b", testPass)
+	require.Equal(t, http.StatusBadRequest, err.(*Error).StatusCode)
+}
+
+func TestClientRemoteKey(t *testing.T) {
+	priv, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/transit/keys/validator", r.URL.Path)
+		spki, err := asn1.Marshal(struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}{
+			Algorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1},
+				Parameters: asn1.RawValue{FullBytes: []byte{0x06, 0x05, 0x2b, 0x81, 0x04, 0x00, 0x0a}},
+			},
+			PublicKey: asn1.BitString{Bytes: priv.PubKey().SerializeUncompressed(), BitLength: 65 * 8},
+		})
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"type":           "ecdsa-secp256k1",
+			"latest_version": 1,
+			"keys":           map[string]interface{}{"1": map[string]string{"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: spki}))}},
+		}})
+	}))
+	defer vault.Close()
+
+	s := &api.Server{Node: "tcp://127.0.0.1:1", Vault: api.VaultConfig{Address: vault.URL, Token: "root"}}
+	s.SetKeystore(api.NewMemoryKeystore())
+	c, err := New("http://localhost", WithHandler(s.Router()))
+	require.NoError(t, err)
+
+	key, err := c.CreateRemoteKey(api.RemoteKeyBody{Name: "operator", Backend: api.SignerVault, KeyName: "validator", Password: testPass})
+	require.NoError(t, err)
+	require.Equal(t, api.SignerVault, key.Type)
+	_, err = c.CreateRemoteKey(api.RemoteKeyBody{Name: "other", Backend: "kms", Password: testPass})
+	require.Equal(t, http.StatusBadRequest, err.(*Error).StatusCode)
+}
+
+func TestClientTx(t *testing.T) {
+	rpccdc := amino.NewCodec()
+	ctypes.RegisterAmino(rpccdc)
+	simulated := app.MakeCodec().MustMarshalBinaryLengthPrefixed(sdk.Result{GasUsed: 50000})
+	var methods []string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req rpctypes.RPCRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		methods = append(methods, req.Method)
+		var result interface{}
+		switch req.Method {
+		case "abci_query":
+			result = &ctypes.ResultABCIQuery{Response: abci.ResponseQuery{Value: simulated}}
+		case "broadcast_tx_sync":
+			result = &ctypes.ResultBroadcastTx{Hash: []byte{0xab}}
+		default:
+			json.NewEncoder(w).Encode(rpctypes.RPCMethodNotFoundError(req.ID))
+			return
+		}
+		json.NewEncoder(w).Encode(rpctypes.NewRPCSuccessResponse(rpccdc, req.ID, result))
+	}))
+	defer node.Close()
+
+	// the handler resets the sdk config after every request, like a client
+	// process that never used the chain's prefixes
+	s := &api.Server{Node: "tcp://127.0.0.1:1", Chains: []api.Chain{{Name: "terra", Node: node.URL, Bech32Prefix: "terra", CoinType: 330}}}
+	s.SetKeystore(api.NewMemoryKeystore())
+	router := s.Router()
+	c, err := New("http://localhost", WithChain("terra"), WithHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r)
+		resetConfig()
+	})))
+	require.NoError(t, err)
+
+	// test generating, signing and broadcasting a send with the chain's prefixes
+	key, err := c.CreateKey(api.AddNewKey{Name: "a", Password: testPass, Mnemonic: sMnemonic})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(key.Address, "terra1"))
+	tx, err := c.BankSend(api.BankSendBody{Sender: key.Address, Reciever: key.Address, Amount: "10stake", GasPrices: "0.01stake"})
+	require.NoError(t, err)
+	require.Contains(t, string(tx), key.Address)
+	var unsigned struct {
+		Value struct {
+			Fee struct {
+				Amount sdk.Coins `json:"amount"`
+				Gas    string    `json:"gas"`
+			} `json:"fee"`
+		} `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(tx, &unsigned))
+	require.Equal(t, "50000", unsigned.Value.Fee.Gas)
+	require.Equal(t, "500stake", unsigned.Value.Fee.Amount.String())
+
+	signed, err := c.Sign(api.SignBody{Tx: tx, Name: "a", Passphrase: testPass, ChainID: "testing", AccountNumber: "1", Sequence: "0"})
+	require.NoError(t, err)
+	require.Contains(t, string(signed), key.Address)
+	res, err := c.Broadcast(signed)
+	require.NoError(t, err)
+	require.Equal(t, "AB", res.TxHash)
+	require.Equal(t, []string{"abci_query", "broadcast_tx_sync"}, methods)
+
+	// test verifying the signed transaction
+	vr, err := c.Verify(api.VerifyBody{Tx: signed, ChainID: "testing", Accounts: []api.VerifyAccount{{AccountNumber: "1", Sequence: "0"}}})
+	require.NoError(t, err)
+	require.True(t, vr.Valid)
+	require.Equal(t, "a", vr.Signers[0].Name)
+	require.Equal(t, key.Address, vr.Signers[0].Address)
+}
+
+// resetConfig sets the sdk config back to the cosmos prefixes
+func resetConfig() {
+	config := sdk.GetConfig()
+	config.SetBech32PrefixForAccount(sdk.Bech32PrefixAccAddr, sdk.Bech32PrefixAccPub)
+	config.SetBech32PrefixForValidator(sdk.Bech32PrefixValAddr, sdk.Bech32PrefixValPub)
+	config.SetBech32PrefixForConsensusNode(sdk.Bech32PrefixConsAddr, sdk.Bech32PrefixConsPub)
+}
+
+// decodeTx decodes a transaction with the sdk config's prefixes
+func decodeTx(t *testing.T, bz []byte) (tx auth.StdTx) {
+	require.NoError(t, app.MakeCodec().UnmarshalJSON(bz, &tx))
+	return tx
+}
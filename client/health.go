@@ -0,0 +1,31 @@
+package client
+
+import (
+	"net/http"
+
+	"github.com/jackzampolin/keyserver/api"
+)
+
+// Version returns the keyserver's version
+func (c *Client) Version() (v api.VersionInfo, err error) {
+	err = c.call(http.MethodGet, "/version", nil, nil, &v)
+	return
+}
+
+// Health returns an error unless the keyserver is serving requests
+func (c *Client) Health() error {
+	return c.call(http.MethodGet, "/healthz", nil, nil, nil)
+}
+
+// Ready returns the keyserver's readiness, the error is set along with the
+// readiness when the keybase or a node isn't ready
+func (c *Client) Ready() (rd api.Readiness, err error) {
+	bz, _, err := c.do(http.MethodGet, "/readyz", nil, nil)
+	if _, ok := err.(*Error); err != nil && !ok {
+		return rd, err
+	}
+	if derr := decode(bz, &rd); derr != nil {
+		return rd, derr
+	}
+	return rd, err
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/jackzampolin/keyserver/api"
+)
+
+// KeysFilter selects and pages the keys returned by Keys. Keys must carry
+// every label, be of Type and have a name starting with Prefix. Limit caps
+// the page size and Cursor continues after the previous page.
+type KeysFilter struct {
+	Labels            []string
+	Type              string
+	Prefix            string
+	BackupUnconfirmed bool
+	Limit             int
+	Cursor            string
+}
+
+func (f KeysFilter) query() url.Values {
+	q := url.Values{}
+	for _, label := range f.Labels {
+		q.Add("label", label)
+	}
+	if f.Type != "" {
+		q.Set("type", f.Type)
+	}
+	if f.Prefix != "" {
+		q.Set("prefix", f.Prefix)
+	}
+	if f.BackupUnconfirmed {
+		q.Set("backup", "unconfirmed")
+	}
+	if f.Limit > 0 {
+		q.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if f.Cursor != "" {
+		q.Set("cursor", f.Cursor)
+	}
+	return q
+}
+
+// Keys lists the keys matching f, next is the cursor of the next page if more keys match
+func (c *Client) Keys(f KeysFilter) (keys []api.KeyOutput, next string, err error) {
+	bz, header, err := c.do(http.MethodGet, "/keys", f.query(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = decode(bz, &keys); err != nil {
+		return nil, "", err
+	}
+	return keys, header.Get(api.NextCursorHeader), nil
+}
+
+// CreateKey creates a key, or restores it when a mnemonic is given. The
+// generated mnemonic is only returned by this call.
+func (c *Client) CreateKey(ak api.AddNewKey) (ko api.KeyOutput, err error) {
+	err = c.call(http.MethodPost, "/keys", nil, ak, &ko)
+	return
+}
+
+// Key returns the key, bech selects the acc, val or cons address and public key encoding
+func (c *Client) Key(name, bech string) (ko api.KeyOutput, err error) {
+	q := url.Values{}
+	if bech != "" {
+		q.Set("bech", bech)
+	}
+	err = c.call(http.MethodGet, keyPath(name), q, nil, &ko)
+	return
+}
+
+// UpdatePassword changes the password of a key
+func (c *Client) UpdatePassword(name, oldPassword, newPassword string) error {
+	return c.call(http.MethodPut, keyPath(name), nil, api.UpdateKeyBody{OldPassword: oldPassword, NewPassword: newPassword}, nil)
+}
+
+// PatchKey edits the labels and metadata of a key
+func (c *Client) PatchKey(name string, pb api.PatchKeyBody) (ko api.KeyOutput, err error) {
+	err = c.call(http.MethodPatch, keyPath(name), nil, pb, &ko)
+	return
+}
+
+// DeleteKey deletes a key, the password is checked for local keys
+func (c *Client) DeleteKey(name, password string) error {
+	return c.call(http.MethodDelete, keyPath(name), nil, api.DeleteKeyBody{Password: password}, nil)
+}
+
+// ExportKey returns the key's private key armored with the export password
+func (c *Client) ExportKey(name string, eb api.ExportKeyBody) (ka api.KeyArmor, err error) {
+	err = c.call(http.MethodPost, keyPath(name, "export"), nil, eb, &ka)
+	return
+}
+
+// ImportKey imports an armored private or public key
+func (c *Client) ImportKey(ib api.ImportKeyBody) (ko api.KeyOutput, err error) {
+	err = c.call(http.MethodPost, "/keys/import", nil, ib, &ko)
+	return
+}
+
+// CreateOfflineKey registers a watch-only public key or address
+func (c *Client) CreateOfflineKey(ob api.OfflineKeyBody) (ko api.KeyOutput, err error) {
+	err = c.call(http.MethodPost, "/keys/offline", nil, ob, &ko)
+	return
+}
+
+// CreateMultiKey registers a multisig key from the public keys of registered keys
+func (c *Client) CreateMultiKey(mb api.MultiKeyBody) (ko api.KeyOutput, err error) {
+	err = c.call(http.MethodPost, "/keys/multi", nil, mb, &ko)
+	return
+}
+
+// CreateRemoteKey registers a key held by a signing backend
+func (c *Client) CreateRemoteKey(rb api.RemoteKeyBody) (ko api.KeyOutput, err error) {
+	err = c.call(http.MethodPost, "/keys/remote", nil, rb, &ko)
+	return
+}
+
+// DeriveKeys derives keys in bulk from a retained or given mnemonic
+func (c *Client) DeriveKeys(db api.DeriveKeysBody) (keys []api.DerivedKey, err error) {
+	err = c.call(http.MethodPost, "/keys/derive", nil, db, &keys)
+	return
+}
+
+// RenameKey moves a key and its metadata to a new name
+func (c *Client) RenameKey(name string, rb api.RenameKeyBody) (ko api.KeyOutput, err error) {
+	err = c.call(http.MethodPost, keyPath(name, "rename"), nil, rb, &ko)
+	return
+}
+
+// ConfirmBackup confirms the mnemonic backup of a key with the requested words
+func (c *Client) ConfirmBackup(name string, bb api.BackupConfirmBody) (ko api.KeyOutput, err error) {
+	err = c.call(http.MethodPost, keyPath(name, "backup", "confirm"), nil, bb, &ko)
+	return
+}
+
+// RevealMnemonic returns the mnemonic kept for a key
+func (c *Client) RevealMnemonic(name, password string) (mn api.Mnemonic, err error) {
+	err = c.call(http.MethodPost, keyPath(name, "backup", "reveal"), nil, api.BackupRevealBody{Password: password}, &mn)
+	return
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/jackzampolin/keyserver/api"
+)
+
+// Sign signs the transaction in sb and returns it with the signature
+// appended. Transactions are passed through as the keyserver's json rather
+// than decoded, their addresses have the prefixes of the selected chain,
+// which the sdk config of the calling process may not use.
+func (c *Client) Sign(sb api.SignBody) (json.RawMessage, error) {
+	bz, _, err := c.do(http.MethodPost, "/tx/sign", nil, sb)
+	if err != nil {
+		return nil, err
+	}
+	return bz, nil
+}
+
+// Broadcast broadcasts a signed transaction and returns once the node has
+// checked it, before it is included in a block. A transaction failing the
+// check returns an *Error with the chain_rejected code.
+func (c *Client) Broadcast(tx json.RawMessage) (res sdk.TxResponse, err error) {
+	bz, _, err := c.do(http.MethodPost, "/tx/broadcast", nil, []byte(tx))
+	if err != nil {
+		return res, err
+	}
+	return res, c.decodeAmino(bz, &res)
+}
+
+// Verify checks the signatures of the transaction in vb and names the
+// registered key of each signer
+func (c *Client) Verify(vb api.VerifyBody) (res api.VerifyResult, err error) {
+	err = c.call(http.MethodPost, "/tx/verify", nil, vb, &res)
+	return
+}
+
+// BankSend generates an unsigned send transaction with simulated gas, the
+// sender and receiver are bech32 addresses with the chain's prefix
+func (c *Client) BankSend(sb api.BankSendBody) (json.RawMessage, error) {
+	bz, _, err := c.do(http.MethodPost, "/tx/bank/send", nil, sb)
+	if err != nil {
+		return nil, err
+	}
+	return bz, nil
+}
+
+func (c *Client) decodeAmino(bz []byte, out interface{}) error {
+	if err := c.cdc.UnmarshalJSON(bz, out); err != nil {
+		return fmt.Errorf("invalid response: %s", err)
+	}
+	return nil
+}
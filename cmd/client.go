@@ -0,0 +1,124 @@
+// Copyright © 2018 Jack Zampolin <jack@blockstack.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/jackzampolin/keyserver/client"
+	"github.com/spf13/cobra"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+const (
+	flagLocal = "local"
+	flagChain = "chain"
+)
+
+var (
+	// localMode is set by --local to run requests in-process against the configured keystore
+	localMode bool
+
+	// chainName is set by --chain to select a chain profile of the keyserver
+	chainName string
+)
+
+// newClient returns a client for the keyserver selected with --server, or
+// one that runs requests in-process with --local
+func newClient() *client.Client {
+	if localMode {
+		return localClient()
+	}
+	r := target()
+	opts, err := r.options()
+	if err != nil {
+		fatal("invalid remote", "name", r.Name, "err", err)
+	}
+	if chainName != "" {
+		opts = append(opts, client.WithChain(chainName))
+	}
+	c, err := client.New(r.URL, opts...)
+	if err != nil {
+		fatal("invalid keyserver url", "name", r.Name, "err", err)
+	}
+	return c
+}
+
+// localClient opens the configured keystore and returns a client that serves
+// requests with the server's router, without a running keyserver
+func localClient() *client.Client {
+	if f := rootCmd.PersistentFlags().Lookup(flagServer); f != nil && f.Changed {
+		fatal("pass either --local or --server")
+	}
+	if err := server.Validate(); err != nil {
+		fatal("invalid configuration", "err", err)
+	}
+	if err := server.OpenKeystore(); err != nil {
+		fatal("failed opening keystore", "type", server.Keystore.Type, "err", err)
+	}
+	// the cli logs failed requests itself
+	server.SetLogger(log.NewFilter(logger, log.AllowError()))
+
+	c, err := client.New("http://localhost", client.WithHandler(server.Router()), client.WithChain(chainName))
+	if err != nil {
+		fatal("failed creating local client", "err", err)
+	}
+	return c
+}
+
+// addClientFlags adds --local and --chain to the commands
+func addClientFlags(cmds ...*cobra.Command) {
+	for _, cmd := range cmds {
+		cmd.PersistentFlags().BoolVar(&localMode, flagLocal, false, "run against the configured key_dir and keystore in-process, without a running keyserver")
+		cmd.PersistentFlags().StringVar(&chainName, flagChain, "", "name of the keyserver's chain profile to use, defaults to the cosmos hub prefixes and the server's node")
+	}
+}
+
+// Exit codes of failed requests, other failures exit with 1
+const (
+	exitRejected     = 2
+	exitUnauthorized = 3
+	exitNotFound     = 4
+	exitServerError  = 5
+)
+
+// fatalRequest logs the error of a failed request, including the server's
+// error message, and exits with a code for the kind of failure
+func fatalRequest(msg string, err error) {
+	e, ok := err.(*client.Error)
+	if !ok {
+		fatal(msg, "err", err)
+	}
+
+	code := exitRejected
+	switch {
+	case e.StatusCode == http.StatusUnauthorized, e.StatusCode == http.StatusForbidden:
+		code = exitUnauthorized
+	case e.StatusCode == http.StatusNotFound:
+		code = exitNotFound
+	case e.StatusCode >= 500:
+		code = exitServerError
+	}
+	keyvals := []interface{}{"status", e.StatusCode, "code", e.Code, "error", e.Message, "request_id", e.RequestID}
+	if e.Codespace != "" || e.ABCICode != 0 {
+		keyvals = append(keyvals, "codespace", e.Codespace, "abci_code", e.ABCICode)
+	}
+	if len(e.Details) > 0 {
+		keyvals = append(keyvals, "details", e.Details)
+	}
+	logger.Error(msg, keyvals...)
+	os.Exit(code)
+}
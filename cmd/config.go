@@ -15,60 +15,381 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 
 	"github.com/jackzampolin/keyserver/api"
 	homedir "github.com/mitchellh/go-homedir"
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	yaml "gopkg.in/yaml.v2"
 )
 
+const (
+	flagShowSecrets = "show-secrets"
+
+	// envPrefix prefixes the environment variables that override the config
+	envPrefix = "KEYSERVER"
+)
+
+// legacyKeys are the keys written by older versions of `keyserver config`,
+// they are read as their current names
+var legacyKeys = map[string]string{
+	"keydir":        "key_dir",
+	"mnemonicwords": "mnemonic_words",
+	"loglevel":      "log_level",
+	"logformat":     "log_format",
+}
+
 // versionCmd represents the version command
 var configCmd = &cobra.Command{
 	Use:   "config",
 	Short: "Sets a default config file",
 	Run: func(cmd *cobra.Command, args []string) {
-		home, err := homedir.Dir()
-		if err != nil {
-			fmt.Println("Error finding homedir:", err)
-			return
-		}
-
-		s := api.Server{
-			Port:   3000,
-			KeyDir: fmt.Sprintf("%s/.keyserver", home),
-			Node:   "http://localhost:26657",
-		}
-
+		s := defaultConfig()
 		if _, err := os.Stat(s.KeyDir); os.IsNotExist(err) {
 			err := os.MkdirAll(s.KeyDir, 0777)
 			if err != nil {
-				fmt.Println("Error creating directory:", err)
-				return
+				fatal("error creating directory", "dir", s.KeyDir, "err", err)
 			}
 		}
 
 		conf := fmt.Sprintf("%s/config.yaml", s.KeyDir)
 		if _, err := os.Stat(conf); os.IsNotExist(err) {
-			out, err := yaml.Marshal(s)
+			out, err := configYAML(s)
 			if err != nil {
-				fmt.Println("Error marshaling config:", err)
-				return
+				fatal("error marshaling config", "err", err)
 			}
-			file, err := os.Create(conf)
-			if err != nil {
-				fmt.Println("Error creating config file:", err)
-				return
+			if err = ioutil.WriteFile(conf, out, 0600); err != nil {
+				fatal("error creating config file", "file", conf, "err", err)
 			}
-			defer file.Close()
-			fmt.Fprintf(file, string(out))
 		} else {
-			fmt.Println("Config file already exists, skipping...")
+			logger.Info("config file already exists, skipping", "file", conf)
+		}
+	},
+}
+
+// configShowCmd prints the effective config
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the config merged from the defaults, the config file and KEYSERVER_* environment variables",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		// redact a copy of the config
+		bz, err := json.Marshal(server)
+		if err != nil {
+			fatal("error marshaling config", "err", err)
+		}
+		s := &api.Server{}
+		if err = json.Unmarshal(bz, s); err != nil {
+			fatal("error marshaling config", "err", err)
+		}
+		rs := remotes()
+		if secrets, _ := cmd.Flags().GetBool(flagShowSecrets); !secrets {
+			for _, secret := range []*string{&s.Keystore.Password, &s.Vault.Token, &s.HSM.PIN} {
+				redactSecret(secret)
+			}
+			for i := range rs {
+				redactSecret(&rs[i].Token)
+			}
+		}
+
+		if outputFormat == "" {
+			outputFormat = outputYAML
+		}
+		printOutput(struct {
+			*api.Server
+			Target  string   `json:"server,omitempty"`
+			Remotes []remote `json:"remotes,omitempty"`
+		}{s, viper.GetString(flagServer), rs})
+	},
+}
+
+// configSetCmd sets a value in the config file
+var configSetCmd = &cobra.Command{
+	Use:   "set [key] [value]",
+	Short: "Set a value in the config file, nested keys are joined with a dot, e.g. keystore.type",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		key := strings.ToLower(args[0])
+		if k, ok := legacyKeys[key]; ok {
+			key = k
+		}
+		if !isConfigKey(key) {
+			fatal("unknown config key, edit the config file to change chains and remotes", "key", args[0])
+		}
+
+		file := viper.ConfigFileUsed()
+		if file == "" {
+			fatal("no config file, create one with keyserver config")
+		}
+		bz, err := ioutil.ReadFile(file)
+		if err != nil {
+			fatal("failed reading config", "file", file, "err", err)
+		}
+		var doc yaml.MapSlice
+		if err = yaml.Unmarshal(bz, &doc); err != nil {
+			fatal("failed reading config", "file", file, "err", err)
+		}
+		out, err := yaml.Marshal(setConfigValue(doc, key, configValue(args[1])))
+		if err != nil {
+			fatal("error marshaling config", "err", err)
+		}
+
+		// check the new config loads before writing it
+		v := viper.New()
+		bindConfig(v)
+		v.SetConfigType("yaml")
+		if err = v.ReadConfig(bytes.NewReader(out)); err != nil {
+			fatal("invalid config", "err", err)
+		}
+		s, err := decodeConfig(v)
+		if err == nil {
+			err = s.Validate()
+		}
+		if err != nil {
+			fatal("invalid config", "key", key, "err", err)
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			fatal("failed reading config", "file", file, "err", err)
 		}
+		if err = ioutil.WriteFile(file, out, info.Mode()); err != nil {
+			fatal("failed writing config", "file", file, "err", err)
+		}
+		logger.Info("config updated", "file", file, "key", key)
 	},
 }
 
+// defaultConfig is the config written by `keyserver config`, and the
+// defaults of settings missing from the config file
+func defaultConfig() *api.Server {
+	home, err := homedir.Dir()
+	if err != nil {
+		fatal("error finding homedir", "err", err)
+	}
+	return &api.Server{
+		Port:   3000,
+		KeyDir: filepath.Join(home, ".keyserver"),
+		Node:   "http://localhost:26657",
+
+		MnemonicWords: 24,
+
+		Keystore: api.KeystoreConfig{Type: api.KeystoreLevelDB},
+
+		LogLevel:  "info",
+		LogFormat: "json",
+	}
+}
+
+// configKeys returns the key of every setting of api.Server, the keys of
+// nested settings are joined with a dot
+func configKeys() []string {
+	var keys []string
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			if t.Field(i).Type.Kind() == reflect.Struct {
+				walk(t.Field(i).Type, prefix+name+".")
+				continue
+			}
+			keys = append(keys, prefix+name)
+		}
+	}
+	walk(reflect.TypeOf(api.Server{}), "")
+	return keys
+}
+
+// isConfigKey returns whether key can be set with `keyserver config set`
+func isConfigKey(key string) bool {
+	if key == flagServer {
+		return true
+	}
+	for _, k := range configKeys() {
+		if k == key && k != "chains" {
+			return true
+		}
+	}
+	return false
+}
+
+// envName returns the environment variable overriding key, e.g.
+// KEYSERVER_KEYSTORE_PASSWORD for keystore.password
+func envName(key string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+// bindConfig sets the defaults of v and binds every setting to its
+// environment variable
+func bindConfig(v *viper.Viper) {
+	for _, key := range configKeys() {
+		v.BindEnv(key, envName(key))
+	}
+	v.BindEnv(flagServer, envServer)
+
+	d := defaultConfig()
+	v.SetDefault("port", d.Port)
+	v.SetDefault("key_dir", d.KeyDir)
+	v.SetDefault("node", d.Node)
+	v.SetDefault("mnemonic_words", d.MnemonicWords)
+	v.SetDefault("keystore.type", d.Keystore.Type)
+	v.SetDefault("log_level", d.LogLevel)
+	v.SetDefault("log_format", d.LogFormat)
+}
+
+// decodeConfig decodes the settings of v, which has read its config file,
+// into an api.Server
+func decodeConfig(v *viper.Viper) (*api.Server, error) {
+	// aliases registered after reading move legacy keys to their current names
+	for legacy, key := range legacyKeys {
+		v.RegisterAlias(legacy, key)
+	}
+
+	settings := v.AllSettings()
+	// chains are set in the environment as a json list
+	if chains, ok := settings["chains"].(string); ok {
+		var list []interface{}
+		if err := json.Unmarshal([]byte(chains), &list); err != nil {
+			return nil, fmt.Errorf("invalid chains: %s", err)
+		}
+		settings["chains"] = list
+	}
+
+	s := &api.Server{}
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{WeaklyTypedInput: true, Result: s})
+	if err != nil {
+		return nil, err
+	}
+	return s, dec.Decode(fieldNames(settings))
+}
+
+// fieldNames strips the underscores from the keys of the settings, so both
+// the current names and the legacy ones match the api.Server field names
+func fieldNames(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			out[strings.Replace(k, "_", "", -1)] = fieldNames(val)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			out[strings.Replace(fmt.Sprint(k), "_", "", -1)] = fieldNames(val)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			out[i] = fieldNames(val)
+		}
+		return out
+	}
+	return v
+}
+
+// configYAML encodes v as yaml with its json field names, in their order
+func configYAML(v interface{}) ([]byte, error) {
+	bz, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// json is yaml, objects keep their field order as MapSlices
+	if bytes.HasPrefix(bz, []byte("{")) {
+		var doc yaml.MapSlice
+		if err = yaml.Unmarshal(bz, &doc); err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(doc)
+	}
+	var docs []yaml.MapSlice
+	if err = yaml.Unmarshal(bz, &docs); err == nil {
+		return yaml.Marshal(docs)
+	}
+	var list interface{}
+	if err = yaml.Unmarshal(bz, &list); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(list)
+}
+
+// configValue parses a value passed on the command line as a yaml scalar,
+// so numbers and booleans keep their type
+func configValue(arg string) interface{} {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(arg), &v); err != nil || v == nil {
+		return arg
+	}
+	switch v.(type) {
+	case map[interface{}]interface{}, []interface{}:
+		return arg
+	}
+	return v
+}
+
+// setConfigValue sets key, with nested keys joined with a dot, to value in
+// the config document, a legacy name of the key is renamed in place or
+// dropped if the document also has the current name
+func setConfigValue(doc yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	found := false
+	for _, item := range doc {
+		found = found || fmt.Sprint(item.Key) == key
+	}
+	out := doc[:0]
+	for _, item := range doc {
+		if name := fmt.Sprint(item.Key); legacyKeys[name] == key && name != key {
+			if found {
+				continue
+			}
+			item.Key, found = key, true
+		}
+		out = append(out, item)
+	}
+	return setMapItem(out, strings.Split(key, "."), value)
+}
+
+// setMapItem sets the item at path to value, in place so the other items
+// keep their values and order, or appends it
+func setMapItem(doc yaml.MapSlice, path []string, value interface{}) yaml.MapSlice {
+	for i, item := range doc {
+		if fmt.Sprint(item.Key) != path[0] {
+			continue
+		}
+		if len(path) > 1 {
+			nested, _ := item.Value.(yaml.MapSlice)
+			value = setMapItem(nested, path[1:], value)
+		}
+		doc[i].Value = value
+		return doc
+	}
+	if len(path) > 1 {
+		value = setMapItem(nil, path[1:], value)
+	}
+	return append(doc, yaml.MapItem{Key: path[0], Value: value})
+}
+
+func redactSecret(secret *string) {
+	if *secret != "" {
+		*secret = "<redacted>"
+	}
+}
+
 func init() {
+	configShowCmd.Flags().Bool(flagShowSecrets, false, "show passwords and tokens instead of redacting them")
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configSetCmd)
 	rootCmd.AddCommand(configCmd)
 }
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackzampolin/keyserver/api"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+// loadConfig decodes the config in bz the way initConfig does
+func loadConfig(t *testing.T, bz []byte) *api.Server {
+	v := viper.New()
+	bindConfig(v)
+	v.SetConfigType("yaml")
+	require.NoError(t, v.ReadConfig(bytes.NewReader(bz)))
+	s, err := decodeConfig(v)
+	require.NoError(t, err)
+	return s
+}
+
+func TestConfigSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte("port: 3000\nkeydir: "+dir+"\nkeystore:\n  type: file\n  path: keys.json\nlog_level: info\n"), 0600))
+
+	viper.SetConfigFile(file)
+	defer viper.Reset()
+	logger, _ = api.NewLogger(ioutil.Discard, "", "")
+
+	// test setting a nested key keeps its siblings
+	configSetCmd.Run(configSetCmd, []string{"keystore.password", "secret"})
+	bz, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	s := loadConfig(t, bz)
+	require.Equal(t, api.KeystoreConfig{Type: api.KeystoreFile, Path: "keys.json", Password: "secret"}, s.Keystore)
+	require.Equal(t, dir, s.KeyDir)
+	require.Equal(t, 3000, s.Port)
+
+	// test setting new keys appends them and keeps numbers as numbers
+	configSetCmd.Run(configSetCmd, []string{"vault.address", "http://127.0.0.1:8200"})
+	configSetCmd.Run(configSetCmd, []string{"port", "3001"})
+	bz, err = ioutil.ReadFile(file)
+	require.NoError(t, err)
+	s = loadConfig(t, bz)
+	require.Equal(t, "http://127.0.0.1:8200", s.Vault.Address)
+	require.Equal(t, 3001, s.Port)
+	require.Equal(t, "secret", s.Keystore.Password)
+
+	// test setting a key by its legacy name replaces the legacy key
+	configSetCmd.Run(configSetCmd, []string{"loglevel", "debug"})
+	configSetCmd.Run(configSetCmd, []string{"key_dir", filepath.Join(dir, "keys")})
+	bz, err = ioutil.ReadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, "port: 3001\nkey_dir: "+filepath.Join(dir, "keys")+"\nkeystore:\n  type: file\n  path: keys.json\n  password: secret\nlog_level: debug\nvault:\n  address: http://127.0.0.1:8200\n", string(bz))
+}
+
+func TestConfigEnv(t *testing.T) {
+	for env, value := range map[string]string{
+		"KEYSERVER_PORT":              "4000",
+		"KEYSERVER_KEYSTORE_PASSWORD": "fromenv",
+		"KEYSERVER_HSM_PIN":           "1234",
+		"KEYSERVER_CHAINS":            `[{"name":"terra","bech32_prefix":"terra","coin_type":330}]`,
+	} {
+		require.NoError(t, os.Setenv(env, value))
+		defer os.Unsetenv(env)
+	}
+
+	// test the environment overrides the config file and the defaults
+	s := loadConfig(t, []byte("port: 3000\nkeystore:\n  type: file\n  password: fromfile\n"))
+	require.Equal(t, 4000, s.Port)
+	require.Equal(t, api.KeystoreConfig{Type: api.KeystoreFile, Password: "fromenv"}, s.Keystore)
+	require.Equal(t, "1234", s.HSM.PIN)
+	require.Equal(t, "info", s.LogLevel)
+	require.Len(t, s.Chains, 1)
+	require.Equal(t, uint32(330), s.Chains[0].CoinType)
+	require.Equal(t, "KEYSERVER_KEYSTORE_PASSWORD", envName("keystore.password"))
+}
+
+func TestConfigLegacyKeys(t *testing.T) {
+	// test configs written by older versions load
+	s := loadConfig(t, []byte("port: 3000\nkeydir: /tmp/keys\nmnemonicwords: 12\nloglevel: debug\nlogformat: text\n"))
+	require.Equal(t, "/tmp/keys", s.KeyDir)
+	require.Equal(t, 12, s.MnemonicWords)
+	require.Equal(t, "debug", s.LogLevel)
+	require.Equal(t, "text", s.LogFormat)
+
+	// test the environment overrides legacy keys
+	require.NoError(t, os.Setenv("KEYSERVER_KEY_DIR", "/tmp/env"))
+	defer os.Unsetenv("KEYSERVER_KEY_DIR")
+	s = loadConfig(t, []byte("keydir: /tmp/keys\n"))
+	require.Equal(t, "/tmp/env", s.KeyDir)
+}
@@ -15,16 +15,54 @@
 package cmd
 
 import (
-	"bytes"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/jackzampolin/keyserver/api"
+	"github.com/jackzampolin/keyserver/client"
 	"github.com/spf13/cobra"
 )
 
+const (
+	flagAccount  = "account"
+	flagIndex    = "index"
+	flagCoinType = "coin-type"
+	flagHDPath   = "hd-path"
+
+	flagBIP39Passphrase = "bip39-passphrase"
+	flagMnemonicWords   = "mnemonic-words"
+
+	flagAddress = "address"
+
+	flagThreshold = "threshold"
+
+	flagLabel  = "label"
+	flagType   = "type"
+	flagPrefix = "prefix"
+	flagLimit  = "limit"
+	flagCursor = "cursor"
+	flagMeta   = "meta"
+	flagUnset  = "unset"
+
+	flagRetainMnemonic = "retain-mnemonic"
+	flagBaseKey        = "base-key"
+	flagMnemonic       = "mnemonic"
+	flagAccounts       = "accounts"
+	flagIndexes        = "indexes"
+	flagNameTemplate   = "name-template"
+	flagComputeOnly    = "compute-only"
+	flagVerifyBackup   = "verify-backup"
+	flagBackup         = "backup"
+	flagKeyName        = "key-name"
+	flagRecover        = "recover"
+)
+
+// backupWordArg matches the position=word arguments of keys backup confirm
+var backupWordArg = regexp.MustCompile(`^[0-9]+=`)
+
 // versionCmd represents the version command
 var keysCmd = &cobra.Command{
 	Use:   "keys",
@@ -36,54 +74,60 @@ var keysGet = &cobra.Command{
 	Use:   "get",
 	Short: "Fetch all keys managed by the keyserver",
 	Run: func(cmd *cobra.Command, args []string) {
-		url := fmt.Sprintf("http://localhost:%d/keys", server.Port)
-		resp, err := http.Get(url)
-		if err != nil {
-			log.Fatalf("error fetching %s", url)
-			return
+		var f client.KeysFilter
+		f.Labels, _ = cmd.Flags().GetStringSlice(flagLabel)
+		f.Type, _ = cmd.Flags().GetString(flagType)
+		f.Prefix, _ = cmd.Flags().GetString(flagPrefix)
+		f.Limit, _ = cmd.Flags().GetInt(flagLimit)
+		f.Cursor, _ = cmd.Flags().GetString(flagCursor)
+		switch backup, _ := cmd.Flags().GetString(flagBackup); backup {
+		case "":
+		case "unconfirmed":
+			f.BackupUnconfirmed = true
+		default:
+			fatal("--backup must be unconfirmed", "backup", backup)
 		}
-		if resp.StatusCode != 200 {
-			log.Fatalf("non 200 respose code")
-			return
-		}
-		out, err := ioutil.ReadAll(resp.Body)
+
+		keys, next, err := newClient().Keys(f)
 		if err != nil {
-			log.Fatalf("failed reading response body")
-			return
+			fatalRequest("failed listing keys", err)
+		}
+		printOutput(keys)
+		if next != "" {
+			logger.Info("more keys match, pass --cursor for the next page", "cursor", next)
 		}
-		fmt.Println(string(out))
 	},
 }
 
 // /keys POST
 var keysPost = &cobra.Command{
-	Use:   "post [name] [password] [mnemonic]",
-	Args:  cobra.RangeArgs(2, 3),
-	Short: "Add a new key to the keyserver, optionally pass a mnemonic to restore the key",
+	Use:   "post [name]",
+	Args:  cobra.RangeArgs(1, 3),
+	Short: "Add a new key to the keyserver, pass --recover to restore the key from a mnemonic",
 	Run: func(cmd *cobra.Command, args []string) {
-		url := fmt.Sprintf("http://localhost:%d/keys", server.Port)
-		var addNP api.AddNewKey
-		if len(args) == 2 {
-			addNP = api.AddNewKey{Name: args[0], Password: args[1]}
-		} else if len(args) == 3 {
-			addNP = api.AddNewKey{Name: args[0], Password: args[1], Mnemonic: args[2]}
+		addNP := api.AddNewKey{Name: args[0]}
+		restore, _ := cmd.Flags().GetBool(flagRecover)
+		if restore || len(args) == 3 {
+			addNP.Mnemonic = argPassword(cmd, args, 2, "Mnemonic", false)
 		}
-
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(addNP.Marshal()))
-		if err != nil {
-			log.Fatalf("error fetching %s", url)
-			return
-		}
-		if resp.StatusCode != 200 {
-			log.Fatalf("non 200 respose code")
-			return
+		if bip39, _ := cmd.Flags().GetBool(flagBIP39Passphrase); bip39 {
+			addNP.BIP39Passphrase = readPassword(cmd, "BIP39 passphrase", !restore && len(args) < 3)
 		}
-		out, err := ioutil.ReadAll(resp.Body)
+		addNP.Password = argPassword(cmd, args, 1, "Password", true)
+		addNP.Account, _ = cmd.Flags().GetInt(flagAccount)
+		addNP.Index, _ = cmd.Flags().GetInt(flagIndex)
+		addNP.CoinType, _ = cmd.Flags().GetInt(flagCoinType)
+		addNP.HDPath, _ = cmd.Flags().GetString(flagHDPath)
+		addNP.MnemonicWords, _ = cmd.Flags().GetInt(flagMnemonicWords)
+		addNP.Labels, _ = cmd.Flags().GetStringSlice(flagLabel)
+		addNP.RetainMnemonic, _ = cmd.Flags().GetBool(flagRetainMnemonic)
+		addNP.VerifyBackup, _ = cmd.Flags().GetBool(flagVerifyBackup)
+
+		key, err := newClient().CreateKey(addNP)
 		if err != nil {
-			log.Fatalf("failed reading response body")
-			return
+			fatalRequest("failed creating key", err)
 		}
-		fmt.Println(string(out))
+		printOutput(key)
 	},
 }
 
@@ -93,94 +137,324 @@ var keyGet = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Short: "Fetch details for one key",
 	Run: func(cmd *cobra.Command, args []string) {
-		url := fmt.Sprintf("http://localhost:%d/keys/%s", server.Port, args[0])
-		resp, err := http.Get(url)
-		if err != nil {
-			log.Fatalf("error fetching %s", url)
-			return
-		}
-		if resp.StatusCode != 200 {
-			log.Fatalf("non 200 respose code")
-			return
-		}
-		out, err := ioutil.ReadAll(resp.Body)
+		key, err := newClient().Key(args[0], "")
 		if err != nil {
-			log.Fatalf("failed reading response body")
-			return
+			fatalRequest("failed fetching key", err)
 		}
-		fmt.Println(string(out))
+		printOutput(key)
 	},
 }
 
 // /keys/{name} PUT
 var keyPut = &cobra.Command{
-	Use:   "put [name] [oldpass] [newpass]",
-	Args:  cobra.ExactArgs(3),
+	Use:   "put [name]",
+	Args:  cobra.RangeArgs(1, 3),
 	Short: "Update the password on a key",
 	Run: func(cmd *cobra.Command, args []string) {
-		url := fmt.Sprintf("http://localhost:%d/keys/%s", server.Port, args[0])
-		kb := api.UpdateKeyBody{OldPassword: args[1], NewPassword: args[2]}
-		client := &http.Client{}
-		req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(kb.Marshal()))
+		oldPass := argPassword(cmd, args, 1, "Current password", false)
+		newPass := argPassword(cmd, args, 2, "New password", true)
+		if err := newClient().UpdatePassword(args[0], oldPass, newPass); err != nil {
+			fatalRequest("failed updating password", err)
+		}
+		logger.Info("password updated", "name", args[0])
+	},
+}
+
+// /keys/{name} DELETE
+var keyDelete = &cobra.Command{
+	Use:   "delete [name]",
+	Args:  cobra.RangeArgs(1, 2),
+	Short: "Delete a key, the password is checked for local keys",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := newClient().DeleteKey(args[0], argPassword(cmd, args, 1, "Password", false)); err != nil {
+			fatalRequest("failed deleting key", err)
+		}
+		logger.Info("key deleted", "name", args[0])
+	},
+}
+
+// /keys/{name}/export POST
+var keyExport = &cobra.Command{
+	Use:   "export [name]",
+	Args:  cobra.RangeArgs(1, 3),
+	Short: "Export a key as a password protected ASCII armored private key",
+	Run: func(cmd *cobra.Command, args []string) {
+		eb := api.ExportKeyBody{Password: argPassword(cmd, args, 1, "Password", false)}
+		if len(args) == 3 {
+			eb.ExportPassword = args[2]
+		} else if len(args) < 2 {
+			eb.ExportPassword = readPassword(cmd, "Export password, empty to reuse the key's password", true)
+		}
+		ka, err := newClient().ExportKey(args[0], eb)
 		if err != nil {
-			log.Fatalf("error fetching %s", url)
+			fatalRequest("failed exporting key", err)
+		}
+		if outputFormat == "" || quietOutput {
+			fmt.Println(ka.Armor)
 			return
 		}
-		resp, err := client.Do(req)
+		printOutput(ka)
+	},
+}
+
+// /keys/import POST
+var keyImport = &cobra.Command{
+	Use:   "import [name] [armor-file]",
+	Args:  cobra.RangeArgs(2, 4),
+	Short: "Import an ASCII armored private or public key, private keys ask for the armor's passphrase and the key's password",
+	Run: func(cmd *cobra.Command, args []string) {
+		armor, err := ioutil.ReadFile(args[1])
 		if err != nil {
-			log.Fatalf("error fetching %s", url)
-			return
+			fatal("error reading armor file", "file", args[1], "err", err)
 		}
-		if resp.StatusCode != 204 {
-			log.Fatalf("non 204 respose code %d", resp.StatusCode)
-			return
+		ib := api.ImportKeyBody{Name: args[0], Armor: string(armor)}
+		if len(args) > 2 || strings.Contains(ib.Armor, "PRIVATE KEY") {
+			ib.Passphrase = argPassword(cmd, args, 2, "Armor passphrase", false)
+		}
+		if len(args) > 3 {
+			ib.Password = args[3]
+		} else if len(args) < 3 && strings.Contains(ib.Armor, "PRIVATE KEY") {
+			ib.Password = readPassword(cmd, "Password, empty to reuse the passphrase", true)
 		}
-		out, err := ioutil.ReadAll(resp.Body)
+		key, err := newClient().ImportKey(ib)
 		if err != nil {
-			log.Fatalf("failed reading response body")
-			return
+			fatalRequest("failed importing key", err)
 		}
-		fmt.Println(out)
+		printOutput(key)
 	},
 }
 
-// /keys/{name} DELETE
-var keyDelete = &cobra.Command{
-	Use:   "delete [name] [password]",
+// /keys/offline POST
+var keyOffline = &cobra.Command{
+	Use:   "offline [name] [pubkey]",
+	Args:  cobra.ExactArgs(2),
+	Short: "Register a watch-only key from a bech32 public key, or an address with --address",
+	Run: func(cmd *cobra.Command, args []string) {
+		ob := api.OfflineKeyBody{Name: args[0], PubKey: args[1]}
+		if address, _ := cmd.Flags().GetBool(flagAddress); address {
+			ob = api.OfflineKeyBody{Name: args[0], Address: args[1]}
+		}
+		key, err := newClient().CreateOfflineKey(ob)
+		if err != nil {
+			fatalRequest("failed registering key", err)
+		}
+		printOutput(key)
+	},
+}
+
+// /keys/multi POST
+var keyMulti = &cobra.Command{
+	Use:   "multi [name] [key...]",
+	Args:  cobra.MinimumNArgs(2),
+	Short: "Register a multisig key from the public keys of registered keys, watch-only ones included",
+	Run: func(cmd *cobra.Command, args []string) {
+		mb := api.MultiKeyBody{Name: args[0], Keys: args[1:]}
+		mb.Threshold, _ = cmd.Flags().GetInt(flagThreshold)
+		key, err := newClient().CreateMultiKey(mb)
+		if err != nil {
+			fatalRequest("failed registering key", err)
+		}
+		printOutput(key)
+	},
+}
+
+// /keys/{name} PATCH
+var keyLabel = &cobra.Command{
+	Use:   "label [name] [label...]",
+	Args:  cobra.MinimumNArgs(1),
+	Short: "Replace the labels on a key if any are given, and edit its metadata with --meta and --unset",
+	Run: func(cmd *cobra.Command, args []string) {
+		pb := api.PatchKeyBody{Metadata: map[string]*string{}}
+		if labels := args[1:]; len(labels) > 0 {
+			pb.Labels = &labels
+		}
+		meta, _ := cmd.Flags().GetStringToString(flagMeta)
+		for k := range meta {
+			v := meta[k]
+			pb.Metadata[k] = &v
+		}
+		unset, _ := cmd.Flags().GetStringSlice(flagUnset)
+		for _, k := range unset {
+			pb.Metadata[k] = nil
+		}
+		key, err := newClient().PatchKey(args[0], pb)
+		if err != nil {
+			fatalRequest("failed updating key", err)
+		}
+		printOutput(key)
+	},
+}
+
+// /keys/remote POST
+var keyRemote = &cobra.Command{
+	Use:   "remote [name] [backend]",
 	Args:  cobra.ExactArgs(2),
-	Short: "Delete a key",
+	Short: "Register a key held by a signing backend such as vault or hsm",
 	Run: func(cmd *cobra.Command, args []string) {
-		url := fmt.Sprintf("http://localhost:%d/keys/%s", server.Port, args[0])
-		kb := api.DeleteKeyBody{Password: args[1]}
-		client := &http.Client{}
-		req, err := http.NewRequest(http.MethodDelete, url, bytes.NewBuffer(kb.Marshal()))
+		rb := api.RemoteKeyBody{Name: args[0], Backend: args[1]}
+		rb.KeyName, _ = cmd.Flags().GetString(flagKeyName)
+		rb.Password = readPassword(cmd, "Password required to sign with the key", true)
+		key, err := newClient().CreateRemoteKey(rb)
 		if err != nil {
-			log.Fatalf("error fetching %s", url)
-			return
+			fatalRequest("failed registering key", err)
+		}
+		printOutput(key)
+	},
+}
+
+// /keys/{name}/rename POST
+var keyRename = &cobra.Command{
+	Use:   "rename [name] [new-name]",
+	Args:  cobra.RangeArgs(2, 3),
+	Short: "Rename a key, the password is required for local keys",
+	Run: func(cmd *cobra.Command, args []string) {
+		rb := api.RenameKeyBody{NewName: args[1]}
+		rb.Password = argPassword(cmd, args, 2, "Password, empty for keys without one", false)
+		key, err := newClient().RenameKey(args[0], rb)
+		if err != nil {
+			fatalRequest("failed renaming key", err)
+		}
+		printOutput(key)
+	},
+}
+
+var keyBackup = &cobra.Command{
+	Use:   "backup",
+	Short: "Reveal and confirm the mnemonic of keys created with --verify-backup",
+}
+
+// /keys/{name}/backup/confirm POST
+var keyBackupConfirm = &cobra.Command{
+	Use:   "confirm [name] [position=word...]",
+	Args:  cobra.MinimumNArgs(2),
+	Short: "Confirm a key's mnemonic was backed up by supplying the requested words, e.g. 3=marine",
+	Run: func(cmd *cobra.Command, args []string) {
+		words := args[1:]
+		var bb api.BackupConfirmBody
+		if !backupWordArg.MatchString(args[1]) {
+			bb.Password = argPassword(cmd, args, 1, "Password", false)
+			words = args[2:]
+		} else {
+			bb.Password = readPassword(cmd, "Password", false)
+		}
+		bb.Words = make(map[int]string)
+		for _, arg := range words {
+			parts := strings.SplitN(arg, "=", 2)
+			pos, err := strconv.Atoi(parts[0])
+			if err != nil || len(parts) != 2 {
+				fatal("words must be given as position=word", "arg", arg)
+			}
+			bb.Words[pos] = parts[1]
 		}
-		resp, err := client.Do(req)
+		key, err := newClient().ConfirmBackup(args[0], bb)
 		if err != nil {
-			log.Fatalf("error fetching %s", url)
-			return
+			fatalRequest("failed confirming backup", err)
 		}
-		if resp.StatusCode != 200 {
-			log.Fatalf("non 200 respose code %d", resp.StatusCode)
+		printOutput(key)
+	},
+}
+
+// /keys/{name}/backup/reveal POST
+var keyBackupReveal = &cobra.Command{
+	Use:   "reveal [name]",
+	Args:  cobra.RangeArgs(1, 2),
+	Short: "Show the mnemonic of a key whose backup is unconfirmed or that retains its mnemonic",
+	Run: func(cmd *cobra.Command, args []string) {
+		mn, err := newClient().RevealMnemonic(args[0], argPassword(cmd, args, 1, "Password", false))
+		if err != nil {
+			fatalRequest("failed revealing mnemonic", err)
+		}
+		if outputFormat == "" || quietOutput {
+			fmt.Println(mn.Mnemonic)
 			return
 		}
-		out, err := ioutil.ReadAll(resp.Body)
+		printOutput(mn)
+	},
+}
+
+// /keys/derive POST
+var keysDerive = &cobra.Command{
+	Use:   "derive",
+	Args:  cobra.RangeArgs(0, 1),
+	Short: "Derive keys in bulk from --base-key, a key created with --retain-mnemonic, or from --mnemonic",
+	Run: func(cmd *cobra.Command, args []string) {
+		var db api.DeriveKeysBody
+		db.BaseKey, _ = cmd.Flags().GetString(flagBaseKey)
+		if mnemonic, _ := cmd.Flags().GetBool(flagMnemonic); mnemonic {
+			db.Mnemonic = readPassword(cmd, "Mnemonic", false)
+		}
+		if bip39, _ := cmd.Flags().GetBool(flagBIP39Passphrase); bip39 {
+			db.BIP39Passphrase = readPassword(cmd, "BIP39 passphrase", false)
+		}
+		db.CoinType, _ = cmd.Flags().GetInt(flagCoinType)
+		db.Accounts, _ = cmd.Flags().GetString(flagAccounts)
+		db.Indexes, _ = cmd.Flags().GetString(flagIndexes)
+		db.NameTemplate, _ = cmd.Flags().GetString(flagNameTemplate)
+		db.Labels, _ = cmd.Flags().GetStringSlice(flagLabel)
+		db.ComputeOnly, _ = cmd.Flags().GetBool(flagComputeOnly)
+		switch {
+		case db.BaseKey != "":
+			db.Password = argPassword(cmd, args, 0, "Password of the base key", false)
+		case !db.ComputeOnly:
+			db.Password = argPassword(cmd, args, 0, "Password of the derived keys", true)
+		}
+
+		keys, err := newClient().DeriveKeys(db)
 		if err != nil {
-			log.Fatalf("failed reading response body")
-			return
+			fatalRequest("failed deriving keys", err)
 		}
-		fmt.Println(out)
+		printOutput(keys)
 	},
 }
 
 func init() {
+	keysPost.Flags().Int(flagAccount, 0, "account number for HD derivation")
+	keysPost.Flags().Int(flagIndex, 0, "address index number for HD derivation")
+	keysPost.Flags().Int(flagCoinType, 0, "BIP44 coin type, defaults to the coin type of the server's chain")
+	keysPost.Flags().String(flagHDPath, "", "full BIP44 derivation path, e.g. m/44'/118'/0'/0/0")
+	keysPost.Flags().Bool(flagBIP39Passphrase, false, "ask for a BIP39 passphrase (25th word) to create or recover the key with")
+	keysPost.Flags().Int(flagMnemonicWords, 0, "number of words in the generated mnemonic (12 or 24), defaults to the server's mnemonic_words")
+	keysPost.Flags().StringSlice(flagLabel, nil, "label to add to the key, may be repeated")
+	keysPost.Flags().Bool(flagRetainMnemonic, false, "keep the mnemonic encrypted with the password to derive more keys from it")
+	keysPost.Flags().Bool(flagVerifyBackup, false, "keep the mnemonic encrypted until its backup is confirmed with keys backup confirm")
+	keysPost.Flags().Bool(flagRecover, false, "ask for the mnemonic to restore the key from")
+	keysGet.Flags().String(flagBackup, "", "set to unconfirmed to only list keys whose backup is unconfirmed")
+	keysDerive.Flags().String(flagBaseKey, "", "key created with --retain-mnemonic to derive from, the password decrypts its mnemonic")
+	keysDerive.Flags().Bool(flagMnemonic, false, "ask for a mnemonic to derive from instead of a base key")
+	keysDerive.Flags().Bool(flagBIP39Passphrase, false, "ask for the BIP39 passphrase (25th word) of the mnemonic")
+	keysDerive.Flags().Int(flagCoinType, 0, "BIP44 coin type, defaults to the coin type of the server's chain")
+	keysDerive.Flags().String(flagAccounts, "", "account range to derive, e.g. 0-9, defaults to 0")
+	keysDerive.Flags().String(flagIndexes, "", "address index range to derive, e.g. 0-99, defaults to 0")
+	keysDerive.Flags().String(flagNameTemplate, "", "name of the stored keys, {account} and {index} are replaced")
+	keysDerive.Flags().StringSlice(flagLabel, nil, "label to add to the derived keys, may be repeated")
+	keysDerive.Flags().Bool(flagComputeOnly, false, "only return the addresses and public keys, without storing the keys")
+	keysGet.Flags().StringSlice(flagLabel, nil, "only list keys with this label, may be repeated")
+	keysGet.Flags().String(flagType, "", "only list keys of this type, e.g. local, offline or address")
+	keysGet.Flags().String(flagPrefix, "", "only list keys whose name starts with this prefix")
+	keysGet.Flags().Int(flagLimit, 0, "maximum number of keys to list")
+	keysGet.Flags().String(flagCursor, "", "list keys after this name, from the cursor of the previous page")
+	keyLabel.Flags().StringToString(flagMeta, nil, "metadata entries to set, e.g. --meta customer=42")
+	keyLabel.Flags().StringSlice(flagUnset, nil, "metadata keys to remove")
+	keyRemote.Flags().String(flagKeyName, "", "name of the key in the backend, defaults to the name")
+	keyOffline.Flags().Bool(flagAddress, false, "register a bech32 address instead of a public key")
+	keyMulti.Flags().Int(flagThreshold, 1, "number of the keys that must sign")
 	keysCmd.AddCommand(keysGet)
 	keysCmd.AddCommand(keysPost)
 	keysCmd.AddCommand(keyGet)
 	keysCmd.AddCommand(keyPut)
 	keysCmd.AddCommand(keyDelete)
+	keysCmd.AddCommand(keyExport)
+	keysCmd.AddCommand(keyImport)
+	keysCmd.AddCommand(keyOffline)
+	keysCmd.AddCommand(keyMulti)
+	keysCmd.AddCommand(keyRemote)
+	keysCmd.AddCommand(keyLabel)
+	keysCmd.AddCommand(keysDerive)
+	keysCmd.AddCommand(keyRename)
+	keyBackup.AddCommand(keyBackupConfirm)
+	keyBackup.AddCommand(keyBackupReveal)
+	keysCmd.AddCommand(keyBackup)
+	addPasswordFlags(keysCmd)
+	addClientFlags(keysCmd)
 	rootCmd.AddCommand(keysCmd)
 }
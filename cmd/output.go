@@ -0,0 +1,173 @@
+// Copyright © 2018 Jack Zampolin <jack@blockstack.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/jackzampolin/keyserver/api"
+	"github.com/jackzampolin/keyserver/client"
+)
+
+const (
+	flagOutput = "output"
+	flagQuiet  = "quiet"
+
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+)
+
+var (
+	// outputFormat is set with --output, commands print json unless it is set
+	outputFormat string
+
+	// quietOutput is set with --quiet, commands only print addresses or tx hashes
+	quietOutput bool
+)
+
+// validateOutput checks the output flags before the command runs
+func validateOutput() error {
+	switch outputFormat {
+	case "", outputJSON, outputYAML, outputTable:
+	default:
+		return fmt.Errorf("invalid output %s, must be json, yaml or table", outputFormat)
+	}
+	if quietOutput && outputFormat != "" {
+		return fmt.Errorf("--quiet can't be combined with --output")
+	}
+	return nil
+}
+
+// printOutput prints the result of a command in the selected format. Tables
+// are printed for keys, other results are printed as json. In quiet mode
+// only the addresses of keys are printed, along with generated mnemonics.
+func printOutput(v interface{}) {
+	if quietOutput {
+		printQuiet(v)
+		return
+	}
+	if outputFormat == outputTable && printTable(v) {
+		return
+	}
+
+	bz, err := json.Marshal(v)
+	if err != nil {
+		fatal("failed encoding output", "err", err)
+	}
+	printEncoded(bz)
+}
+
+// printTx prints a transaction's json or an amino encoded broadcast result,
+// only the hash of broadcast transactions is printed in quiet mode
+func printTx(c *client.Client, v interface{}) {
+	if res, ok := v.(sdk.TxResponse); ok && quietOutput {
+		fmt.Println(res.TxHash)
+		return
+	}
+
+	bz, ok := v.(json.RawMessage)
+	if !ok {
+		var err error
+		if bz, err = c.Codec().MarshalJSON(v); err != nil {
+			fatal("failed encoding output", "err", err)
+		}
+	}
+	if quietOutput {
+		fmt.Println(string(bz))
+		return
+	}
+	printEncoded(bz)
+}
+
+// printEncoded prints json in the selected format
+func printEncoded(bz []byte) {
+	if outputFormat == outputYAML {
+		out, err := configYAML(json.RawMessage(bz))
+		if err != nil {
+			fatal("failed encoding output", "err", err)
+		}
+		fmt.Print(string(out))
+		return
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, bz, "", "  "); err != nil {
+		fatal("failed encoding output", "err", err)
+	}
+	fmt.Println(out.String())
+}
+
+func printQuiet(v interface{}) {
+	switch v := v.(type) {
+	case api.KeyOutput:
+		fmt.Println(v.Address)
+		// generated mnemonics are only returned once
+		if v.Mnemonic != "" {
+			fmt.Println(v.Mnemonic)
+		}
+	case []api.KeyOutput:
+		for _, ko := range v {
+			fmt.Println(ko.Address)
+		}
+	case []api.DerivedKey:
+		for _, dk := range v {
+			fmt.Println(dk.Address)
+		}
+	case consensusKey:
+		fmt.Println(v.Address)
+	}
+}
+
+// printTable prints keys as a table, it returns false for other results
+func printTable(v interface{}) bool {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	switch v := v.(type) {
+	case api.KeyOutput:
+		printKeysTable(w, []api.KeyOutput{v})
+		if v.Mnemonic != "" {
+			fmt.Fprintf(w, "\nmnemonic: %s\n", v.Mnemonic)
+		}
+	case []api.KeyOutput:
+		printKeysTable(w, v)
+	case []api.DerivedKey:
+		fmt.Fprintln(w, "NAME\tHD PATH\tADDRESS")
+		for _, dk := range v {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", dk.Name, dk.HDPath, dk.Address)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func printKeysTable(w *tabwriter.Writer, keys []api.KeyOutput) {
+	fmt.Fprintln(w, "NAME\tTYPE\tADDRESS\tLABELS\tBACKUP")
+	for _, ko := range keys {
+		backup := ""
+		if ko.BackupUnconfirmed {
+			backup = "unconfirmed"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", ko.Name, ko.Type, ko.Address, strings.Join(ko.Labels, ","), backup)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright © 2018 Jack Zampolin <jack@blockstack.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bgentry/speakeasy"
+	isatty "github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagPasswordFile  = "password-file"
+	flagPasswordStdin = "password-stdin"
+)
+
+var (
+	// passwordLines are the passwords read from --password-file or --password-stdin
+	passwordLines []string
+
+	// passwordsLoaded is set once passwordLines has been read
+	passwordsLoaded bool
+
+	// positionalWarned is set once the deprecation of positional passwords has been logged
+	positionalWarned bool
+)
+
+// readPassword returns the next password for cmd. With --password-file or
+// --password-stdin every password is a line of the file or of stdin, in the
+// order the command asks for them. Otherwise it prompts on the terminal
+// without echoing, twice when confirm is set.
+func readPassword(cmd *cobra.Command, prompt string, confirm bool) string {
+	file, _ := cmd.Flags().GetString(flagPasswordFile)
+	stdin, _ := cmd.Flags().GetBool(flagPasswordStdin)
+	if file != "" && stdin {
+		fatal("pass either --password-file or --password-stdin")
+	}
+
+	if file != "" || stdin {
+		if !passwordsLoaded {
+			passwordLines = loadPasswords(file)
+			passwordsLoaded = true
+		}
+		if len(passwordLines) == 0 {
+			fatal("not enough passwords, put each password the command asks for on its own line", "prompt", prompt)
+		}
+		pass := passwordLines[0]
+		passwordLines = passwordLines[1:]
+		return pass
+	}
+
+	if !isatty.IsTerminal(os.Stdin.Fd()) && !isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		fatal("stdin isn't a terminal, pass --password-file or --password-stdin", "prompt", prompt)
+	}
+	pass, err := speakeasy.FAsk(os.Stderr, prompt+": ")
+	if err != nil {
+		fatal("failed reading password", "err", err)
+	}
+	if confirm {
+		again, err := speakeasy.FAsk(os.Stderr, "Repeat "+strings.ToLower(prompt[:1])+prompt[1:]+": ")
+		if err != nil {
+			fatal("failed reading password", "err", err)
+		}
+		if pass != again {
+			fatal("passwords don't match")
+		}
+	}
+	return pass
+}
+
+// argPassword returns args[i] when the password or mnemonic was passed as an argument,
+// which is deprecated, and reads it with readPassword otherwise
+func argPassword(cmd *cobra.Command, args []string, i int, prompt string, confirm bool) string {
+	if len(args) > i {
+		if !positionalWarned {
+			positionalWarned = true
+			logger.Error("passing passwords and mnemonics as arguments is deprecated since they end up in the shell history and process list, use the prompt, --password-file or --password-stdin", "command", cmd.CommandPath())
+		}
+		return args[i]
+	}
+	return readPassword(cmd, prompt, confirm)
+}
+
+func loadPasswords(file string) []string {
+	var r io.Reader = os.Stdin
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			fatal("failed opening password file", "file", file, "err", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSuffix(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		fatal("failed reading passwords", "err", err)
+	}
+	return lines
+}
+
+// addPasswordFlags adds the password source flags to the commands
+func addPasswordFlags(cmds ...*cobra.Command) {
+	for _, cmd := range cmds {
+		cmd.PersistentFlags().String(flagPasswordFile, "", "read passwords from this file, one per line in the order the command asks for them")
+		cmd.PersistentFlags().Bool(flagPasswordStdin, false, "read passwords from stdin, one per line in the order the command asks for them")
+	}
+}
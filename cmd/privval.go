@@ -0,0 +1,151 @@
+// Copyright © 2018 Jack Zampolin <jack@blockstack.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/jackzampolin/keyserver/api"
+	"github.com/spf13/cobra"
+	"github.com/tendermint/tendermint/privval"
+)
+
+const (
+	flagLaddr   = "laddr"
+	flagChainID = "chain-id"
+	flagState   = "state"
+	flagNoState = "no-state"
+)
+
+// privvalCmd represents the privval command
+var privvalCmd = &cobra.Command{
+	Use:   "privval",
+	Short: "Sign consensus messages for a tendermint node with a key held by the keyserver",
+}
+
+// privvalRun connects to the node and signs until interrupted
+var privvalRun = &cobra.Command{
+	Use:   "run [name]",
+	Args:  cobra.ExactArgs(1),
+	Short: "Connect to a node's priv_validator_laddr and sign its votes and proposals, the key is generated if it doesn't exist",
+	Run: func(cmd *cobra.Command, args []string) {
+		laddr, _ := cmd.Flags().GetString(flagLaddr)
+		chainID, _ := cmd.Flags().GetString(flagChainID)
+		if laddr == "" || chainID == "" {
+			fatal("--laddr and --chain-id are required")
+		}
+
+		// the lock is held until the process exits
+		if _, err := server.LockPrivValidator(args[0]); err != nil {
+			fatal("failed locking consensus key", "name", args[0], "err", err)
+		}
+
+		pv, err := server.PrivValidator(args[0])
+		if err != nil {
+			fatal("failed loading consensus key", "name", args[0], "err", err)
+		}
+		key := newConsensusKey(args[0], pv)
+		logger.Info("consensus key", "name", key.Name, "address", key.Address, "pub_key", key.PubKey,
+			"height", key.Height, "round", key.Round, "step", key.Step)
+
+		stop := make(chan struct{})
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			close(stop)
+		}()
+
+		if err = api.ServePrivValidator(logger.With("module", "privval"), pv, chainID, laddr, stop); err != nil {
+			fatal("signer stopped", "err", err)
+		}
+	},
+}
+
+// privvalShow prints the consensus key
+var privvalShow = &cobra.Command{
+	Use:   "show [name]",
+	Args:  cobra.ExactArgs(1),
+	Short: "Show the consensus address and public key, the key is generated if it doesn't exist",
+	Run: func(cmd *cobra.Command, args []string) {
+		pv, err := server.PrivValidator(args[0])
+		if err != nil {
+			fatal("failed loading consensus key", "name", args[0], "err", err)
+		}
+		printOutput(newConsensusKey(args[0], pv))
+	},
+}
+
+// privvalImport imports a tendermint priv_validator_key.json
+var privvalImport = &cobra.Command{
+	Use:   "import [name] [priv_validator_key.json]",
+	Args:  cobra.ExactArgs(2),
+	Short: "Import a node's consensus key with its priv_validator_state.json passed as --state, which keeps its double signing protection",
+	Run: func(cmd *cobra.Command, args []string) {
+		state, _ := cmd.Flags().GetString(flagState)
+		noState, _ := cmd.Flags().GetBool(flagNoState)
+		if (state == "") == !noState {
+			fatal("pass the node's priv_validator_state.json with --state, or --no-state to start the key at height 0")
+		}
+		if err := server.ImportPrivValidator(args[0], args[1], state); err != nil {
+			fatal("failed importing consensus key", "name", args[0], "err", err)
+		}
+
+		pv, err := server.PrivValidator(args[0])
+		if err != nil {
+			fatal("failed loading consensus key", "name", args[0], "err", err)
+		}
+		printOutput(newConsensusKey(args[0], pv))
+	},
+}
+
+// consensusKey is the consensus key printed by privval show and import
+type consensusKey struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	PubKey  string `json:"pub_key"`
+	Height  int64  `json:"height"`
+	Round   int    `json:"round"`
+	Step    int8   `json:"step"`
+}
+
+func newConsensusKey(name string, pv *privval.FilePV) consensusKey {
+	pub, err := sdk.Bech32ifyConsPub(pv.GetPubKey())
+	if err != nil {
+		fatal("failed encoding consensus key", "name", name, "err", err)
+	}
+	return consensusKey{
+		Name:    name,
+		Address: sdk.ConsAddress(pv.GetAddress()).String(),
+		PubKey:  pub,
+		Height:  pv.LastSignState.Height,
+		Round:   pv.LastSignState.Round,
+		Step:    pv.LastSignState.Step,
+	}
+}
+
+func init() {
+	privvalRun.Flags().String(flagLaddr, "", "the node's priv_validator_laddr, e.g. tcp://127.0.0.1:26658 or unix:///var/run/privval.sock")
+	privvalRun.Flags().String(flagChainID, "", "chain ID the votes and proposals are signed for")
+	privvalImport.Flags().String(flagState, "", "the node's priv_validator_state.json")
+	privvalImport.Flags().Bool(flagNoState, false, "start the key without a last sign state, only safe for keys that never signed")
+	privvalCmd.AddCommand(privvalRun)
+	privvalCmd.AddCommand(privvalShow)
+	privvalCmd.AddCommand(privvalImport)
+	rootCmd.AddCommand(privvalCmd)
+}
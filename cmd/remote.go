@@ -0,0 +1,92 @@
+// Copyright © 2018 Jack Zampolin <jack@blockstack.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/jackzampolin/keyserver/client"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+const (
+	flagServer = "server"
+
+	// envServer selects the keyserver when --server isn't passed
+	envServer = "KEYSERVER_SERVER"
+)
+
+// remote is a named keyserver in the remotes section of the config, commands
+// target it with --server name
+type remote struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Token  string `json:"token,omitempty"`
+	CAFile string `json:"ca_file,omitempty"`
+}
+
+// remotes returns the remote profiles in the config
+func remotes() (out []remote) {
+	err := viper.UnmarshalKey("remotes", &out, func(dc *mapstructure.DecoderConfig) { dc.TagName = "json" })
+	if err != nil {
+		fatal("invalid remotes in config", "err", err)
+	}
+	return out
+}
+
+// target returns the keyserver selected by --server, KEYSERVER_SERVER or the
+// server entry of the config, which is either a url or the name of a remote.
+// It defaults to the local keyserver on the configured port.
+func target() remote {
+	name := viper.GetString(flagServer)
+	if name == "" {
+		return remote{Name: "local", URL: fmt.Sprintf("http://localhost:%d", server.Port)}
+	}
+	for _, r := range remotes() {
+		if r.Name == name {
+			return r
+		}
+	}
+	if strings.Contains(name, "://") {
+		return remote{URL: name}
+	}
+	fatal("unknown remote, pass a url or the name of a remote in the config", "server", name)
+	return remote{}
+}
+
+// options returns the client options for the remote
+func (r remote) options() ([]client.Option, error) {
+	var opts []client.Option
+	if r.Token != "" {
+		opts = append(opts, client.WithBearerToken(r.Token))
+	}
+	if r.CAFile != "" {
+		pem, err := ioutil.ReadFile(r.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", r.CAFile)
+		}
+		opts = append(opts, client.WithTLSConfig(&tls.Config{RootCAs: pool}))
+	}
+	return opts, nil
+}
@@ -22,6 +22,7 @@ import (
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/tendermint/tendermint/libs/log"
 )
 
 var (
@@ -31,6 +32,9 @@ var (
 	// The actual app config
 	server *api.Server
 
+	// Structured logger configured from the app config
+	logger log.Logger
+
 	// Version for the application. Set via ldflags
 	Version = "undefined"
 
@@ -59,33 +63,61 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.keyserver/config.yaml)")
+	rootCmd.PersistentFlags().String(flagServer, "", "keyserver url or name of a remote in the config, defaults to $"+envServer+", the config's server entry or the local keyserver")
+	viper.BindPFlag(flagServer, rootCmd.PersistentFlags().Lookup(flagServer))
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, flagOutput, "o", "", "output format: json, yaml or table, defaults to json")
+	rootCmd.PersistentFlags().BoolVarP(&quietOutput, flagQuiet, "q", false, "only print addresses or tx hashes")
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
 func initConfig() {
-	server = &api.Server{
-		Version: Version,
-		Commit:  Commit,
-		Branch:  Branch,
-	}
+	// log with the defaults until the config is loaded
+	logger, _ = api.NewLogger(os.Stderr, "", "")
+
 	if cfgFile != "" {
 		viper.SetConfigFile(cfgFile)
 	} else {
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			fatal("error finding homedir", "err", err)
 		}
 
-		// viper.AddConfigPath(home)
 		viper.AddConfigPath(fmt.Sprintf("%s/.keyserver/", home))
 		viper.SetConfigName("config")
 	}
+	bindConfig(viper.GetViper())
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// a missing default config file is fine, an unreadable one is not
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok || cfgFile != "" {
+			fatal("failed reading config", "file", viper.ConfigFileUsed(), "err", err)
+		}
+	}
+	s, err := decodeConfig(viper.GetViper())
+	if err != nil {
+		fatal("invalid config", "file", viper.ConfigFileUsed(), "err", err)
+	}
+	s.Version, s.Commit, s.Branch = Version, Commit, Branch
+	server = s
 
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		viper.Unmarshal(&server)
+	level := server.LogLevel
+	if quietOutput {
+		level = "error"
 	}
+	l, err := api.NewLogger(os.Stderr, server.LogFormat, level)
+	if err != nil {
+		fatal("invalid logging config", "err", err)
+	}
+	logger = l
+	server.SetLogger(logger)
+
+	if err := validateOutput(); err != nil {
+		fatal("invalid output flags", "err", err)
+	}
+}
+
+// fatal logs msg with keyvals at the error level and exits
+func fatal(msg string, keyvals ...interface{}) {
+	logger.Error(msg, keyvals...)
+	os.Exit(1)
 }
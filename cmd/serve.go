@@ -16,12 +16,17 @@ package cmd
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
-	"github.com/gorilla/handlers"
+	"github.com/fsnotify/fsnotify"
+	"github.com/jackzampolin/keyserver/api"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // serveCmd represents the serve command
@@ -29,12 +34,118 @@ var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Runs the server",
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := server.Validate(); err != nil {
+			fatal("invalid configuration", "err", err)
+		}
+		if err := server.CreateKeyDir(); err != nil {
+			fatal("invalid configuration", "err", err)
+		}
 
-		log.Println(fmt.Sprintf("Listening on port ':%v'...", server.Port))
-		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", server.Port), handlers.LoggingHandler(os.Stdout, server.Router())))
+		if err := server.OpenKeystore(); err != nil {
+			fatal("failed opening keystore", "type", server.Keystore.Type, "err", err)
+		}
+
+		go watchConfig()
+		go stopOnSignal()
+
+		logger.Info("listening", "port", server.Port, "node", server.Node, "key_dir", server.KeyDir, "keystore", server.Keystore.Type)
+		err := http.ListenAndServe(fmt.Sprintf(":%v", server.Port), server.Router())
+		api.CloseHSM()
+		fatal("server stopped", "err", err)
 	},
 }
 
+// stopOnSignal logs out of the hsm tokens and finalizes their modules before
+// exiting on SIGINT or SIGTERM
+func stopOnSignal() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	sig := <-stop
+	api.CloseHSM()
+	server.Logger().Info("stopping", "signal", sig)
+	os.Exit(0)
+}
+
+// watchConfig reloads the config when the config file changes or on SIGHUP,
+// it logs with the server's logger as the reloads replace it
+func watchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	file := viper.ConfigFileUsed()
+	real, _ := filepath.EvalSymlinks(file)
+	if file != "" {
+		// watch the directory, editors and config maps replace the file
+		w, err := fsnotify.NewWatcher()
+		if err == nil {
+			err = w.Add(filepath.Dir(file))
+		}
+		if err != nil {
+			server.Logger().Error("failed watching config, reload it with SIGHUP", "file", file, "err", err)
+		} else {
+			events, errs = w.Events, w.Errors
+		}
+	}
+
+	// editors write in several steps, reload once the file settles
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-hup:
+			reloadConfig("sighup")
+		case ev := <-events:
+			current, _ := filepath.EvalSymlinks(file)
+			if filepath.Clean(ev.Name) == filepath.Clean(file) || current != real {
+				real = current
+				settled = time.After(100 * time.Millisecond)
+			}
+		case <-settled:
+			settled = nil
+			reloadConfig("file changed")
+		case err := <-errs:
+			server.Logger().Error("failed watching config", "file", file, "err", err)
+		}
+	}
+}
+
+// reloadConfig reads the config again and applies it to the server, the
+// current config is kept if the new one is invalid
+func reloadConfig(reason string) {
+	file := viper.ConfigFileUsed()
+	restart, err := server.Reload(func() (*api.Server, error) {
+		v := viper.New()
+		bindConfig(v)
+		if file != "" {
+			v.SetConfigFile(file)
+			if err := v.ReadInConfig(); err != nil {
+				return nil, err
+			}
+		}
+		s, err := decodeConfig(v)
+		if err != nil {
+			return nil, err
+		}
+		l, err := api.NewLogger(os.Stderr, s.LogFormat, s.LogLevel)
+		if err != nil {
+			return nil, err
+		}
+		s.SetLogger(l)
+		return s, nil
+	})
+	if err != nil {
+		server.Logger().Error("config reload failed, keeping the current config", "reason", reason, "file", file, "err", err)
+		return
+	}
+
+	logger := server.Logger()
+	logger.Info("config reloaded", "reason", reason, "file", file)
+	if len(restart) > 0 {
+		logger.Error("config changes need a restart to apply", "settings", restart)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(serveCmd)
 }
@@ -15,15 +15,25 @@
 package cmd
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"net/http"
+	"os"
 
-	sdk "github.com/cosmos/cosmos-sdk/types"
 	"github.com/jackzampolin/keyserver/api"
+	"github.com/jackzampolin/keyserver/client"
+	isatty "github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
+	"github.com/tendermint/tendermint/libs/bech32"
+)
+
+const (
+	flagMemo          = "memo"
+	flagFees          = "fees"
+	flagGasPrices     = "gas-prices"
+	flagGasAdjustment = "gas-adjustment"
+	flagAccountNumber = "account-number"
+	flagSequence      = "sequence"
 )
 
 var txCmd = &cobra.Command{
@@ -37,145 +47,214 @@ var bankCmd = &cobra.Command{
 }
 
 var broadcastCmd = &cobra.Command{
-	Use:   "broadcast [file]",
-	Short: "broadcast a signed transaction",
-	Args:  cobra.ExactArgs(1),
+	Use:   "broadcast [tx-file]",
+	Short: "broadcast a signed transaction, read from stdin when the file is omitted or -",
+	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		txData, err := ioutil.ReadFile(args[0])
-		if err != nil {
-			log.Fatal("error reading transaction file")
-		}
-		url := fmt.Sprintf("http://localhost:%d/tx/broadcast", server.Port)
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(txData))
-		if err != nil {
-			log.Fatalf("error fetching %s", url)
-			return
-		}
-		out, err := ioutil.ReadAll(resp.Body)
+		c := newClient()
+		tx := readTx(cmd, args, 0)
+		res, err := c.Broadcast(tx)
 		if err != nil {
-			log.Fatalf("failed reading response body")
-			return
+			fatalRequest("failed broadcasting transaction", err)
 		}
-		if resp.StatusCode != 200 {
-			log.Fatalf("non 200 respose code %d, error: %s", resp.StatusCode, string(out))
-			return
-		}
-		fmt.Println(string(out))
+		printTx(c, res)
 	},
 }
 
 // versionCmd represents the version command
 var sendCmd = &cobra.Command{
-	Use:   "send [sender] [reciever] [amount] [chain-id] [memo] [fees] [gas-adjustment]",
-	Short: "generate a send transaction",
-	Args:  cobra.RangeArgs(4, 7),
+	Use:   "send [sender] [receiver] [amount]",
+	Short: "generate a send transaction, the sender and receiver are key names or addresses",
+	Args:  cobra.RangeArgs(3, 7),
 	Run: func(cmd *cobra.Command, args []string) {
-		var bs api.BankSendBody
-		send, err := sdk.AccAddressFromBech32(args[0])
-		if err != nil {
-			return
-		}
-		recv, err := sdk.AccAddressFromBech32(args[1])
-		if err != nil {
-			return
+		c := newClient()
+		bs := api.BankSendBody{
+			Sender:   accAddress(c, args[0], "sender"),
+			Reciever: accAddress(c, args[1], "receiver"),
+			Amount:   args[2],
 		}
+		bs.ChainID, _ = cmd.Flags().GetString(flagChainID)
+		bs.Memo, _ = cmd.Flags().GetString(flagMemo)
+		bs.Fees, _ = cmd.Flags().GetString(flagFees)
+		bs.GasPrices, _ = cmd.Flags().GetString(flagGasPrices)
+		bs.GasAdjustment, _ = cmd.Flags().GetString(flagGasAdjustment)
 
-		switch len(args) {
-		case 4:
-			bs = api.BankSendBody{
-				Sender:   send,
-				Reciever: recv,
-				Amount:   args[2],
-				ChainID:  args[3],
-			}
-		case 5:
-			bs = api.BankSendBody{
-				Sender:   send,
-				Reciever: recv,
-				Amount:   args[2],
-				ChainID:  args[3],
-				Memo:     args[4],
-			}
-		case 6:
-			bs = api.BankSendBody{
-				Sender:   send,
-				Reciever: recv,
-				Amount:   args[2],
-				ChainID:  args[3],
-				Memo:     args[4],
-				Fees:     args[5],
-			}
-		case 7:
-			bs = api.BankSendBody{
-				Sender:        send,
-				Reciever:      recv,
-				Amount:        args[2],
-				ChainID:       args[3],
-				Memo:          args[4],
-				Fees:          args[5],
-				GasAdjustment: args[6],
-			}
+		// the deprecated form takes [chain-id] [memo] [fees] [gas-adjustment] after the amount
+		if len(args) > 3 {
+			warnPositional(cmd, "--chain-id, --memo, --fees and --gas-adjustment")
+			bs.ChainID = args[3]
 		}
-		url := fmt.Sprintf("http://localhost:%d/tx/bank/send", server.Port)
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(bs.Marshal()))
-		if err != nil {
-			log.Fatalf("error fetching %s", url)
-			return
+		if len(args) > 4 {
+			bs.Memo = args[4]
+		}
+		if len(args) > 5 {
+			bs.Fees = args[5]
+		}
+		if len(args) > 6 {
+			bs.GasAdjustment = args[6]
 		}
 
-		out, err := ioutil.ReadAll(resp.Body)
+		tx, err := c.BankSend(bs)
 		if err != nil {
-			log.Fatalf("failed reading response body")
-			return
+			fatalRequest("failed generating send transaction", err)
 		}
-		fmt.Println(string(out))
-
+		printTx(c, tx)
 	},
 }
 
-// /keys GET
+// /tx/sign POST
 var txSign = &cobra.Command{
-	Use:   "sign [name] [password] [chain-id] [account-number] [sequence] [tx-file]",
-	Args:  cobra.ExactArgs(6),
-	Short: "Sign a transaction",
+	Use:   "sign [name] [tx-file]",
+	Args:  cobra.RangeArgs(1, 6),
+	Short: "Sign a transaction, read from stdin when the file is omitted or -",
 	Run: func(cmd *cobra.Command, args []string) {
-		txData, err := ioutil.ReadFile(args[5])
-		if err != nil {
-			log.Fatal("error reading transaction file")
+		postData := api.SignBody{Name: args[0]}
+		postData.ChainID, _ = cmd.Flags().GetString(flagChainID)
+		postData.AccountNumber, _ = cmd.Flags().GetString(flagAccountNumber)
+		postData.Sequence, _ = cmd.Flags().GetString(flagSequence)
+
+		// the deprecated forms take [chain-id] [account-number] [sequence] [tx-file]
+		// after the name, the oldest one with the password before them
+		switch len(args) {
+		case 1, 2:
+		case 5, 6:
+			warnPositional(cmd, "--chain-id, --account-number and --sequence")
+			if len(args) == 6 {
+				postData.Passphrase = argPassword(cmd, args, 1, "Password", false)
+				args = append(args[:1], args[2:]...)
+			}
+			postData.ChainID, postData.AccountNumber, postData.Sequence = args[1], args[2], args[3]
+			args = []string{args[0], args[4]}
+		default:
+			fatal("pass the tx file after the name, and the chain id, account number and sequence as flags", "args", len(args))
+		}
+		if postData.AccountNumber == "" || postData.Sequence == "" {
+			fatal("--account-number and --sequence are required")
+		}
+		if postData.ChainID == "" && chainName == "" {
+			fatal("--chain-id is required unless --chain selects a chain profile with a chain_id")
 		}
 
-		postData := api.SignBody{
-			Name:          args[0],
-			Passphrase:    args[1],
-			ChainID:       args[2],
-			AccountNumber: args[3],
-			Sequence:      args[4],
-			Tx:            txData,
+		c := newClient()
+		tx := readTx(cmd, args, 1)
+		if postData.Passphrase == "" {
+			postData.Passphrase = readPassword(cmd, "Password", false)
 		}
+		postData.Tx = tx
 
-		url := fmt.Sprintf("http://localhost:%d/tx/sign", server.Port)
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(postData.Marshal()))
+		signed, err := c.Sign(postData)
 		if err != nil {
-			log.Fatalf("error fetching %s", url)
-			return
+			fatalRequest("failed signing transaction", err)
 		}
-		out, err := ioutil.ReadAll(resp.Body)
+		printTx(c, signed)
+	},
+}
+
+// /tx/verify POST
+var txVerify = &cobra.Command{
+	Use:   "verify [tx-file]",
+	Args:  cobra.RangeArgs(0, 1),
+	Short: "Verify the signatures of a transaction, read from stdin when the file is omitted or -",
+	Run: func(cmd *cobra.Command, args []string) {
+		vb := api.VerifyBody{}
+		vb.ChainID, _ = cmd.Flags().GetString(flagChainID)
+		accounts, _ := cmd.Flags().GetStringSlice(flagAccountNumber)
+		sequences, _ := cmd.Flags().GetStringSlice(flagSequence)
+		if len(accounts) == 0 || len(accounts) != len(sequences) {
+			fatal("--account-number and --sequence are required once for every signer")
+		}
+		if vb.ChainID == "" && chainName == "" {
+			fatal("--chain-id is required unless --chain selects a chain profile with a chain_id")
+		}
+		for i := range accounts {
+			vb.Accounts = append(vb.Accounts, api.VerifyAccount{AccountNumber: accounts[i], Sequence: sequences[i]})
+		}
+
+		c := newClient()
+		vb.Tx = readTx(cmd, args, 0)
+
+		res, err := c.Verify(vb)
 		if err != nil {
-			log.Fatalf("failed reading response body")
-			return
+			fatalRequest("failed verifying transaction", err)
 		}
-		if resp.StatusCode != 200 {
-			log.Fatalf("non 200 respose code %d, error: %s", resp.StatusCode, string(out))
-			return
+		printOutput(res)
+		if !res.Valid {
+			os.Exit(1)
 		}
-		fmt.Println(string(out))
 	},
 }
 
+// readTx reads the transaction json in the file at args[i], or on stdin when
+// the file is omitted or -. It is passed to the keyserver as is, since its
+// addresses have the prefixes of the selected chain.
+func readTx(cmd *cobra.Command, args []string, i int) json.RawMessage {
+	file := "-"
+	if len(args) > i {
+		file = args[i]
+	}
+
+	var txData []byte
+	var err error
+	if file == "-" {
+		if isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+			fatal("pass a transaction file or pipe the transaction on stdin")
+		}
+		if stdin, _ := cmd.Flags().GetBool(flagPasswordStdin); stdin {
+			fatal("the transaction and passwords can't both be read from stdin, use --password-file")
+		}
+		txData, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		txData, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		fatal("error reading transaction", "file", file, "err", err)
+	}
+	if !json.Valid(txData) {
+		fatal("error decoding transaction, it isn't json", "file", file)
+	}
+	return txData
+}
+
+// accAddress returns arg if it is a bech32 address, or looks up the address
+// of the key it names
+func accAddress(c *client.Client, arg, role string) string {
+	if _, _, err := bech32.DecodeAndConvert(arg); err == nil {
+		return arg
+	}
+	key, err := c.Key(arg, "")
+	if client.IsNotFound(err) {
+		fatal(fmt.Sprintf("%s is neither an address nor a key name", role), role, arg)
+	} else if err != nil {
+		fatalRequest(fmt.Sprintf("failed looking up %s", role), err)
+	}
+	return key.Address
+}
+
+// warnPositional logs that the positional form of cmd is deprecated in favor of flags
+func warnPositional(cmd *cobra.Command, flags string) {
+	logger.Error("passing these values as arguments is deprecated, use "+flags, "command", cmd.CommandPath())
+}
+
 func init() {
+	sendCmd.Flags().String(flagChainID, "", "chain id, defaults to the chain profile's")
+	sendCmd.Flags().String(flagMemo, "", "memo of the transaction")
+	sendCmd.Flags().String(flagFees, "", "fees to pay, e.g. 500uatom")
+	sendCmd.Flags().String(flagGasPrices, "", "gas prices to compute fees from when --fees is unset, defaults to the chain profile's")
+	sendCmd.Flags().String(flagGasAdjustment, "", "factor to multiply the simulated gas by")
+	txSign.Flags().String(flagChainID, "", "chain id to sign for, required unless the --chain profile has a chain_id")
+	txSign.Flags().String(flagAccountNumber, "", "account number of the signer")
+	txSign.Flags().String(flagSequence, "", "sequence of the signer")
+
+	txVerify.Flags().String(flagChainID, "", "chain id the transaction was signed for, required unless the --chain profile has a chain_id")
+	txVerify.Flags().StringSlice(flagAccountNumber, nil, "account number of each signer, in the order of the signers")
+	txVerify.Flags().StringSlice(flagSequence, nil, "sequence of each signer, in the order of the signers")
+
 	txCmd.AddCommand(txSign)
+	txCmd.AddCommand(txVerify)
 	txCmd.AddCommand(bankCmd)
 	txCmd.AddCommand(broadcastCmd)
 	bankCmd.AddCommand(sendCmd)
+	addPasswordFlags(txCmd)
+	addClientFlags(txCmd)
 	rootCmd.AddCommand(txCmd)
 }
@@ -3,15 +3,25 @@ module github.com/jackzampolin/keyserver
 go 1.12
 
 require (
+	github.com/bgentry/speakeasy v0.1.0
+	github.com/btcsuite/btcd v0.0.0-20190523000118-16327141da8c
 	github.com/cosmos/cosmos-sdk v0.36.0
 	github.com/cosmos/gaia v1.0.0
 	github.com/cosmos/go-bip39 v0.0.0-20180819234021-555e2067c45d
-	github.com/gorilla/handlers v1.4.0
+	github.com/fsnotify/fsnotify v1.4.7
+	github.com/go-kit/kit v0.9.0
 	github.com/gorilla/mux v1.7.3
+	github.com/mattn/go-isatty v0.0.8
+	github.com/miekg/pkcs11 v1.1.1
 	github.com/mitchellh/go-homedir v1.1.0
+	github.com/mitchellh/mapstructure v1.1.2
+	github.com/pkg/errors v0.8.1
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/viper v1.4.0
 	github.com/stretchr/testify v1.3.0
+	github.com/tendermint/crypto v0.0.0-20180820045704-3764759f34a5
+	github.com/tendermint/go-amino v0.15.0
 	github.com/tendermint/tendermint v0.32.2
+	github.com/tendermint/tm-db v0.1.1
 	gopkg.in/yaml.v2 v2.2.2
 )